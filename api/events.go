@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ckaminer/jobcoin/mixerlib"
+	"github.com/gorilla/mux"
+)
+
+// StatusEvent is the name of the first event sent by UserEventsHandler, whose
+// data is the user's mixerlib.UserStatus.
+const StatusEvent = "status"
+
+// keepAliveInterval is how often a comment is sent on an idle event stream so
+// that proxies do not close it.
+const keepAliveInterval = 15 * time.Second
+
+// UserEventsHandler returns a HandlerFunc streaming the progress of a user's
+// funds as Server-Sent Events. The user is identified by the depositAddress
+// path variable. The stream starts with a StatusEvent holding the user's
+// current status, followed by a mixerlib.Event, named after its kind, each time
+// something happens to their funds. It ends when the client disconnects or the
+// mixer's Events are closed.
+func UserEventsHandler(ml *mixerlib.MixerLib) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		depositAddress := mux.Vars(r)["depositAddress"]
+		logger := LoggerFrom(r.Context()).With("depositAddress", depositAddress)
+
+		flusher, ok := w.(http.Flusher)
+		if !ok || ml.Events == nil {
+			respondWithJSON(w, http.StatusNotImplemented, ErrorPayload{"Event streaming is not supported"})
+			return
+		}
+
+		// Subscribe before reading the status so nothing that happens in
+		// between is missed.
+		events, unsubscribe := ml.Events.Subscribe(depositAddress)
+		defer unsubscribe()
+
+		status, err := ml.UserStatus(depositAddress)
+		if err == mixerlib.ErrUserNotFound {
+			respondWithJSON(w, http.StatusNotFound, ErrorPayload{err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to load user status", "error", err)
+			respondWithJSON(w, http.StatusInternalServerError, ErrorPayload{"Failed to load user status"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		writeEvent(w, StatusEvent, status)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				writeEvent(w, string(event.Kind), event)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes data, encoded as JSON, as a Server-Sent Event with the given name.
+func writeEvent(w http.ResponseWriter, name string, data interface{}) {
+	encoded, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ckaminer/jobcoin/mixerlib"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEventsServer(t *testing.T, ml *mixerlib.MixerLib) *httptest.Server {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/users/{depositAddress}/events", UserEventsHandler(ml)).Methods("GET")
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUserEventsHandler_StreamsStatusUntilEventsClosed(t *testing.T) {
+	ml := &mixerlib.MixerLib{Store: mixerlib.NewMemoryStore(), Events: mixerlib.NewEvents()}
+	ml.Store.AddUser(mixerlib.MixerUser{DepositAddress: "1234abcd", ReturnAddresses: []string{"1111aaaa"}})
+	server := newEventsServer(t, ml)
+
+	res, err := http.Get(server.URL + "/api/users/1234abcd/events")
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(res.Body)
+	name, _ := reader.ReadString('\n')
+	data, _ := reader.ReadString('\n')
+	assert.Equal(t, "event: status\n", name)
+	assert.True(t, strings.HasPrefix(data, `data: {"depositAddress":"1234abcd","state":"awaiting_deposit"`))
+
+	ml.Events.Close()
+	rest, _ := reader.ReadString(0)
+	assert.Equal(t, "\n", rest)
+}
+
+func TestUserEventsHandler_ReturnsNotFoundForUnknownUser(t *testing.T) {
+	ml := &mixerlib.MixerLib{Store: mixerlib.NewMemoryStore(), Events: mixerlib.NewEvents()}
+	server := newEventsServer(t, ml)
+
+	res, err := http.Get(server.URL + "/api/users/1234abcd/events")
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
@@ -2,12 +2,12 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
+	"time"
 
 	"github.com/ckaminer/jobcoin/mixerlib"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 )
 
 // ErrorPayload represents the error that will returned by the API.
@@ -16,39 +16,97 @@ type ErrorPayload struct {
 }
 
 // CreateNewUserHandler returns a HandlerFunc to handle the creation of users.
-// It accepts a channel to be used in the resulting HanderFunc
-// HandlerFunc will validate inputs before sending users into the provided userChannel.
-func CreateNewUserHandler(userChan chan mixerlib.MixerUser) http.HandlerFunc {
+// It accepts a registry to be used in the resulting HanderFunc
+// HandlerFunc will validate inputs before registering users with the provided registry.
+func CreateNewUserHandler(registry *mixerlib.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := LoggerFrom(r.Context())
+
 		var user mixerlib.MixerUser
 		err := json.NewDecoder(r.Body).Decode(&user)
 		if err != nil {
-			log.Println("NewUserHandler error: ", err.Error())
+			logger.Warn("Invalid new user request body", "error", err)
 			respondWithJSON(w, http.StatusBadRequest, ErrorPayload{"Invalid request body"})
 			return
 		}
 		defer r.Body.Close()
 
-		if address, valid := mixerlib.ValidUserAddresses(user.ReturnAddresses); !valid {
-			respondWithJSON(w, http.StatusConflict, ErrorPayload{
-				fmt.Sprintf("Return address %s is already in use", address),
-			})
-			return
-		}
-
 		depositAddress, err := uuid.NewUUID()
 		if err != nil {
+			logger.Error("Failed to create deposit address", "error", err)
 			respondWithJSON(w, http.StatusInternalServerError, ErrorPayload{"Failed to create user"})
-			log.Fatal(err)
+			return
 		}
 		user.DepositAddress = depositAddress.String()
+		user.RegisteredAt = time.Now()
 
-		userChan <- user
+		err = registry.Register(user)
+		if inUse, ok := err.(*mixerlib.AddressInUseError); ok {
+			respondWithJSON(w, http.StatusConflict, ErrorPayload{inUse.Error()})
+			return
+		}
+		if err == mixerlib.ErrWebhooksDisabled || err == mixerlib.ErrInvalidWebhookURL || err == mixerlib.ErrPrivateWebhookHost || err == mixerlib.ErrInvalidRefundAddress {
+			respondWithJSON(w, http.StatusBadRequest, ErrorPayload{err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to register user", "depositAddress", user.DepositAddress, "error", err)
+			respondWithJSON(w, http.StatusInternalServerError, ErrorPayload{"Failed to create user"})
+			return
+		}
+		logger.Info("Registered user", "depositAddress", user.DepositAddress)
 
 		respondWithJSON(w, http.StatusCreated, user)
 	}
 }
 
+// GetUserStatusHandler returns a HandlerFunc reporting where a user's funds are
+// in the mixing process. The user is identified by the depositAddress path variable.
+func GetUserStatusHandler(ml *mixerlib.MixerLib) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		depositAddress := mux.Vars(r)["depositAddress"]
+
+		status, err := ml.UserStatus(depositAddress)
+		if err == mixerlib.ErrUserNotFound {
+			respondWithJSON(w, http.StatusNotFound, ErrorPayload{err.Error()})
+			return
+		}
+		if err != nil {
+			LoggerFrom(r.Context()).Error("Failed to load user status", "depositAddress", depositAddress, "error", err)
+			respondWithJSON(w, http.StatusInternalServerError, ErrorPayload{"Failed to load user status"})
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, status)
+	}
+}
+
+// HealthResponse is the body returned by HealthHandler.
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// HealthHandler reports that the process is alive and serving requests.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, HealthResponse{"ok"})
+}
+
+// ReadinessHandler returns a HandlerFunc reporting whether the mixer is able to
+// do its work, with the health of each of its components. It responds with a
+// 503 if any component is unhealthy.
+func ReadinessHandler(ml *mixerlib.MixerLib) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		readiness := ml.Readiness()
+		if !readiness.Ready {
+			LoggerFrom(r.Context()).Warn("Mixer is not ready", "components", readiness.Components)
+			respondWithJSON(w, http.StatusServiceUnavailable, readiness)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, readiness)
+	}
+}
+
 func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 
@@ -6,16 +6,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/ckaminer/jobcoin/clientlib"
 	"github.com/ckaminer/jobcoin/mixerlib"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateNewUserHandler_ReturnsHandlerFuncForNewUsers(t *testing.T) {
-	// Buffer the UserChannel so that it closes once single value comes through
-	// For testing only to avoid hanging tests
-	userChan := make(chan mixerlib.MixerUser, 1)
-	newUserHandlerFunc := CreateNewUserHandler(userChan)
+	registry, _ := mixerlib.NewRegistry(mixerlib.NewMemoryStore())
+	newUserHandlerFunc := CreateNewUserHandler(registry)
 
 	recorder := httptest.NewRecorder()
 	handler := http.HandlerFunc(newUserHandlerFunc)
@@ -44,21 +45,25 @@ func TestCreateNewUserHandler_ReturnsHandlerFuncForNewUsers(t *testing.T) {
 
 	assert.NotEqual(t, "", resBody.DepositAddress)
 	assert.Equal(t, 36, len(resBody.DepositAddress))
+	assert.False(t, resBody.RegisteredAt.IsZero())
 	assert.Equal(t, "return-one", resBody.ReturnAddresses[0])
 	assert.Equal(t, "return-two", resBody.ReturnAddresses[1])
 	assert.Equal(t, "return-three", resBody.ReturnAddresses[2])
+
+	_, valid := registry.ValidUserAddresses([]string{"return-two"})
+	assert.False(t, valid)
 }
 
 func TestCreateNewUserHandler_ReturnsConflictIfInvalidReturnAddress(t *testing.T) {
-	newUserHandlerFunc := CreateNewUserHandler(nil)
-	// Add users to MixerUser to create return address conflict
-	mixerlib.MixerUsers = []mixerlib.MixerUser{
-		{
-			ReturnAddresses: []string{
-				"return-two",
-			},
+	// Register a user to create return address conflict
+	registry, _ := mixerlib.NewRegistry(mixerlib.NewMemoryStore())
+	registry.Register(mixerlib.MixerUser{
+		DepositAddress: "deposit-two",
+		ReturnAddresses: []string{
+			"return-two",
 		},
-	}
+	})
+	newUserHandlerFunc := CreateNewUserHandler(registry)
 
 	recorder := httptest.NewRecorder()
 	handler := http.HandlerFunc(newUserHandlerFunc)
@@ -89,8 +94,50 @@ func TestCreateNewUserHandler_ReturnsConflictIfInvalidReturnAddress(t *testing.T
 	assert.Equal(t, "Return address return-two is already in use", resBody.Message)
 }
 
+func TestCreateNewUserHandler_ReturnsBadRequestIfWebhooksDisabled(t *testing.T) {
+	registry, _ := mixerlib.NewRegistry(mixerlib.NewMemoryStore())
+
+	recorder := httptest.NewRecorder()
+	reqBody := []byte(`{"returnAddresses": ["return-one"], "webhookUrl": "https://example.com/hooks/mixer"}`)
+	r, _ := http.NewRequest("POST", "api/users", bytes.NewReader(reqBody))
+
+	CreateNewUserHandler(registry).ServeHTTP(recorder, r)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	var resBody ErrorPayload
+	err := json.NewDecoder(recorder.Body).Decode(&resBody)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, mixerlib.ErrWebhooksDisabled.Error(), resBody.Message)
+}
+
+func TestCreateNewUserHandler_ReturnsBadRequestIfWebhookURLIsPrivate(t *testing.T) {
+	registry, _ := mixerlib.NewRegistry(mixerlib.NewMemoryStore())
+	registry.WebhooksEnabled = true
+
+	recorder := httptest.NewRecorder()
+	reqBody := []byte(`{"returnAddresses": ["return-one"], "webhookUrl": "http://169.254.169.254/latest/meta-data"}`)
+	r, _ := http.NewRequest("POST", "api/users", bytes.NewReader(reqBody))
+
+	CreateNewUserHandler(registry).ServeHTTP(recorder, r)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	var resBody ErrorPayload
+	err := json.NewDecoder(recorder.Body).Decode(&resBody)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, mixerlib.ErrPrivateWebhookHost.Error(), resBody.Message)
+}
+
 func TestCreateNewUserHandler_ReturnsBadRequestIfInvalidReqBody(t *testing.T) {
-	newUserHandlerFunc := CreateNewUserHandler(nil)
+	registry, _ := mixerlib.NewRegistry(mixerlib.NewMemoryStore())
+	newUserHandlerFunc := CreateNewUserHandler(registry)
 
 	recorder := httptest.NewRecorder()
 	handler := http.HandlerFunc(newUserHandlerFunc)
@@ -120,3 +167,92 @@ func TestCreateNewUserHandler_ReturnsBadRequestIfInvalidReqBody(t *testing.T) {
 
 	assert.Equal(t, "Invalid request body", resBody.Message)
 }
+
+func newStatusRouter(ml *mixerlib.MixerLib) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/users/{depositAddress}", GetUserStatusHandler(ml)).Methods("GET")
+	return r
+}
+
+func TestGetUserStatusHandler_ReturnsStatusOfUser(t *testing.T) {
+	store := mixerlib.NewMemoryStore()
+	store.AddUser(mixerlib.MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+	})
+	ml := &mixerlib.MixerLib{Store: store}
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/users/deposit-one", nil)
+
+	newStatusRouter(ml).ServeHTTP(recorder, r)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var resBody mixerlib.UserStatus
+	err := json.NewDecoder(recorder.Body).Decode(&resBody)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, "deposit-one", resBody.DepositAddress)
+	assert.Equal(t, mixerlib.StateAwaitingDeposit, resBody.State)
+	assert.Equal(t, map[string]clientlib.Amount{"return-one": 0}, resBody.Returned)
+	assert.Nil(t, resBody.EstimatedCompletion)
+}
+
+func TestGetUserStatusHandler_ReturnsNotFoundForUnknownUser(t *testing.T) {
+	ml := &mixerlib.MixerLib{Store: mixerlib.NewMemoryStore()}
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/users/deposit-one", nil)
+
+	newStatusRouter(ml).ServeHTTP(recorder, r)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	var resBody ErrorPayload
+	err := json.NewDecoder(recorder.Body).Decode(&resBody)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, "User not found", resBody.Message)
+}
+
+func TestHealthHandler_ReportsOK(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/healthz", nil)
+
+	http.HandlerFunc(HealthHandler).ServeHTTP(recorder, r)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, recorder.Body.String())
+}
+
+func TestReadinessHandler_ReturnsServiceUnavailableIfNotReady(t *testing.T) {
+	breaker := clientlib.NewCircuitBreaker(1, time.Minute)
+	ml := &mixerlib.MixerLib{
+		JobcoinClient: &clientlib.JobcoinLib{Client: clientlib.NewClientMock(http.StatusOK, nil, nil), Breaker: breaker},
+		Store:         mixerlib.NewMemoryStore(),
+		Breaker:       breaker,
+	}
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/readyz", nil)
+
+	ReadinessHandler(ml).ServeHTTP(recorder, r)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	var resBody mixerlib.Readiness
+	err := json.NewDecoder(recorder.Body).Decode(&resBody)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.False(t, resBody.Ready)
+	assert.Equal(t, "not running", resBody.Components[mixerlib.DepositPollerComponent].Error)
+	assert.True(t, resBody.Components[mixerlib.JobcoinComponent].Healthy)
+	assert.True(t, resBody.Components[mixerlib.StoreComponent].Healthy)
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader is the header a request ID is read from, if the client sent
+// one, and returned in.
+const RequestIDHeader = "X-Request-ID"
+
+type loggerKey struct{}
+
+// WithLogging returns middleware that gives every request an ID, returns it in
+// the RequestIDHeader and logs the request once it has been handled. Handlers
+// log through LoggerFrom, which tags every entry with the request ID. A panic
+// in a handler is logged and answered with a 500 so it only fails that request.
+func WithLogging(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(RequestIDHeader)
+			if requestID == "" {
+				requestID = uuid.New().String()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+
+			requestLogger := logger.With("requestId", requestID)
+			r = r.WithContext(context.WithValue(r.Context(), loggerKey{}, requestLogger))
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+
+			defer func() {
+				if v := recover(); v != nil {
+					if v == http.ErrAbortHandler {
+						panic(v)
+					}
+					requestLogger.Error("Request panicked", "panic", v)
+					if !sw.wroteHeader {
+						respondWithJSON(sw, http.StatusInternalServerError, ErrorPayload{"Internal server error"})
+					}
+				}
+				requestLogger.Info(
+					"Handled request",
+					"method", r.Method, "path", r.URL.Path, "status", sw.status, "duration", time.Since(start).String(),
+				)
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// LoggerFrom returns the logger for the request with the given context, or
+// slog.Default() if the request did not pass through WithLogging.
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// statusWriter records the status code written to a ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client, for handlers that stream.
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		sw.wroteHeader = true
+		flusher.Flush()
+	}
+}
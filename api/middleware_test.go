@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin WithLogging tests
+func TestWithLogging_TagsRequestLogsWithRequestID(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	handler := WithLogging(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		LoggerFrom(r.Context()).Info("Handling request")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/users/1234abcd", nil)
+	r.Header.Set(RequestIDHeader, "request-one")
+
+	handler.ServeHTTP(recorder, r)
+
+	decoder := json.NewDecoder(&out)
+	var handled, access map[string]interface{}
+	decoder.Decode(&handled)
+	decoder.Decode(&access)
+
+	assert.Equal(t, "request-one", recorder.Header().Get(RequestIDHeader))
+	assert.Equal(t, "request-one", handled["requestId"])
+	assert.Equal(t, "Handled request", access["msg"])
+	assert.Equal(t, "request-one", access["requestId"])
+	assert.Equal(t, float64(http.StatusTeapot), access["status"])
+}
+
+func TestWithLogging_GeneratesRequestIDIfNoneSent(t *testing.T) {
+	logger := slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))
+	handler := WithLogging(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/api/users/1234abcd", nil)
+
+	handler.ServeHTTP(recorder, r)
+
+	assert.Equal(t, 36, len(recorder.Header().Get(RequestIDHeader)))
+}
+
+func TestWithLogging_RespondsWithInternalServerErrorIfHandlerPanics(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	handler := WithLogging(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/users", nil)
+
+	assert.NotPanics(t, func() {
+		handler.ServeHTTP(recorder, r)
+	})
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Contains(t, out.String(), `"msg":"Request panicked"`)
+}
+
+func TestLoggerFrom_ReturnsDefaultLoggerOutsideWithLogging(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/api/users/1234abcd", nil)
+
+	assert.Equal(t, slog.Default(), LoggerFrom(r.Context()))
+}
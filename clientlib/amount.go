@@ -0,0 +1,126 @@
+package clientlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of Jobcoin held as an integer number of the smallest
+// unit the mixer deals in. Working in whole units keeps every sum exact, unlike
+// float64 where repeated fees and splits accumulate rounding error.
+type Amount int64
+
+// AmountDecimals is the number of decimal places an Amount can represent.
+const AmountDecimals = 8
+
+// Coin is one whole Jobcoin.
+const Coin Amount = 100000000
+
+// ParseAmount parses a decimal string such as "10.53" into an Amount.
+// Digits beyond AmountDecimals decimal places are truncated towards zero.
+// Exponents, as produced by %g, are not accepted.
+func ParseAmount(s string) (Amount, error) {
+	str := strings.TrimSpace(s)
+	negative := strings.HasPrefix(str, "-")
+	str = strings.TrimPrefix(str, "-")
+
+	whole, frac := str, ""
+	if idx := strings.Index(str, "."); idx != -1 {
+		whole, frac = str[:idx], str[idx+1:]
+	}
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("invalid Jobcoin amount %q", s)
+	}
+
+	if len(frac) > AmountDecimals {
+		frac = frac[:AmountDecimals]
+	}
+	frac = frac + strings.Repeat("0", AmountDecimals-len(frac))
+
+	if whole == "" {
+		whole = "0"
+	}
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Jobcoin amount %q", s)
+	}
+
+	if negative {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+// MustParseAmount is like ParseAmount but panics if s is not a valid amount.
+func MustParseAmount(s string) Amount {
+	a, err := ParseAmount(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// String formats the amount as a plain decimal with trailing zeros removed,
+// e.g. "5", "0.25" or "10.53".
+func (a Amount) String() string {
+	sign := ""
+	units := int64(a)
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+
+	whole := units / int64(Coin)
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", AmountDecimals, units%int64(Coin)), "0")
+	if frac == "" {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	return fmt.Sprintf("%s%d.%s", sign, whole, frac)
+}
+
+// Float64 returns the amount in Jobcoin. It may lose precision, so it is only
+// meant for reporting, e.g. in metrics, and never for arithmetic.
+func (a Amount) Float64() float64 {
+	return float64(a) / float64(Coin)
+}
+
+// BasisPoints returns bps hundredths of a percent of the amount, rounded down
+// to the nearest unit. The remainder, a - a.BasisPoints(bps), is exact so the
+// two parts always sum back to the original amount.
+func (a Amount) BasisPoints(bps int64) Amount {
+	// Split the multiplication to avoid overflowing int64 on large amounts.
+	return Amount(int64(a)/10000*bps + int64(a)%10000*bps/10000)
+}
+
+// MarshalJSON encodes the amount as a JSON string, which is how the Jobcoin API
+// represents amounts.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON decodes an amount from a JSON string.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package clientlib
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin ParseAmount tests
+func TestParseAmount_ParsesDecimalStringsExactly(t *testing.T) {
+	cases := map[string]Amount{
+		"0":           0,
+		"5":           5 * Coin,
+		"10.53":       1053000000,
+		"0.00000001":  1,
+		".5":          Coin / 2,
+		"7.50677726":  750677726,
+		"-2.25":       -225000000,
+		"3.000000019": 300000001,
+	}
+
+	for input, expected := range cases {
+		actual, err := ParseAmount(input)
+		if err != nil {
+			t.Errorf("Did not expect error for %q. Got: %s", input, err.Error())
+		}
+		assert.Equal(t, expected, actual, input)
+	}
+}
+
+func TestParseAmount_ReturnsErrorForInvalidAmounts(t *testing.T) {
+	for _, input := range []string{"", ".", "abc", "1e-05", "1.2.3", "99999999999999999999"} {
+		_, err := ParseAmount(input)
+		if err == nil {
+			t.Errorf("Expected error for %q but did not receive one.", input)
+		}
+	}
+}
+
+// Begin String tests
+func TestAmountString_FormatsWithoutTrailingZerosOrExponent(t *testing.T) {
+	assert.Equal(t, "0", Amount(0).String())
+	assert.Equal(t, "5", (5 * Coin).String())
+	assert.Equal(t, "4.99999999", (5*Coin - 1).String())
+	assert.Equal(t, "0.00001", Amount(1000).String())
+	assert.Equal(t, "-2.25", Amount(-225000000).String())
+}
+
+// Begin BasisPoints tests
+func TestAmountBasisPoints_RoundsDownAndRemainderSumsToOriginal(t *testing.T) {
+	deposit := MustParseAmount("0.54")
+
+	fee := deposit.BasisPoints(100)
+
+	assert.Equal(t, MustParseAmount("0.0054"), fee)
+	assert.Equal(t, deposit, fee+(deposit-fee))
+	assert.Equal(t, Amount(0), Amount(99).BasisPoints(100))
+}
+
+// Begin JSON tests
+func TestAmountJSON_RoundTripsAsString(t *testing.T) {
+	data, err := json.Marshal(JobcoinTx{Amount: MustParseAmount("11.23")})
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+	assert.Contains(t, string(data), `"amount":"11.23"`)
+
+	var tx JobcoinTx
+	err = json.Unmarshal(data, &tx)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+	assert.Equal(t, MustParseAmount("11.23"), tx.Amount)
+}
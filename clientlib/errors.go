@@ -0,0 +1,66 @@
+package clientlib
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrInsufficientFunds is matched by errors returned when the Jobcoin API
+	// rejects a transaction because the sending address does not hold enough Jobcoin.
+	ErrInsufficientFunds = errors.New("Insufficient Funds")
+
+	// ErrTransient is matched by errors that are likely to succeed if the
+	// request is made again: network failures, 5xx responses and 429 Too Many Requests.
+	ErrTransient = errors.New("temporary Jobcoin API failure")
+
+	// ErrCircuitOpen is returned without making a request while the circuit
+	// breaker considers the Jobcoin API to be down.
+	ErrCircuitOpen = errors.New("Jobcoin API circuit breaker is open")
+)
+
+// APIError is returned when the Jobcoin API responds with an unexpected status.
+// Use errors.Is with ErrInsufficientFunds or ErrTransient to decide how to handle it.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Is reports whether the API error is an instance of target.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrInsufficientFunds:
+		return e.Message == ErrInsufficientFunds.Error()
+	case ErrTransient:
+		return transientStatus(e.StatusCode)
+	}
+	return false
+}
+
+// transientStatus reports whether a response with the given status code may
+// succeed if the request is repeated.
+func transientStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// networkError wraps a failure to get any response from the Jobcoin API.
+// These are always treated as transient.
+type networkError struct {
+	err error
+}
+
+func (e *networkError) Error() string {
+	return e.err.Error()
+}
+
+func (e *networkError) Unwrap() error {
+	return e.err
+}
+
+func (e *networkError) Is(target error) bool {
+	return target == ErrTransient
+}
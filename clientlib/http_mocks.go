@@ -30,3 +30,24 @@ func NewClientMock(status int, payload []byte, err error) HTTPClient {
 		Error:   err,
 	}
 }
+
+type mockSequenceClient struct {
+	Responses []*mockHTTPClient
+	Calls     int
+}
+
+func (mc *mockSequenceClient) Do(req *http.Request) (*http.Response, error) {
+	response := mc.Responses[len(mc.Responses)-1]
+	if mc.Calls < len(mc.Responses) {
+		response = mc.Responses[mc.Calls]
+	}
+	mc.Calls++
+	return response.Do(req)
+}
+
+// newSequenceClientMock returns a mock HTTPClient that answers each request with
+// the next of the given responses, repeating the last one once they run out.
+// Calls counts the requests made.
+func newSequenceClientMock(responses ...*mockHTTPClient) *mockSequenceClient {
+	return &mockSequenceClient{Responses: responses}
+}
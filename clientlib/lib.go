@@ -3,12 +3,10 @@ package clientlib
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-
-	"github.com/ckaminer/jobcoin"
+	"time"
 )
 
 // HTTPClient is an interface representing functionality of an http client
@@ -22,12 +20,12 @@ type JobcoinTx struct {
 	Timestamp   string `json:"timestamp"`
 	FromAddress string `json:"fromAddress"`
 	ToAddress   string `json:"toAddress"`
-	Amount      string `json:"amount"`
+	Amount      Amount `json:"amount"`
 }
 
 // JobcoinAddressInfo represents info for a Jobcoin address
 type JobcoinAddressInfo struct {
-	Balance      string      `json:"balance"`
+	Balance      Amount      `json:"balance"`
 	Transactions []JobcoinTx `json:"transactions"`
 }
 
@@ -35,35 +33,73 @@ type JobcoinAddressInfo struct {
 // interact with the Jobcoin API.
 type JobcoinClient interface {
 	GetAddressInfo(address string) (JobcoinAddressInfo, error)
-	SendJobcoin(fromAddress, toAddress, amount string) error
+	SendJobcoin(fromAddress, toAddress string, amount Amount) error
 }
 
 // JobcoinLib is an implementation of the JobcoinClient interface. It requires
-// an HTTPClient to make network calls.
+// an HTTPClient to make network calls and the BaseURL of the Jobcoin API,
+// e.g. https://jobcoin.gemini.com/casino-unit/api.
+//
+// Failed requests are retried according to Retry. Address lookups are retried
+// after any ErrTransient failure, but transactions are only retried after a 429
+// response: a network error or 5xx may come after the transaction was created,
+// so retrying it could send the same Jobcoin twice. If Breaker is set, requests
+// fail with ErrCircuitOpen while it is open. If Limiter is set, every attempt,
+// including retries, first waits for a token from it. Failed requests and
+// retries are logged to Logger, or to slog.Default() if it is nil.
 type JobcoinLib struct {
-	Client HTTPClient
+	Client  HTTPClient
+	BaseURL string
+	Retry   RetryPolicy
+	Breaker *CircuitBreaker
+	Limiter *RateLimiter
+	Logger  *slog.Logger
+
+	sleepFunc func(time.Duration)
+}
+
+func (jl *JobcoinLib) logger() *slog.Logger {
+	if jl.Logger == nil {
+		return slog.Default()
+	}
+	return jl.Logger
 }
 
 // GetAddressInfo should return address info for given address
 func (jl *JobcoinLib) GetAddressInfo(address string) (JobcoinAddressInfo, error) {
-	url := fmt.Sprintf("%s/%s", jobcoin.AddressesEndpoint, address)
+	var addrInfo JobcoinAddressInfo
+	err := jl.withRetry(isTransient, func() error {
+		var err error
+		addrInfo, err = jl.getAddressInfo(address)
+		return err
+	})
+	if err != nil {
+		jl.logger().Debug("Failed to get Jobcoin address info", "address", address, "error", err)
+	}
+	return addrInfo, err
+}
+
+func (jl *JobcoinLib) getAddressInfo(address string) (JobcoinAddressInfo, error) {
+	url := fmt.Sprintf("%s/addresses/%s", jl.BaseURL, address)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		log.Println(err)
 		return JobcoinAddressInfo{}, err
 	}
 
 	res, err := jl.Client.Do(req)
 	if err != nil {
-		log.Println(err)
-		return JobcoinAddressInfo{}, err
+		return JobcoinAddressInfo{}, &networkError{err}
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("Failed to get address info due to: %w", readAPIError(res))
+		return JobcoinAddressInfo{}, err
+	}
+
 	var addrInfo JobcoinAddressInfo
 	err = json.NewDecoder(res.Body).Decode(&addrInfo)
 	if err != nil {
-		log.Println(err)
 		return JobcoinAddressInfo{}, err
 	}
 
@@ -71,44 +107,60 @@ func (jl *JobcoinLib) GetAddressInfo(address string) (JobcoinAddressInfo, error)
 }
 
 // SendJobcoin creates a transaction sending the specified amount between the given addresses
-func (jl *JobcoinLib) SendJobcoin(fromAddress, toAddress, amount string) error {
+func (jl *JobcoinLib) SendJobcoin(fromAddress, toAddress string, amount Amount) error {
+	err := jl.withRetry(isRateLimited, func() error {
+		return jl.sendJobcoin(fromAddress, toAddress, amount)
+	})
+	if err != nil {
+		jl.logger().Debug("Failed to send Jobcoin", "from", fromAddress, "to", toAddress, "amount", amount, "error", err)
+	}
+	return err
+}
+
+func (jl *JobcoinLib) sendJobcoin(fromAddress, toAddress string, amount Amount) error {
 	reqBody, err := json.Marshal(JobcoinTx{
 		FromAddress: fromAddress,
 		ToAddress:   toAddress,
 		Amount:      amount,
 	})
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 
-	req, err := http.NewRequest("POST", jobcoin.TransactionEndpoint, bytes.NewReader(reqBody))
+	req, err := http.NewRequest("POST", jl.BaseURL+"/transactions", bytes.NewReader(reqBody))
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	res, err := jl.Client.Do(req)
 	if err != nil {
-		log.Println(err)
-		return err
+		return &networkError{err}
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		apiErr := struct {
-			Error interface{} `json:"error"`
-		}{}
-		err = json.NewDecoder(res.Body).Decode(&apiErr)
-		if err != nil {
-			log.Println(err)
-			return err
-		}
-		errMessage := fmt.Sprintf("Failed to create transaction due to: %s", apiErr.Error)
-		log.Println(errMessage)
-		return errors.New(errMessage)
+		err = fmt.Errorf("Failed to create transaction due to: %w", readAPIError(res))
+		return err
 	}
 
 	return nil
 }
+
+// readAPIError builds an APIError from a failed response, using the error
+// message in the body if there is one.
+func readAPIError(res *http.Response) *APIError {
+	apiErr := struct {
+		Error interface{} `json:"error"`
+	}{}
+	message := http.StatusText(res.StatusCode)
+	err := json.NewDecoder(res.Body).Decode(&apiErr)
+	if err == nil && apiErr.Error != nil {
+		message = fmt.Sprint(apiErr.Error)
+	}
+
+	return &APIError{
+		StatusCode: res.StatusCode,
+		Message:    message,
+	}
+}
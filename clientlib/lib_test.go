@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -27,22 +28,22 @@ func TestGetAddressInfo_ReturnsAddressInfoForGivenAddress(t *testing.T) {
 		]
 	}`)
 	client := NewClientMock(http.StatusOK, mockResponseBody, nil)
-	jl := &JobcoinLib{client}
+	jl := &JobcoinLib{Client: client}
 
 	expectedAddrInfo := JobcoinAddressInfo{
-		Balance: "10.53",
+		Balance: MustParseAmount("10.53"),
 		Transactions: []JobcoinTx{
 			{
 				Timestamp:   "2020-10-23T14:05:01.199Z",
 				FromAddress: "",
 				ToAddress:   "01234abcde",
-				Amount:      "20.97",
+				Amount:      MustParseAmount("20.97"),
 			},
 			{
 				Timestamp:   "2020-10-24T09:29:51.320Z",
 				FromAddress: "01234abcde",
 				ToAddress:   "98765zyxwt",
-				Amount:      "10.46",
+				Amount:      MustParseAmount("10.46"),
 			},
 		},
 	}
@@ -58,14 +59,15 @@ func TestGetAddressInfo_ReturnsAddressInfoForGivenAddress(t *testing.T) {
 func TestGetAddressInfo_ReturnsErrorIfClientRequestFails(t *testing.T) {
 	expectedErr := errors.New("request failed")
 	client := NewClientMock(0, nil, expectedErr)
-	jl := &JobcoinLib{client}
+	jl := &JobcoinLib{Client: client}
 
 	_, err := jl.GetAddressInfo("01234abcde")
 	if err == nil {
 		t.Errorf("Expected error to be returned but it was not.")
 	}
 
-	assert.Equal(t, expectedErr, err)
+	assert.True(t, errors.Is(err, expectedErr))
+	assert.True(t, errors.Is(err, ErrTransient))
 }
 
 func TestGetAddressInfo_ReturnsErrorIfJsonFailsToDecode(t *testing.T) {
@@ -86,7 +88,7 @@ func TestGetAddressInfo_ReturnsErrorIfJsonFailsToDecode(t *testing.T) {
 		]
 	}`)
 	client := NewClientMock(http.StatusOK, mockResponseBody, nil)
-	jl := &JobcoinLib{client}
+	jl := &JobcoinLib{Client: client}
 
 	_, err := jl.GetAddressInfo("01234abcde")
 	if err == nil {
@@ -104,9 +106,9 @@ func TestSendJobcoin_SendsJobcoinFromOneAddressToAnother(t *testing.T) {
 		}
 	`)
 	client := NewClientMock(http.StatusOK, mockResponseBody, nil)
-	jl := &JobcoinLib{client}
+	jl := &JobcoinLib{Client: client}
 
-	err := jl.SendJobcoin("1234abcd", "9876zyxw", "11.23")
+	err := jl.SendJobcoin("1234abcd", "9876zyxw", MustParseAmount("11.23"))
 	if err != nil {
 		t.Errorf("Did not expect error. Got: %s", err.Error())
 	}
@@ -115,14 +117,15 @@ func TestSendJobcoin_SendsJobcoinFromOneAddressToAnother(t *testing.T) {
 func TestSendJobcoin_ReturnsErrorIfClientRequestFails(t *testing.T) {
 	expectedErr := errors.New("request failed")
 	client := NewClientMock(0, nil, expectedErr)
-	jl := &JobcoinLib{client}
+	jl := &JobcoinLib{Client: client}
 
-	err := jl.SendJobcoin("1234abcd", "9876zyxw", "11.23")
+	err := jl.SendJobcoin("1234abcd", "9876zyxw", MustParseAmount("11.23"))
 	if err == nil {
 		t.Errorf("Expected error to be returned but it was not.")
 	}
 
-	assert.Equal(t, expectedErr, err)
+	assert.True(t, errors.Is(err, expectedErr))
+	assert.True(t, errors.Is(err, ErrTransient))
 }
 
 func TestSendJobcoin_ReturnsErrorIfTransactionCreationFails(t *testing.T) {
@@ -130,9 +133,9 @@ func TestSendJobcoin_ReturnsErrorIfTransactionCreationFails(t *testing.T) {
 		"error": "Insufficient Funds"
 	}`)
 	client := NewClientMock(http.StatusUnprocessableEntity, mockResponseBody, nil)
-	jl := &JobcoinLib{client}
+	jl := &JobcoinLib{Client: client}
 
-	err := jl.SendJobcoin("1234abcd", "9876zyxw", "11.23")
+	err := jl.SendJobcoin("1234abcd", "9876zyxw", MustParseAmount("11.23"))
 	if err == nil {
 		t.Errorf("Expected error to be returned but it was not.")
 	}
@@ -140,3 +143,232 @@ func TestSendJobcoin_ReturnsErrorIfTransactionCreationFails(t *testing.T) {
 	expectedErr := "Failed to create transaction due to: Insufficient Funds"
 	assert.Equal(t, expectedErr, err.Error())
 }
+
+func TestSendJobcoin_InsufficientFundsErrorIsTyped(t *testing.T) {
+	client := NewClientMock(http.StatusUnprocessableEntity, []byte(`{"error": "Insufficient Funds"}`), nil)
+	jl := &JobcoinLib{Client: client}
+
+	err := jl.SendJobcoin("1234abcd", "9876zyxw", MustParseAmount("11.23"))
+
+	assert.True(t, errors.Is(err, ErrInsufficientFunds))
+	assert.False(t, errors.Is(err, ErrTransient))
+}
+
+func TestSendJobcoin_RetriesRateLimitedRequests(t *testing.T) {
+	client := newSequenceClientMock(
+		&mockHTTPClient{Status: http.StatusTooManyRequests, Payload: []byte(`{}`)},
+		&mockHTTPClient{Status: http.StatusOK, Payload: []byte(`{"status": "OK"}`)},
+	)
+	jl := &JobcoinLib{
+		Client:    client,
+		Retry:     RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		sleepFunc: func(time.Duration) {},
+	}
+
+	err := jl.SendJobcoin("1234abcd", "9876zyxw", MustParseAmount("11.23"))
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, 2, client.Calls)
+}
+
+func TestSendJobcoin_DoesNotRetryServerErrors(t *testing.T) {
+	client := newSequenceClientMock(
+		&mockHTTPClient{Status: http.StatusBadGateway, Payload: []byte(`{}`)},
+		&mockHTTPClient{Status: http.StatusOK, Payload: []byte(`{"status": "OK"}`)},
+	)
+	jl := &JobcoinLib{
+		Client:    client,
+		Retry:     RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		sleepFunc: func(time.Duration) {},
+	}
+
+	err := jl.SendJobcoin("1234abcd", "9876zyxw", MustParseAmount("11.23"))
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.True(t, errors.Is(err, ErrTransient))
+	assert.Equal(t, 1, client.Calls)
+}
+
+// Begin retry tests
+func TestGetAddressInfo_RetriesTransientFailuresWithBackoff(t *testing.T) {
+	client := newSequenceClientMock(
+		&mockHTTPClient{Error: errors.New("connection reset")},
+		&mockHTTPClient{Status: http.StatusServiceUnavailable, Payload: []byte(`{}`)},
+		&mockHTTPClient{Status: http.StatusOK, Payload: []byte(`{"balance": "1.5"}`)},
+	)
+	var waits []time.Duration
+	jl := &JobcoinLib{
+		Client:    client,
+		Retry:     RetryPolicy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second},
+		sleepFunc: func(d time.Duration) { waits = append(waits, d) },
+	}
+
+	info, err := jl.GetAddressInfo("01234abcde")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, MustParseAmount("1.5"), info.Balance)
+	assert.Equal(t, 3, client.Calls)
+	assert.Equal(t, 2, len(waits))
+	assert.True(t, waits[0] <= 100*time.Millisecond)
+	assert.True(t, waits[1] <= 200*time.Millisecond)
+}
+
+func TestGetAddressInfo_GivesUpAfterMaxAttempts(t *testing.T) {
+	client := newSequenceClientMock(&mockHTTPClient{Status: http.StatusInternalServerError, Payload: []byte(`{}`)})
+	jl := &JobcoinLib{
+		Client:    client,
+		Retry:     RetryPolicy{MaxAttempts: 4},
+		sleepFunc: func(time.Duration) {},
+	}
+
+	_, err := jl.GetAddressInfo("01234abcde")
+
+	assert.True(t, errors.Is(err, ErrTransient))
+	assert.Equal(t, "Failed to get address info due to: Internal Server Error", err.Error())
+	assert.Equal(t, 4, client.Calls)
+}
+
+func TestRetryPolicy_BackoffIsCappedAtMaxBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second}
+
+	for i := 0; i < 100; i++ {
+		assert.True(t, policy.Backoff(10) <= 3*time.Second)
+	}
+}
+
+// Begin CircuitBreaker tests
+func TestCircuitBreaker_OpensAfterThresholdAndRefusesRequests(t *testing.T) {
+	client := newSequenceClientMock(&mockHTTPClient{Error: errors.New("connection refused")})
+	jl := &JobcoinLib{
+		Client:  client,
+		Breaker: NewCircuitBreaker(2, time.Minute),
+	}
+
+	jl.GetAddressInfo("01234abcde")
+	jl.GetAddressInfo("01234abcde")
+	_, err := jl.GetAddressInfo("01234abcde")
+
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.True(t, jl.Breaker.Open())
+	assert.Equal(t, 2, client.Calls)
+}
+
+func TestCircuitBreaker_ClosesAfterSuccessfulRequestOnceCooledDown(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+	client := newSequenceClientMock(
+		&mockHTTPClient{Error: errors.New("connection refused")},
+		&mockHTTPClient{Status: http.StatusOK, Payload: []byte(`{"balance": "1"}`)},
+	)
+	jl := &JobcoinLib{Client: client, Breaker: breaker}
+
+	jl.GetAddressInfo("01234abcde")
+	assert.True(t, breaker.Open())
+
+	now = now.Add(time.Minute)
+	_, err := jl.GetAddressInfo("01234abcde")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.False(t, breaker.Open())
+}
+
+func TestCircuitBreaker_DoesNotCountRejectedTransactions(t *testing.T) {
+	client := NewClientMock(http.StatusUnprocessableEntity, []byte(`{"error": "Insufficient Funds"}`), nil)
+	jl := &JobcoinLib{
+		Client:  client,
+		Breaker: NewCircuitBreaker(1, time.Minute),
+	}
+
+	jl.SendJobcoin("1234abcd", "9876zyxw", MustParseAmount("11.23"))
+
+	assert.False(t, jl.Breaker.Open())
+}
+
+func TestCircuitBreaker_RecordsLastResponseButNotTransientFailures(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(5, time.Minute)
+	breaker.now = func() time.Time { return now }
+	jl := &JobcoinLib{
+		Client:  NewClientMock(http.StatusUnprocessableEntity, []byte(`{"error": "Insufficient Funds"}`), nil),
+		Breaker: breaker,
+	}
+	assert.True(t, breaker.LastResponse().IsZero())
+
+	jl.SendJobcoin("1234abcd", "9876zyxw", MustParseAmount("11.23"))
+	respondedAt := now
+	now = now.Add(time.Minute)
+	jl.Client = NewClientMock(0, nil, errors.New("connection refused"))
+	jl.GetAddressInfo("01234abcde")
+
+	assert.Equal(t, respondedAt, breaker.LastResponse())
+}
+
+// Begin RateLimiter tests
+
+// newTestRateLimiter returns a RateLimiter whose clock only moves when the
+// returned function is called, and which records how long it waits.
+func newTestRateLimiter(rate float64, burst int) (*RateLimiter, func(time.Duration), *[]time.Duration) {
+	now := time.Now()
+	waits := []time.Duration{}
+	limiter := NewRateLimiter(rate, burst)
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(d time.Duration) { waits = append(waits, d) }
+	return limiter, func(d time.Duration) { now = now.Add(d) }, &waits
+}
+
+func TestRateLimiter_WaitsOnceBurstIsUsedUp(t *testing.T) {
+	limiter, _, waits := newTestRateLimiter(2, 2)
+
+	limiter.wait()
+	limiter.wait()
+	assert.Empty(t, *waits)
+
+	limiter.wait()
+	limiter.wait()
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second}, *waits)
+}
+
+func TestRateLimiter_RefillsUpToBurst(t *testing.T) {
+	limiter, advance, waits := newTestRateLimiter(1, 2)
+	limiter.wait()
+	limiter.wait()
+
+	advance(time.Hour)
+	limiter.wait()
+	limiter.wait()
+	assert.Empty(t, *waits)
+
+	limiter.wait()
+	assert.Equal(t, []time.Duration{time.Second}, *waits)
+}
+
+func TestGetAddressInfo_WaitsForRateLimiterBeforeEveryAttempt(t *testing.T) {
+	client := newSequenceClientMock(
+		&mockHTTPClient{Status: http.StatusServiceUnavailable, Payload: []byte(`{}`)},
+		&mockHTTPClient{Status: http.StatusOK, Payload: []byte(`{"balance": "1"}`)},
+	)
+	limiter, _, waits := newTestRateLimiter(1, 1)
+	jl := &JobcoinLib{
+		Client:    client,
+		Retry:     RetryPolicy{MaxAttempts: 3},
+		Limiter:   limiter,
+		sleepFunc: func(time.Duration) {},
+	}
+
+	_, err := jl.GetAddressInfo("01234abcde")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, 2, client.Calls)
+	assert.Equal(t, []time.Duration{time.Second}, *waits)
+}
@@ -0,0 +1,66 @@
+package clientlib
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentedClient is a JobcoinClient that counts the calls made through
+// Client and how long they took, by method and whether they failed.
+type InstrumentedClient struct {
+	Client   JobcoinClient
+	Requests *prometheus.CounterVec
+	Duration *prometheus.HistogramVec
+}
+
+// NewInstrumentedClient wraps client and registers its metrics with
+// registerer. An error is returned if they cannot be registered, for example
+// because metrics with the same names already are.
+func NewInstrumentedClient(client JobcoinClient, registerer prometheus.Registerer) (*InstrumentedClient, error) {
+	ic := &InstrumentedClient{
+		Client: client,
+		Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jobcoin_api_requests_total",
+			Help: "Calls made to the Jobcoin API by method and result. A retried call is counted once.",
+		}, []string{"method", "result"}),
+		Duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "jobcoin_api_request_duration_seconds",
+			Help:    "How long calls to the Jobcoin API took by method, including any retries.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+	}
+
+	for _, collector := range []prometheus.Collector{ic.Requests, ic.Duration} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ic, nil
+}
+
+// GetAddressInfo calls GetAddressInfo on the wrapped client.
+func (ic *InstrumentedClient) GetAddressInfo(address string) (JobcoinAddressInfo, error) {
+	start := time.Now()
+	info, err := ic.Client.GetAddressInfo(address)
+	ic.observe("GetAddressInfo", start, err)
+	return info, err
+}
+
+// SendJobcoin calls SendJobcoin on the wrapped client.
+func (ic *InstrumentedClient) SendJobcoin(fromAddress, toAddress string, amount Amount) error {
+	start := time.Now()
+	err := ic.Client.SendJobcoin(fromAddress, toAddress, amount)
+	ic.observe("SendJobcoin", start, err)
+	return err
+}
+
+func (ic *InstrumentedClient) observe(method string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	ic.Requests.WithLabelValues(method, result).Inc()
+	ic.Duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,59 @@
+package clientlib
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestInstrumentedClient wraps client, registering its metrics with a new registry.
+func newTestInstrumentedClient(t *testing.T, client HTTPClient) *InstrumentedClient {
+	ic, err := NewInstrumentedClient(&JobcoinLib{Client: client}, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	return ic
+}
+
+// Begin InstrumentedClient tests
+func TestInstrumentedClient_CountsRequestsByMethodAndResult(t *testing.T) {
+	mockResponseBody := []byte(`{"balance": "10.53", "transactions": []}`)
+	ic := newTestInstrumentedClient(t, NewClientMock(http.StatusOK, mockResponseBody, nil))
+
+	info, err := ic.GetAddressInfo("01234abcde")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, MustParseAmount("10.53"), info.Balance)
+	assert.Equal(t, float64(1), testutil.ToFloat64(ic.Requests.WithLabelValues("GetAddressInfo", "success")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(ic.Requests.WithLabelValues("GetAddressInfo", "error")))
+	assert.Equal(t, 1, testutil.CollectAndCount(ic.Duration))
+}
+
+func TestInstrumentedClient_CountsFailedRequests(t *testing.T) {
+	expectedErr := errors.New("request failed")
+	ic := newTestInstrumentedClient(t, NewClientMock(0, nil, expectedErr))
+
+	err := ic.SendJobcoin("01234abcde", "98765zyxwt", Coin)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(ic.Requests.WithLabelValues("SendJobcoin", "error")))
+	assert.Equal(t, 1, testutil.CollectAndCount(ic.Duration))
+}
+
+func TestNewInstrumentedClient_ReturnsErrorIfMetricsAlreadyRegistered(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	NewInstrumentedClient(&JobcoinLib{}, registry)
+
+	_, err := NewInstrumentedClient(&JobcoinLib{}, registry)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
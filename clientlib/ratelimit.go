@@ -0,0 +1,64 @@
+package clientlib
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting how often requests are made to the
+// Jobcoin API. The bucket holds up to Burst tokens and refills at Rate tokens
+// a second. Every request takes a token, waiting for one if the bucket is
+// empty, so requests are made at most Rate times a second once a burst is
+// used up. It is safe for concurrent use, so everything sharing a JobcoinLib
+// shares its limit. A nil *RateLimiter does not limit requests.
+type RateLimiter struct {
+	Rate  float64
+	Burst int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate requests a second, in
+// bursts of up to burst requests. It starts with a full bucket.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		Rate:   rate,
+		Burst:  burst,
+		tokens: float64(burst),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// wait takes a token, first waiting until one is available if the bucket is
+// empty. The token is taken before waiting, so callers are let through in
+// the order they arrived.
+func (rl *RateLimiter) wait() {
+	if rl == nil {
+		return
+	}
+
+	rl.mu.Lock()
+	now := rl.now()
+	if !rl.last.IsZero() {
+		rl.tokens = rl.tokens + now.Sub(rl.last).Seconds()*rl.Rate
+	}
+	if rl.tokens > float64(rl.Burst) {
+		rl.tokens = float64(rl.Burst)
+	}
+	rl.last = now
+	rl.tokens--
+	var delay time.Duration
+	if rl.tokens < 0 {
+		delay = time.Duration(-rl.tokens / rl.Rate * float64(time.Second))
+	}
+	rl.mu.Unlock()
+
+	if delay > 0 {
+		rl.sleep(delay)
+	}
+}
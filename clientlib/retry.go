@@ -0,0 +1,173 @@
+package clientlib
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryPolicy configures how JobcoinLib retries requests that fail with
+// ErrTransient. The zero value makes a single attempt. It can be used for
+// retrying anything else the same way.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made, including the first.
+	MaxAttempts int
+	// InitialBackoff is the longest wait before the first retry. It doubles
+	// with each retry up to MaxBackoff, and the actual wait is a random
+	// duration up to that limit so that callers do not retry in lockstep.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Attempts returns the total number of attempts to make, at least 1.
+func (p RetryPolicy) Attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// Backoff returns how long to wait before retrying after the given attempt.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	limit := p.InitialBackoff
+	for i := 1; i < attempt && limit < p.MaxBackoff; i++ {
+		limit = limit * 2
+	}
+	if p.MaxBackoff > 0 && limit > p.MaxBackoff {
+		limit = p.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+// CircuitBreaker stops requests to the Jobcoin API after a run of transient
+// failures. Once open, every request fails immediately with ErrCircuitOpen
+// until Cooldown has passed, after which requests are let through again. The
+// first failure after that reopens the breaker and the first success closes it.
+// It also keeps when the Jobcoin API last responded, so its health can be
+// reported without making a request.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu           sync.Mutex
+	failures     int
+	openedAt     time.Time
+	lastResponse time.Time
+	now          func() time.Time
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that opens after threshold
+// consecutive transient failures and stays open for cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Open reports whether requests are currently being refused.
+func (cb *CircuitBreaker) Open() bool {
+	return cb.allow() != nil
+}
+
+// LastResponse returns when a request last got a response that was not a
+// transient failure, or the zero time if none has.
+func (cb *CircuitBreaker) LastResponse() time.Time {
+	if cb == nil {
+		return time.Time{}
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.lastResponse
+}
+
+// allow returns ErrCircuitOpen if requests are currently being refused.
+// A nil CircuitBreaker allows every request.
+func (cb *CircuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.failures >= cb.Threshold && cb.now().Sub(cb.openedAt) < cb.Cooldown {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record counts err as a failure if it is transient. Any other result shows
+// the Jobcoin API is responding, which closes the breaker. It reports whether
+// this failure is the one that opened the breaker.
+func (cb *CircuitBreaker) record(err error) bool {
+	if cb == nil {
+		return false
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if !errors.Is(err, ErrTransient) {
+		cb.failures = 0
+		cb.lastResponse = cb.now()
+		return false
+	}
+
+	cb.failures++
+	if cb.failures >= cb.Threshold {
+		cb.openedAt = cb.now()
+	}
+	return cb.failures == cb.Threshold
+}
+
+// withRetry calls attempt until it succeeds, returns an error retryable does
+// not accept, or the retry policy is exhausted. Every attempt is first checked
+// against, and its result recorded with, the circuit breaker, and then waits
+// for the rate limiter.
+func (jl *JobcoinLib) withRetry(retryable func(error) bool, attempt func() error) error {
+	attempts := jl.Retry.Attempts()
+	for i := 1; ; i++ {
+		err := jl.Breaker.allow()
+		if err != nil {
+			return err
+		}
+		jl.Limiter.wait()
+
+		err = attempt()
+		if jl.Breaker.record(err) {
+			jl.logger().Warn("Jobcoin API keeps failing, pausing requests", "failures", jl.Breaker.Threshold, "cooldown", jl.Breaker.Cooldown.String())
+		}
+		if err == nil || i >= attempts || !retryable(err) {
+			return err
+		}
+
+		wait := jl.Retry.Backoff(i)
+		jl.logger().Warn("Retrying Jobcoin request", "attempt", i, "wait", wait.String(), "error", err)
+		jl.sleep(wait)
+	}
+}
+
+func (jl *JobcoinLib) sleep(d time.Duration) {
+	if jl.sleepFunc != nil {
+		jl.sleepFunc(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// isTransient reports whether err may succeed if the request is repeated.
+func isTransient(err error) bool {
+	return errors.Is(err, ErrTransient)
+}
+
+// isRateLimited reports whether err was a 429 Too Many Requests response,
+// which the Jobcoin API returns without acting on the request.
+func isRateLimited(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests
+}
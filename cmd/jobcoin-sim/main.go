@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/ckaminer/jobcoin/jobcointest"
+)
+
+func main() {
+	port := flag.String("port", ":8081", "address the simulated Jobcoin API listens on")
+	statePath := flag.String("state", "", "file to save the ledger to between runs; kept in memory if empty")
+	flag.Parse()
+
+	ledger := jobcointest.NewLedger()
+	if *statePath != "" {
+		var err error
+		ledger, err = jobcointest.LoadLedger(*statePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Printf("Simulated Jobcoin API listening at http://localhost%s/api\n", *port)
+	log.Fatal(http.ListenAndServe(*port, jobcointest.NewHandler(ledger)))
+}
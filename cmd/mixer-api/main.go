@@ -1,45 +1,237 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ckaminer/jobcoin"
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/ckaminer/jobcoin/api"
 	"github.com/ckaminer/jobcoin/clientlib"
 	"github.com/ckaminer/jobcoin/mixerlib"
 )
 
+// shutdownTimeout is how long in-flight API requests are given to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	userChan := make(chan mixerlib.MixerUser)
-	houseChan := make(chan mixerlib.MixerUser)
+	rotateHouse := flag.Bool("rotate-house-address", false, "move the house funds to a new house address before starting")
+	rotationReason := flag.String("rotation-reason", "", "reason for rotating the house address, kept for auditing")
+	configPath := flag.String("config", os.Getenv("MIXER_CONFIG"), "path to a JSON config file")
+	flag.Parse()
 
-	r := mux.NewRouter()
-	r.HandleFunc("/api/users", api.CreateNewUserHandler(userChan)).Methods("POST")
+	config, err := jobcoin.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger := config.Log.NewLogger(os.Stderr)
+	slog.SetDefault(logger)
+
+	// Reconciling only reads the state, so it can run alongside a serving
+	// mixer-api without either overwriting the other's changes.
+	openStore := mixerlib.NewFileStore
+	if flag.Arg(0) == "reconcile" {
+		openStore = mixerlib.OpenFileStoreReadOnly
+	}
+	store, err := openStore(config.Mixer.StatePath)
+	if err != nil {
+		exit(logger, "Failed to open state file", err)
+	}
 
-	userTicker := time.NewTicker(time.Second * 5)
-	houseTicker := time.NewTicker(time.Second * 6)
+	registry, err := mixerlib.NewRegistry(store)
+	if err != nil {
+		exit(logger, "Failed to load registered users", err)
+	}
+
+	metricsRegistry := prometheus.NewRegistry()
+	breaker := clientlib.NewCircuitBreaker(config.Jobcoin.BreakerThreshold, config.Jobcoin.BreakerCooldown.Std())
+	jobcoinClient := &clientlib.JobcoinLib{
+		Client:  &http.Client{Timeout: config.Jobcoin.Timeout.Std()},
+		BaseURL: config.Jobcoin.BaseURL,
+		Retry:   config.Jobcoin.RetryPolicy(),
+		Breaker: breaker,
+		Limiter: config.Jobcoin.RateLimiter(),
+		Logger:  logger,
+	}
+
+	instrumentedClient, err := clientlib.NewInstrumentedClient(jobcoinClient, metricsRegistry)
+	if err != nil {
+		exit(logger, "Failed to register Jobcoin API metrics", err)
+	}
+	mixerMetrics, err := mixerlib.NewMetrics(metricsRegistry, store, logger)
+	if err != nil {
+		exit(logger, "Failed to register mixer metrics", err)
+	}
 
 	ml := &mixerlib.MixerLib{
-		JobcoinClient: &clientlib.JobcoinLib{
-			Client: &http.Client{},
-		},
+		JobcoinClient: instrumentedClient,
+		Store:         store,
+		Config:        config.Mixer,
+		HouseQueue:    mixerlib.NewHouseQueue(store, config.Mixer.HouseQueueLimit),
+		Breaker:       breaker,
+		Metrics:       mixerMetrics,
+		Events:        mixerlib.NewEvents(),
+		Logger:        logger,
+	}
+	ml.HouseQueue.Logger = logger
+	if config.Webhooks.Enabled() {
+		webhookClient := mixerlib.NewWebhookClient(config.Webhooks.Timeout.Std(), config.Webhooks.AllowPrivateHosts)
+		ml.Webhooks = mixerlib.NewWebhooks(webhookClient, []byte(config.Webhooks.Secret), config.Webhooks.RetryPolicy(), store)
+		ml.Webhooks.Logger = logger
+		registry.WebhooksEnabled = true
+		registry.AllowPrivateWebhooks = config.Webhooks.AllowPrivateHosts
+	}
+
+	if flag.Arg(0) == "reconcile" {
+		os.Exit(reconcile(ml, os.Stdout))
 	}
 
-	houseAddress, err := uuid.NewUUID()
+	err = ml.LoadHouseAccount()
 	if err != nil {
-		log.Fatal(err)
+		exit(logger, "Failed to load house account", err)
+	}
+	// Sweeps are recovered before rotating so that they finish in the house
+	// address they were started with, before its funds are migrated.
+	err = ml.RecoverSweeps()
+	if err != nil {
+		exit(logger, "Failed to recover sweeps", err)
 	}
-	mixerlib.HouseAddress = houseAddress.String()
-	fmt.Println("The house address has been set to: ", mixerlib.HouseAddress)
+	if *rotateHouse {
+		_, err = ml.RotateHouseAddress(*rotationReason)
+		if err != nil {
+			exit(logger, "Failed to rotate house address", err)
+		}
+	}
+	fmt.Println("The house addresses are: ", strings.Join(ml.House.Mixing(), ", "))
+
+	r := mux.NewRouter()
+	r.Use(api.WithLogging(logger))
+	r.HandleFunc("/api/users", api.CreateNewUserHandler(registry)).Methods("POST")
+	r.HandleFunc("/api/users/{depositAddress}", api.GetUserStatusHandler(ml)).Methods("GET")
+	r.HandleFunc("/api/users/{depositAddress}/events", api.UserEventsHandler(ml)).Methods("GET")
+	r.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})).Methods("GET")
+	r.HandleFunc("/healthz", api.HealthHandler).Methods("GET")
+	r.HandleFunc("/readyz", api.ReadinessHandler(ml)).Methods("GET")
 
-	go ml.PollForNewDeposits(userTicker, userChan, houseChan)
-	go ml.PollForUserReturns(houseTicker, houseChan)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	log.Fatal(http.ListenAndServe(jobcoin.MixerPort, r))
+	userTicker := time.NewTicker(config.Mixer.DepositPollInterval.Std())
+	houseTicker := time.NewTicker(config.Mixer.HousePollInterval.Std())
+
+	var pollers sync.WaitGroup
+	pollers.Add(2)
+	go func() {
+		defer pollers.Done()
+		ml.PollForNewDeposits(ctx, userTicker)
+	}()
+	go func() {
+		defer pollers.Done()
+		ml.PollForUserReturns(ctx, houseTicker)
+	}()
+
+	// Webhooks are stopped after the pollers so that events published while
+	// the pollers finish are still sent or saved as dead letters.
+	webhooksCtx, stopWebhooks := context.WithCancel(context.Background())
+	webhooksDone := make(chan struct{})
+	go func() {
+		defer close(webhooksDone)
+		if ml.Webhooks != nil {
+			ml.Webhooks.Run(webhooksCtx)
+		}
+	}()
+
+	server := &http.Server{
+		Addr:     config.API.Port,
+		Handler:  r,
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+	// Event streams never finish on their own, so they are ended on shutdown
+	// rather than holding it up.
+	server.RegisterOnShutdown(ml.Events.Close)
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			exit(logger, "Failed to start server", err)
+		}
+	}()
+
+	<-ctx.Done()
+	logger.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Error("Failed to shut down server", "error", err)
+	}
+
+	pollers.Wait()
+	stopWebhooks()
+	<-webhooksDone
+
+	err = store.Close()
+	if err != nil {
+		exit(logger, "Failed to save state file", err)
+	}
+}
+
+// exit logs err and exits with status 1. It is only used while the mixer is
+// starting up or shutting down, never while handling a request.
+func exit(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// reconcile compares the saved mixer state with the Jobcoin network once and
+// writes the report to out. The state file is only read, so it is safe to run
+// while another mixer-api is serving. It returns the exit code, which is 1 if
+// the reconciliation failed or found any discrepancy.
+func reconcile(ml *mixerlib.MixerLib, out io.Writer) int {
+	house, err := ml.Store.HouseAccount()
+	if err != nil {
+		ml.Logger.Error("Failed to load house account", "error", err)
+		return 1
+	}
+	if house.Address == "" {
+		ml.Logger.Error("No house account has been saved yet, start mixer-api first")
+		return 1
+	}
+	ml.House = house
+
+	report, err := ml.Reconcile()
+	if err != nil {
+		ml.Logger.Error("Failed to reconcile with Jobcoin", "error", err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "Reconciled at %s\n", report.CheckedAt.Format(time.RFC3339))
+	fmt.Fprintf(out, "Owed to users:  %s\n", report.Owed)
+	fmt.Fprintf(out, "House balance:  %s\n", report.HouseBalance)
+	fmt.Fprintf(out, "Fees collected: %s\n", report.FeesCollected)
+	fmt.Fprintf(out, "Unswept:        %s\n", report.Unswept)
+	if len(report.Discrepancies) == 0 {
+		fmt.Fprintln(out, "No discrepancies found.")
+		return 0
+	}
+
+	fmt.Fprintf(out, "%d discrepancies found:\n", len(report.Discrepancies))
+	for _, d := range report.Discrepancies {
+		fmt.Fprintf(out, "  %s\n", d)
+	}
+	return 1
 }
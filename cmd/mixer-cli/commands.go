@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ckaminer/jobcoin/api"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/ckaminer/jobcoin/mixerlib"
+)
+
+const registerInstruction = `
+Welcome to the Jobcoin mixer!
+Please enter a comma-separated list of new, unused Jobcoin addresses
+where your mixed Jobcoins will be sent. Example:
+
+	./bin/mixer-cli register --addresses=bravo,tango,delta
+`
+
+// registerCommand creates a mixer user with the return addresses given by
+// --addresses, and the webhook URL and refund address given by --webhook-url
+// and --refund-address if any.
+func registerCommand(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("register", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	addresses := flags.String("addresses", "", "comma-separated list of new, unused Jobcoin addresses")
+	webhookURL := flags.String("webhook-url", "", "URL to send the user's events to, if the mixer has webhooks enabled")
+	refundAddress := flags.String("refund-address", "", "Jobcoin address to refund deposits outside the deposit limits to, if the mixer refunds them")
+	err := flags.Parse(args)
+	if err != nil {
+		return &usageError{"Usage: mixer-cli register --addresses=<a,b,...> [--webhook-url=<url>] [--refund-address=<address>]"}
+	}
+
+	trimmed := strings.TrimSpace(*addresses)
+	if trimmed == "" {
+		return &usageError{registerInstruction}
+	}
+
+	newUser := mixerlib.MixerUser{
+		ReturnAddresses: strings.Split(strings.ToLower(trimmed), ","),
+		WebhookURL:      *webhookURL,
+		RefundAddress:   strings.ToLower(strings.TrimSpace(*refundAddress)),
+	}
+	createdUser, err := createMixerUser(c.client, c.config.API.UserEndpoint(), newUser)
+	if err != nil {
+		return err
+	}
+
+	c.print(createdUser, func(w io.Writer) {
+		fmt.Fprintf(w, `
+You may now send Jobcoins to address %s.
+
+They will be mixed into %s and sent to your destination addresses.
+`, createdUser.DepositAddress, createdUser.ReturnAddresses)
+	})
+	return nil
+}
+
+// statusCommand shows how far along a user's funds are.
+func statusCommand(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return &usageError{"Usage: mixer-cli status <depositAddress>"}
+	}
+
+	status, err := getUserStatus(c.client, c.config.API.UserStatusEndpoint(args[0]))
+	if err != nil {
+		return err
+	}
+
+	c.print(status, func(w io.Writer) {
+		writeStatus(w, status)
+	})
+	return nil
+}
+
+// watchCommand follows the stream of events for a user, showing each as it
+// happens, until all of their funds have been returned, their deposit address
+// expires or the command is interrupted.
+func watchCommand(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return &usageError{"Usage: mixer-cli watch <depositAddress>"}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.config.API.UserEventsEndpoint(args[0]), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	res, err := c.stream.Do(req)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return readMixerError(res)
+	}
+
+	err = readEvents(res.Body, func(name string, data []byte) (bool, error) {
+		if name == api.StatusEvent {
+			var status mixerlib.UserStatus
+			err := json.Unmarshal(data, &status)
+			if err != nil {
+				return false, err
+			}
+			c.print(status, func(w io.Writer) {
+				fmt.Fprintf(
+					w, "%s %s: deposited %s, fee %s, returned %s, %s remaining\n",
+					time.Now().Format("15:04:05"), status.State, status.Deposited, status.Fee, totalReturned(status), status.Remaining,
+				)
+			})
+			return status.State == mixerlib.StateComplete || status.State == mixerlib.StateExpired, nil
+		}
+
+		var event mixerlib.Event
+		err := json.Unmarshal(data, &event)
+		if err != nil {
+			return false, err
+		}
+		c.print(event, func(w io.Writer) {
+			fmt.Fprintf(w, "%s %s\n", event.Time.Local().Format("15:04:05"), describeEvent(event))
+		})
+		return event.Kind == mixerlib.EventComplete || event.Kind == mixerlib.EventExpired, nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// readEvents reads Server-Sent Events from r and passes the name and data of
+// each to handle, until handle returns true or an error. It returns an error if
+// the stream ends first.
+func readEvents(r io.Reader, handle func(name string, data []byte) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	var name string
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data == nil {
+				continue
+			}
+			done, err := handle(name, data)
+			if done || err != nil {
+				return err
+			}
+			name, data = "", nil
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("the mixer API closed the event stream")
+}
+
+// describeEvent says what happened in event for people to read.
+func describeEvent(event mixerlib.Event) string {
+	switch event.Kind {
+	case mixerlib.EventDepositDetected:
+		return fmt.Sprintf("Deposit of %s Jobcoin received", event.Amount)
+	case mixerlib.EventFeeCharged:
+		return fmt.Sprintf("Fee of %s Jobcoin charged", event.Amount)
+	case mixerlib.EventSwept:
+		return fmt.Sprintf("%s Jobcoin moved into the mixer", event.Amount)
+	case mixerlib.EventPayoutSent:
+		return fmt.Sprintf("Sent %s Jobcoin to %s", event.Amount, event.ToAddress)
+	case mixerlib.EventComplete:
+		return "All funds returned"
+	case mixerlib.EventExpired:
+		return "Deposit address expired, a later deposit will still be mixed but may take longer to be noticed"
+	case mixerlib.EventDepositHeld:
+		return fmt.Sprintf("Holding deposit of %s Jobcoin: %s", event.Amount, event.Message)
+	case mixerlib.EventRefunded:
+		return fmt.Sprintf("Refunded %s Jobcoin to %s: %s", event.Amount, event.ToAddress, event.Message)
+	case mixerlib.EventError:
+		return event.Message
+	}
+	return string(event.Kind)
+}
+
+// sendCommand sends Jobcoin from an address the user owns to their deposit
+// address, after checking the deposit address belongs to a mixer user.
+func sendCommand(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 3 {
+		return &usageError{"Usage: mixer-cli send <fromAddress> <depositAddress> <amount>"}
+	}
+	fromAddress, depositAddress := args[0], args[1]
+	amount, err := clientlib.ParseAmount(args[2])
+	if err != nil || amount <= 0 {
+		return &usageError{fmt.Sprintf("Invalid amount %q, it must be a positive number of Jobcoin", args[2])}
+	}
+
+	_, err = getUserStatus(c.client, c.config.API.UserStatusEndpoint(depositAddress))
+	if err != nil {
+		return fmt.Errorf("could not find deposit address %s: %s", depositAddress, err)
+	}
+
+	err = c.jobcoinClient().SendJobcoin(fromAddress, depositAddress, amount)
+	if err != nil {
+		return err
+	}
+
+	tx := clientlib.JobcoinTx{FromAddress: fromAddress, ToAddress: depositAddress, Amount: amount}
+	c.print(tx, func(w io.Writer) {
+		fmt.Fprintf(w, "Sent %s Jobcoin from %s to deposit address %s.\n", amount, fromAddress, depositAddress)
+	})
+	return nil
+}
+
+// addressBalance is the result of balanceCommand.
+type addressBalance struct {
+	Address      string                `json:"address"`
+	Balance      clientlib.Amount      `json:"balance"`
+	Transactions []clientlib.JobcoinTx `json:"transactions"`
+}
+
+// balanceCommand shows the balance of a Jobcoin address.
+func balanceCommand(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return &usageError{"Usage: mixer-cli balance <address>"}
+	}
+
+	info, err := c.jobcoinClient().GetAddressInfo(args[0])
+	if err != nil {
+		return err
+	}
+
+	result := addressBalance{Address: args[0], Balance: info.Balance, Transactions: info.Transactions}
+	c.print(result, func(w io.Writer) {
+		fmt.Fprintf(w, "%s has a balance of %s Jobcoin from %d transactions.\n", result.Address, result.Balance, len(result.Transactions))
+	})
+	return nil
+}
+
+func writeStatus(w io.Writer, status mixerlib.UserStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Deposit address:\t%s\n", status.DepositAddress)
+	fmt.Fprintf(tw, "State:\t%s\n", status.State)
+	fmt.Fprintf(tw, "Deposited:\t%s\n", status.Deposited)
+	fmt.Fprintf(tw, "Fee:\t%s\n", status.Fee)
+	fmt.Fprintf(tw, "Remaining:\t%s\n", status.Remaining)
+	fmt.Fprintf(tw, "Returned:\t%s\n", totalReturned(status))
+
+	addresses := []string{}
+	for address := range status.Returned {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		fmt.Fprintf(tw, "  %s\t%s\n", address, status.Returned[address])
+	}
+	if status.Held > 0 {
+		fmt.Fprintf(tw, "Held:\t%s (%s)\n", status.Held, status.HoldReason)
+	}
+	if status.Refunded > 0 {
+		fmt.Fprintf(tw, "Refunded:\t%s\n", status.Refunded)
+	}
+	if limits := describeLimits(status.Limits); limits != "" {
+		fmt.Fprintf(tw, "Deposit limits:\t%s\n", limits)
+	}
+	if status.ExpiresAt != nil {
+		fmt.Fprintf(tw, "Expires:\t%s\n", status.ExpiresAt.Format(time.RFC1123))
+	}
+	if status.EstimatedCompletion != nil {
+		fmt.Fprintf(tw, "Estimated completion:\t%s\n", status.EstimatedCompletion.Format(time.RFC1123))
+	}
+	tw.Flush()
+}
+
+// describeLimits lists the deposit limits that are set, or returns "" if none are.
+func describeLimits(limits mixerlib.DepositLimits) string {
+	parts := []string{}
+	if limits.Min > 0 {
+		parts = append(parts, fmt.Sprintf("min %s", limits.Min))
+	}
+	if limits.Max > 0 {
+		parts = append(parts, fmt.Sprintf("max %s", limits.Max))
+	}
+	if limits.Remaining != nil {
+		parts = append(parts, fmt.Sprintf("%s remaining", *limits.Remaining))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func totalReturned(status mixerlib.UserStatus) clientlib.Amount {
+	var total clientlib.Amount
+	for _, amount := range status.Returned {
+		total = total + amount
+	}
+	return total
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ckaminer/jobcoin/api"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/ckaminer/jobcoin/jobcointest"
+	"github.com/ckaminer/jobcoin/mixerlib"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServers are a mixer API and simulated Jobcoin network for the CLI to talk to.
+type testServers struct {
+	mixerURL string
+	store    mixerlib.Store
+	ledger   *jobcointest.Ledger
+}
+
+func newTestServers(t *testing.T) testServers {
+	ledger := jobcointest.NewLedger()
+	jobcoinServer := httptest.NewServer(jobcointest.NewHandler(ledger))
+	t.Cleanup(jobcoinServer.Close)
+	t.Setenv("JOBCOIN_BASE_URL", jobcoinServer.URL+"/api")
+	t.Setenv("MIXER_CONFIG", "")
+
+	store := mixerlib.NewMemoryStore()
+	registry, _ := mixerlib.NewRegistry(store)
+	r := mux.NewRouter()
+	r.HandleFunc("/api/users", api.CreateNewUserHandler(registry)).Methods("POST")
+	ml := &mixerlib.MixerLib{Store: store, Events: mixerlib.NewEvents()}
+	r.HandleFunc("/api/users/{depositAddress}", api.GetUserStatusHandler(ml)).Methods("GET")
+	r.HandleFunc("/api/users/{depositAddress}/events", api.UserEventsHandler(ml)).Methods("GET")
+	mixerServer := httptest.NewServer(r)
+	t.Cleanup(mixerServer.Close)
+
+	return testServers{mixerURL: mixerServer.URL + "/api", store: store, ledger: ledger}
+}
+
+// runCLI runs the CLI against the test servers and returns the exit code and output.
+func (s testServers) runCLI(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append([]string{"--server", s.mixerURL}, args...), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// Begin run tests
+func TestRun_ReturnsUsageErrorForUnknownCommand(t *testing.T) {
+	servers := newTestServers(t)
+
+	code, _, stderr := servers.runCLI("mix")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "Usage: mixer-cli")
+}
+
+// Begin register tests
+func TestRegister_PrintsDepositAddress(t *testing.T) {
+	servers := newTestServers(t)
+
+	code, stdout, _ := servers.runCLI("register", "--addresses=How,now")
+
+	users, _ := servers.store.Users()
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, []string{"how", "now"}, users[0].ReturnAddresses)
+	assert.Contains(t, stdout, "You may now send Jobcoins to address "+users[0].DepositAddress)
+}
+
+func TestRegister_PrintsJSON(t *testing.T) {
+	servers := newTestServers(t)
+
+	code, stdout, _ := servers.runCLI("--output", "json", "register", "--addresses=how,now")
+
+	var user mixerlib.MixerUser
+	err := json.Unmarshal([]byte(stdout), &user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, 36, len(user.DepositAddress))
+	assert.Equal(t, []string{"how", "now"}, user.ReturnAddresses)
+}
+
+func TestRegister_ReturnsUsageErrorWithoutAddresses(t *testing.T) {
+	servers := newTestServers(t)
+
+	code, _, stderr := servers.runCLI("register")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "Welcome to the Jobcoin mixer!")
+}
+
+// Begin status tests
+func TestStatus_PrintsUserStatus(t *testing.T) {
+	servers := newTestServers(t)
+	servers.store.AddUser(mixerlib.MixerUser{DepositAddress: "deposit-one", ReturnAddresses: []string{"return-one"}})
+
+	code, stdout, _ := servers.runCLI("status", "deposit-one")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "State:            awaiting_deposit\n")
+	assert.Contains(t, stdout, "  return-one      0\n")
+}
+
+func TestStatus_PrintsHeldDeposit(t *testing.T) {
+	servers := newTestServers(t)
+	servers.store.AddUser(mixerlib.MixerUser{DepositAddress: "deposit-one", ReturnAddresses: []string{"return-one"}})
+	servers.store.UpdateProgress("deposit-one", func(p *mixerlib.DistributionProgress) {
+		p.Held = clientlib.MustParseAmount("0.001")
+		p.HoldReason = "Deposit is below the minimum of 0.01 Jobcoin"
+	})
+
+	code, stdout, _ := servers.runCLI("status", "deposit-one")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "Held:             0.001 (Deposit is below the minimum of 0.01 Jobcoin)\n")
+}
+
+func TestStatus_ReturnsErrorForUnknownUser(t *testing.T) {
+	servers := newTestServers(t)
+
+	code, _, stderr := servers.runCLI("status", "deposit-one")
+
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "Error: User not found\n", stderr)
+}
+
+// Begin watch tests
+func TestWatch_ReturnsOnceComplete(t *testing.T) {
+	servers := newTestServers(t)
+	servers.store.AddUser(mixerlib.MixerUser{DepositAddress: "deposit-one", ReturnAddresses: []string{"return-one"}})
+	servers.store.UpdateProgress("deposit-one", func(p *mixerlib.DistributionProgress) {
+		p.State = mixerlib.StateComplete
+	})
+
+	code, stdout, _ := servers.runCLI("--output", "json", "watch", "deposit-one")
+
+	var status mixerlib.UserStatus
+	err := json.Unmarshal([]byte(stdout), &status)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, mixerlib.StateComplete, status.State)
+}
+
+func TestWatch_PrintsEventsUntilComplete(t *testing.T) {
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/users/deposit-one/events", r.URL.Path)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: status\ndata: {\"state\":\"awaiting_deposit\",\"deposited\":\"0\",\"fee\":\"0\",\"remaining\":\"0\"}\n\n")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "event: payout_sent\ndata: {\"kind\":\"payout_sent\",\"amount\":\"1.5\",\"toAddress\":\"return-one\"}\n\n")
+		fmt.Fprint(w, "event: complete\ndata: {\"kind\":\"complete\"}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"kind\":\"error\",\"message\":\"Not read\"}\n\n")
+	}))
+	t.Cleanup(stream.Close)
+	t.Setenv("MIXER_CONFIG", "")
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"--server", stream.URL + "/api", "watch", "deposit-one"}, &stdout, &stderr)
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, 3, len(lines))
+	assert.True(t, strings.HasSuffix(lines[0], " awaiting_deposit: deposited 0, fee 0, returned 0, 0 remaining"))
+	assert.True(t, strings.HasSuffix(lines[1], " Sent 1.5 Jobcoin to return-one"))
+	assert.True(t, strings.HasSuffix(lines[2], " All funds returned"))
+}
+
+func TestWatch_ReturnsErrorForUnknownUser(t *testing.T) {
+	servers := newTestServers(t)
+
+	code, _, stderr := servers.runCLI("watch", "deposit-one")
+
+	assert.Equal(t, exitError, code)
+	assert.Equal(t, "Error: User not found\n", stderr)
+}
+
+// Begin send tests
+func TestSend_SendsJobcoinToDepositAddress(t *testing.T) {
+	servers := newTestServers(t)
+	servers.store.AddUser(mixerlib.MixerUser{DepositAddress: "deposit-one", ReturnAddresses: []string{"return-one"}})
+	servers.ledger.Create("alice", 10*clientlib.Coin)
+
+	code, stdout, _ := servers.runCLI("send", "alice", "deposit-one", "2.5")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "Sent 2.5 Jobcoin from alice to deposit address deposit-one.\n", stdout)
+	assert.Equal(t, clientlib.MustParseAmount("2.5"), servers.ledger.AddressInfo("deposit-one").Balance)
+}
+
+func TestSend_DoesNotSendToUnknownDepositAddress(t *testing.T) {
+	servers := newTestServers(t)
+	servers.ledger.Create("alice", 10*clientlib.Coin)
+
+	code, _, stderr := servers.runCLI("send", "alice", "deposit-one", "2.5")
+
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "could not find deposit address deposit-one")
+	assert.Equal(t, 10*clientlib.Coin, servers.ledger.AddressInfo("alice").Balance)
+}
+
+func TestSend_ReturnsUsageErrorForInvalidAmount(t *testing.T) {
+	servers := newTestServers(t)
+
+	code, _, stderr := servers.runCLI("send", "alice", "deposit-one", "-1")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "Invalid amount")
+}
+
+// Begin balance tests
+func TestBalance_PrintsAddressBalance(t *testing.T) {
+	servers := newTestServers(t)
+	servers.ledger.Create("alice", 10*clientlib.Coin)
+
+	code, stdout, _ := servers.runCLI("--output", "json", "balance", "alice")
+
+	var balance addressBalance
+	err := json.Unmarshal([]byte(stdout), &balance)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "alice", balance.Address)
+	assert.Equal(t, 10*clientlib.Coin, balance.Balance)
+	assert.Equal(t, 1, len(balance.Transactions))
+}
@@ -2,14 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 
 	"github.com/ckaminer/jobcoin/api"
 	"github.com/ckaminer/jobcoin/clientlib"
@@ -18,81 +20,197 @@ import (
 	"github.com/ckaminer/jobcoin/mixerlib"
 )
 
-func inputDepositAddresses() []string {
+const usage = `Usage: mixer-cli [flags] <command> [arguments]
 
-	var svar string
-	flag.StringVar(&svar, "addresses", "", "comma-separated list of new, unused Jobcoin addresses")
+Commands:
+  register --addresses=<a,b,...>               create a mixer user returning funds to the given addresses,
+           [--webhook-url=<url>]               sending their events to the given URL
+           [--refund-address=<address>]        and refunding deposits outside the limits to the given address
+  status <depositAddress>                      show how far along a user's funds are
+  watch <depositAddress>                       show a user's progress as it happens until it is complete or expired
+  send <fromAddress> <depositAddress> <amount> send Jobcoin you own to a deposit address
+  balance <address>                            show the balance of a Jobcoin address
 
-	flag.Parse()
+Flags:
+`
 
-	trimmed := strings.TrimSpace(svar)
-	if trimmed == "" {
-		instruction := `
-Welcome to the Jobcoin mixer!
-Please enter a comma-separated list of new, unused Jobcoin addresses
-where your mixed Jobcoins will be sent. Example:
+// Exit codes returned by run.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
 
-	./bin/mixer-cli --addresses=bravo,tango,delta
-`
-		fmt.Println(instruction)
-		os.Exit(-1)
-	}
+// The formats results can be written in.
+const (
+	textOutput = "text"
+	jsonOutput = "json"
+)
+
+// cli holds everything a command needs. stream is used for requests that
+// stay open, so it has no timeout.
+type cli struct {
+	config jobcoin.Config
+	client clientlib.HTTPClient
+	stream clientlib.HTTPClient
+	output string
+	stdout io.Writer
+	stderr io.Writer
+}
 
-	return strings.Split(strings.ToLower(trimmed), ",")
+// command runs a subcommand with the arguments following its name.
+type command func(ctx context.Context, c *cli, args []string) error
+
+var commands = map[string]command{
+	"register": registerCommand,
+	"status":   statusCommand,
+	"watch":    watchCommand,
+	"send":     sendCommand,
+	"balance":  balanceCommand,
+}
+
+// usageError is returned by a command given invalid arguments.
+type usageError struct {
+	message string
 }
 
-func createMixerUser(client clientlib.HTTPClient, returnAddresses []string) (mixerlib.MixerUser, error) {
-	reqBody, err := json.Marshal(mixerlib.MixerUser{ReturnAddresses: returnAddresses})
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run parses the global flags, runs the chosen command and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("mixer-cli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", os.Getenv("MIXER_CONFIG"), "path to a JSON config file")
+	server := flags.String("server", "", "base URL of the mixer API, e.g. http://localhost:8080/api (default api.baseURL from the config)")
+	output := flags.String("output", textOutput, "output format, text or json")
+	err := flags.Parse(args)
 	if err != nil {
-		log.Println("Error creating request body: ", err)
-		return mixerlib.MixerUser{}, err
+		return exitUsage
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		return exitUsage
+	}
+	if *output != textOutput && *output != jsonOutput {
+		fmt.Fprintf(stderr, "--output must be %q or %q\n", textOutput, jsonOutput)
+		return exitUsage
 	}
 
-	req, _ := http.NewRequest("POST", jobcoin.MixerUserEndpoint, bytes.NewReader(reqBody))
+	config, err := jobcoin.LoadConfig(*configPath)
 	if err != nil {
-		log.Println("Error creating request: ", err)
-		return mixerlib.MixerUser{}, err
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitError
+	}
+	if *server != "" {
+		config.API.BaseURL = *server
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	res, err := client.Do(req)
+	c := &cli{
+		config: config,
+		client: &http.Client{Timeout: config.Jobcoin.Timeout.Std()},
+		stream: &http.Client{},
+		output: *output,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	err = cmd(ctx, c, flags.Args()[1:])
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(stderr, usageErr.message)
+		return exitUsage
+	}
 	if err != nil {
-		log.Println("Request error: ", err)
-		return mixerlib.MixerUser{}, err
+		fmt.Fprintf(stderr, "Error: %s\n", err)
+		return exitError
 	}
-	if res.StatusCode != http.StatusCreated {
-		var apiErr api.ErrorPayload
-		err = json.NewDecoder(res.Body).Decode(&apiErr)
-		if err != nil {
-			log.Println("Error decoding api response: ", err)
-			return mixerlib.MixerUser{}, err
-		}
-		defer res.Body.Close()
-		return mixerlib.MixerUser{}, errors.New(apiErr.Message)
+	return exitOK
+}
+
+// print writes v as JSON if --output=json was given, and otherwise calls text
+// to write it for people to read.
+func (c *cli) print(v interface{}, text func(w io.Writer)) {
+	if c.output == jsonOutput {
+		json.NewEncoder(c.stdout).Encode(v)
+		return
 	}
+	text(c.stdout)
+}
 
-	var createdUser mixerlib.MixerUser
-	err = json.NewDecoder(res.Body).Decode(&createdUser)
+// jobcoinClient returns a client for the Jobcoin API configured by the config file.
+func (c *cli) jobcoinClient() *clientlib.JobcoinLib {
+	return &clientlib.JobcoinLib{
+		Client:  c.client,
+		BaseURL: c.config.Jobcoin.BaseURL,
+		Retry:   c.config.Jobcoin.RetryPolicy(),
+	}
+}
+
+func createMixerUser(client clientlib.HTTPClient, userEndpoint string, user mixerlib.MixerUser) (mixerlib.MixerUser, error) {
+	reqBody, err := json.Marshal(user)
 	if err != nil {
-		log.Println("Error decoding api response: ", err)
 		return mixerlib.MixerUser{}, err
 	}
-	defer res.Body.Close()
 
-	return createdUser, nil
+	req, err := http.NewRequest("POST", userEndpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return mixerlib.MixerUser{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var createdUser mixerlib.MixerUser
+	err = doMixerRequest(client, req, http.StatusCreated, &createdUser)
+	return createdUser, err
 }
 
-func main() {
-	addresses := inputDepositAddresses()
-	client := &http.Client{}
+func getUserStatus(client clientlib.HTTPClient, statusEndpoint string) (mixerlib.UserStatus, error) {
+	req, err := http.NewRequest("GET", statusEndpoint, nil)
+	if err != nil {
+		return mixerlib.UserStatus{}, err
+	}
 
-	createdUser, err := createMixerUser(client, addresses)
+	var status mixerlib.UserStatus
+	err = doMixerRequest(client, req, http.StatusOK, &status)
+	return status, err
+}
+
+// doMixerRequest sends req to the mixer API and decodes the response into
+// result if it has the expected status, or returns the error message the API
+// responded with if not.
+func doMixerRequest(client clientlib.HTTPClient, req *http.Request, expectedStatus int, result interface{}) error {
+	res, err := client.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expectedStatus {
+		return readMixerError(res)
 	}
 
-	fmt.Printf(`
-You may now send Jobcoins to address %s.
+	return json.NewDecoder(res.Body).Decode(result)
+}
 
-They will be mixed into %s and sent to your destination addresses.`, createdUser.DepositAddress, createdUser.ReturnAddresses)
+// readMixerError returns the error message the mixer API responded with.
+func readMixerError(res *http.Response) error {
+	var apiErr api.ErrorPayload
+	err := json.NewDecoder(res.Body).Decode(&apiErr)
+	if err != nil {
+		return err
+	}
+	return errors.New(apiErr.Message)
 }
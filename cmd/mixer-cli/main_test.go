@@ -6,9 +6,12 @@ import (
 	"testing"
 
 	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/ckaminer/jobcoin/mixerlib"
 	"github.com/stretchr/testify/assert"
 )
 
+const testUserEndpoint = "http://localhost:8080/api/users"
+
 // Begin createMixerUser tests
 func TestCreateMixerUser_ReturnsCreatedUser(t *testing.T) {
 	mockResponseBody := []byte(`
@@ -24,7 +27,7 @@ func TestCreateMixerUser_ReturnsCreatedUser(t *testing.T) {
 	client := clientlib.NewClientMock(http.StatusCreated, mockResponseBody, nil)
 
 	returnAddresses := []string{"return-one", "return-two", "return-three"}
-	createdUser, err := createMixerUser(client, returnAddresses)
+	createdUser, err := createMixerUser(client, testUserEndpoint, mixerlib.MixerUser{ReturnAddresses: returnAddresses})
 	if err != nil {
 		t.Errorf("Did not expect error. Got: %s", err.Error())
 	}
@@ -36,7 +39,7 @@ func TestCreateMixerUser_ReturnsCreatedUser(t *testing.T) {
 func TestCreateMixerUser_ReturnsErrorIfRequestFails(t *testing.T) {
 	client := clientlib.NewClientMock(0, nil, errors.New("Request failed"))
 
-	_, err := createMixerUser(client, nil)
+	_, err := createMixerUser(client, testUserEndpoint, mixerlib.MixerUser{})
 	if err == nil {
 		t.Errorf("Expected an error but did not receive one")
 	}
@@ -53,7 +56,7 @@ func TestCreateMixerUser_ReturnsErrorIfFailsToDecodeError(t *testing.T) {
 	client := clientlib.NewClientMock(http.StatusConflict, mockResponseBody, nil)
 
 	returnAddresses := []string{"return-one", "return-two", "return-three"}
-	_, err := createMixerUser(client, returnAddresses)
+	_, err := createMixerUser(client, testUserEndpoint, mixerlib.MixerUser{ReturnAddresses: returnAddresses})
 	if err == nil {
 		t.Errorf("Expected an error but did not receive one")
 	}
@@ -75,7 +78,7 @@ func TestCreateMixerUser_ReturnsErrorIfFailsToDecodeUserResponse(t *testing.T) {
 	client := clientlib.NewClientMock(http.StatusCreated, mockResponseBody, nil)
 
 	returnAddresses := []string{"return-one", "return-two", "return-three"}
-	_, err := createMixerUser(client, returnAddresses)
+	_, err := createMixerUser(client, testUserEndpoint, mixerlib.MixerUser{ReturnAddresses: returnAddresses})
 	if err == nil {
 		t.Errorf("Expected an error but did not receive one")
 	}
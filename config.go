@@ -1,11 +1,613 @@
 package jobcoin
 
-// Configuration defines a base minimum configuration for the jobcoin mixer
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// Config defines the configuration for the Jobcoin mixer. It is loaded from a
+// JSON file with LoadConfig, and any value can be overridden by an environment
+// variable, listed next to each field.
+type Config struct {
+	Jobcoin  JobcoinConfig `json:"jobcoin"`
+	Mixer    MixerConfig   `json:"mixer"`
+	API      APIConfig     `json:"api"`
+	Log      LogConfig     `json:"log"`
+	Webhooks WebhookConfig `json:"webhooks"`
+}
+
+// JobcoinConfig configures access to the Jobcoin network.
+type JobcoinConfig struct {
+	BaseURL          string   `json:"baseURL"`          // JOBCOIN_BASE_URL
+	Timeout          Duration `json:"timeout"`          // JOBCOIN_TIMEOUT
+	MaxAttempts      int      `json:"maxAttempts"`      // JOBCOIN_MAX_ATTEMPTS
+	InitialBackoff   Duration `json:"initialBackoff"`   // JOBCOIN_INITIAL_BACKOFF
+	MaxBackoff       Duration `json:"maxBackoff"`       // JOBCOIN_MAX_BACKOFF
+	BreakerThreshold int      `json:"breakerThreshold"` // JOBCOIN_BREAKER_THRESHOLD
+	BreakerCooldown  Duration `json:"breakerCooldown"`  // JOBCOIN_BREAKER_COOLDOWN
+	RateLimit        float64  `json:"rateLimit"`        // JOBCOIN_RATE_LIMIT, requests a second, 0 to disable
+	RateBurst        int      `json:"rateBurst"`        // JOBCOIN_RATE_BURST
+}
+
+// RetryPolicy returns the retry policy described by the configuration.
+func (c JobcoinConfig) RetryPolicy() clientlib.RetryPolicy {
+	return clientlib.RetryPolicy{
+		MaxAttempts:    c.MaxAttempts,
+		InitialBackoff: c.InitialBackoff.Std(),
+		MaxBackoff:     c.MaxBackoff.Std(),
+	}
+}
+
+// RateLimiter returns the rate limiter described by the configuration, or nil
+// if requests are not limited.
+func (c JobcoinConfig) RateLimiter() *clientlib.RateLimiter {
+	if c.RateLimit == 0 {
+		return nil
+	}
+	return clientlib.NewRateLimiter(c.RateLimit, c.RateBurst)
+}
+
+// MixerConfig configures how the mixer moves user funds.
+type MixerConfig struct {
+	BankFund              string             `json:"bankFund"`              // MIXER_BANK_FUND
+	ServiceFeeBasisPoints int64              `json:"serviceFeeBasisPoints"` // MIXER_SERVICE_FEE_BASIS_POINTS
+	DistributionIncrement clientlib.Amount   `json:"distributionIncrement"` // MIXER_DISTRIBUTION_INCREMENT
+	DepositPollInterval   Duration           `json:"depositPollInterval"`   // MIXER_DEPOSIT_POLL_INTERVAL
+	DepositPollWorkers    int                `json:"depositPollWorkers"`    // MIXER_DEPOSIT_POLL_WORKERS
+	HousePollInterval     Duration           `json:"housePollInterval"`     // MIXER_HOUSE_POLL_INTERVAL
+	HouseQueueLimit       int                `json:"houseQueueLimit"`       // MIXER_HOUSE_QUEUE_LIMIT, 0 to disable
+	StatePath             string             `json:"statePath"`             // MIXER_STATE_PATH
+	PayoutDistribution    string             `json:"payoutDistribution"`    // MIXER_PAYOUT_DISTRIBUTION
+	PayoutMinDelay        Duration           `json:"payoutMinDelay"`        // MIXER_PAYOUT_MIN_DELAY
+	PayoutMaxDelay        Duration           `json:"payoutMaxDelay"`        // MIXER_PAYOUT_MAX_DELAY
+	PayoutMeanInterval    Duration           `json:"payoutMeanInterval"`    // MIXER_PAYOUT_MEAN_INTERVAL
+	AmountStrategy        string             `json:"amountStrategy"`        // MIXER_AMOUNT_STRATEGY
+	MinPayoutAmount       clientlib.Amount   `json:"minPayoutAmount"`       // MIXER_MIN_PAYOUT_AMOUNT
+	MaxPayoutAmount       clientlib.Amount   `json:"maxPayoutAmount"`       // MIXER_MAX_PAYOUT_AMOUNT
+	Denominations         []clientlib.Amount `json:"denominations"`         // MIXER_DENOMINATIONS, comma separated
+	LogNormalSigma        float64            `json:"logNormalSigma"`        // MIXER_LOG_NORMAL_SIGMA
+	HousePoolSize         int                `json:"housePoolSize"`         // MIXER_HOUSE_POOL_SIZE
+	HouseShuffleInterval  Duration           `json:"houseShuffleInterval"`  // MIXER_HOUSE_SHUFFLE_INTERVAL, 0 to disable
+	ReconcileInterval     Duration           `json:"reconcileInterval"`     // MIXER_RECONCILE_INTERVAL, 0 to disable
+	PollStallTimeout      Duration           `json:"pollStallTimeout"`      // MIXER_POLL_STALL_TIMEOUT
+	DepositTTL            Duration           `json:"depositTTL"`            // MIXER_DEPOSIT_TTL, 0 to disable
+	ExpiredSweepInterval  Duration           `json:"expiredSweepInterval"`  // MIXER_EXPIRED_SWEEP_INTERVAL
+	ArchiveAfter          Duration           `json:"archiveAfter"`          // MIXER_ARCHIVE_AFTER, 0 to disable
+	MinDeposit            clientlib.Amount   `json:"minDeposit"`            // MIXER_MIN_DEPOSIT, 0 to disable
+	MaxDeposit            clientlib.Amount   `json:"maxDeposit"`            // MIXER_MAX_DEPOSIT, 0 to disable
+	UserDepositLimit      clientlib.Amount   `json:"userDepositLimit"`      // MIXER_USER_DEPOSIT_LIMIT, 0 to disable
+	OutOfRangePolicy      string             `json:"outOfRangePolicy"`      // MIXER_OUT_OF_RANGE_POLICY
+	OutOfRangeFee         clientlib.Amount   `json:"outOfRangeFee"`         // MIXER_OUT_OF_RANGE_FEE
+}
+
+// The strategies for sizing each round of payouts to a user.
+const (
+	// FixedAmounts returns DistributionIncrement each round.
+	FixedAmounts = "fixed"
+	// UniformAmounts returns a random amount between MinPayoutAmount and MaxPayoutAmount.
+	UniformAmounts = "uniform"
+	// DenominationAmounts returns one of Denominations, which are shared by every user.
+	DenominationAmounts = "denominations"
+	// LogNormalAmounts returns a log-normally distributed amount around
+	// DistributionIncrement with a spread of LogNormalSigma, kept between
+	// MinPayoutAmount and MaxPayoutAmount.
+	LogNormalAmounts = "lognormal"
+)
+
+// The distributions the delays before a user's payouts can be drawn from.
+const (
+	// ExponentialDistribution spaces payouts with random gaps averaging
+	// PayoutMeanInterval, squeezed into the delay window if needed.
+	ExponentialDistribution = "exponential"
+	// UniformDistribution spreads payouts evenly at random over the delay window.
+	UniformDistribution = "uniform"
+)
+
+// The policies for deposits outside MinDeposit and MaxDeposit, or that would
+// take a user over UserDepositLimit.
+const (
+	// HoldDeposits leaves the deposit in the deposit address until further
+	// deposits bring it within the limits.
+	HoldDeposits = "hold"
+	// RefundDeposits sends the deposit to the refund address the user gave
+	// when registering, or holds it if they gave none.
+	RefundDeposits = "refund"
+	// FlatFeeDeposits sweeps the deposit as usual but charges OutOfRangeFee
+	// instead of the service fee. Deposits no larger than the fee are held.
+	FlatFeeDeposits = "flatFee"
+)
+
+// APIConfig configures the mixer API server and how the CLI reaches it.
+type APIConfig struct {
+	Port    string `json:"port"`    // MIXER_PORT
+	BaseURL string `json:"baseURL"` // MIXER_BASE_URL
+}
+
+// WebhookConfig configures the webhooks sent to users who registered a
+// webhook URL. Webhooks are disabled unless Secret is set.
+type WebhookConfig struct {
+	Secret         string   `json:"secret"`         // MIXER_WEBHOOK_SECRET
+	Timeout        Duration `json:"timeout"`        // MIXER_WEBHOOK_TIMEOUT
+	MaxAttempts    int      `json:"maxAttempts"`    // MIXER_WEBHOOK_MAX_ATTEMPTS
+	InitialBackoff Duration `json:"initialBackoff"` // MIXER_WEBHOOK_INITIAL_BACKOFF
+	MaxBackoff     Duration `json:"maxBackoff"`     // MIXER_WEBHOOK_MAX_BACKOFF
+	// AllowPrivateHosts lets webhook URLs point at loopback, link-local and
+	// private addresses. It is only meant for trying webhooks out locally.
+	AllowPrivateHosts bool `json:"allowPrivateHosts"` // MIXER_WEBHOOK_ALLOW_PRIVATE_HOSTS
+}
+
+// Enabled reports whether webhooks should be sent.
+func (c WebhookConfig) Enabled() bool {
+	return c.Secret != ""
+}
+
+// RetryPolicy returns the retry policy described by the configuration.
+func (c WebhookConfig) RetryPolicy() clientlib.RetryPolicy {
+	return clientlib.RetryPolicy{
+		MaxAttempts:    c.MaxAttempts,
+		InitialBackoff: c.InitialBackoff.Std(),
+		MaxBackoff:     c.MaxBackoff.Std(),
+	}
+}
+
+// The formats log entries can be written in.
 const (
-	BaseURL             = "https://jobcoin.gemini.com/casino-unit/api"
-	AddressesEndpoint   = BaseURL + "/addresses"
-	TransactionEndpoint = BaseURL + "/transactions"
-	MixerPort           = ":8080"
-	MixerBaseURL        = "http://localhost" + MixerPort + "/api"
-	MixerUserEndpoint   = MixerBaseURL + "/users"
+	// JSONLogFormat writes each entry as a JSON object on its own line.
+	JSONLogFormat = "json"
+	// TextLogFormat writes each entry as key=value pairs on its own line.
+	TextLogFormat = "text"
 )
+
+// LogConfig configures how the mixer API logs.
+type LogConfig struct {
+	Level  string `json:"level"`  // MIXER_LOG_LEVEL, one of debug, info, warn or error
+	Format string `json:"format"` // MIXER_LOG_FORMAT
+}
+
+// NewLogger returns a logger writing entries at or above the configured level
+// to w in the configured format.
+func (c LogConfig) NewLogger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Level))
+	options := &slog.HandlerOptions{Level: level}
+	if c.Format == TextLogFormat {
+		return slog.New(slog.NewTextHandler(w, options))
+	}
+	return slog.New(slog.NewJSONHandler(w, options))
+}
+
+// UserEndpoint is the API endpoint used to create mixer users.
+func (c APIConfig) UserEndpoint() string {
+	return c.BaseURL + "/users"
+}
+
+// UserStatusEndpoint is the API endpoint reporting the status of the user
+// with the given deposit address.
+func (c APIConfig) UserStatusEndpoint(depositAddress string) string {
+	return c.UserEndpoint() + "/" + url.PathEscape(depositAddress)
+}
+
+// UserEventsEndpoint is the API endpoint streaming the progress of the user
+// with the given deposit address.
+func (c APIConfig) UserEventsEndpoint(depositAddress string) string {
+	return c.UserStatusEndpoint(depositAddress) + "/events"
+}
+
+// DefaultConfig returns the configuration used for any value not set in the
+// config file or environment.
+func DefaultConfig() Config {
+	return Config{
+		Jobcoin: JobcoinConfig{
+			BaseURL:          "https://jobcoin.gemini.com/casino-unit/api",
+			Timeout:          Duration(10 * time.Second),
+			MaxAttempts:      3,
+			InitialBackoff:   Duration(200 * time.Millisecond),
+			MaxBackoff:       Duration(2 * time.Second),
+			BreakerThreshold: 5,
+			BreakerCooldown:  Duration(30 * time.Second),
+			RateLimit:        10,
+			RateBurst:        20,
+		},
+		Mixer: MixerConfig{
+			BankFund:              "121212-bank-fund-121212",
+			ServiceFeeBasisPoints: 100,
+			DistributionIncrement: 5 * clientlib.Coin,
+			DepositPollInterval:   Duration(5 * time.Second),
+			DepositPollWorkers:    8,
+			HousePollInterval:     Duration(time.Second),
+			HouseQueueLimit:       1000,
+			StatePath:             "mixer-state.json",
+			PayoutDistribution:    ExponentialDistribution,
+			PayoutMinDelay:        Duration(30 * time.Second),
+			PayoutMaxDelay:        Duration(10 * time.Minute),
+			PayoutMeanInterval:    Duration(time.Minute),
+			AmountStrategy:        UniformAmounts,
+			MinPayoutAmount:       clientlib.Coin,
+			MaxPayoutAmount:       10 * clientlib.Coin,
+			Denominations: []clientlib.Amount{
+				clientlib.Coin / 10,
+				clientlib.Coin / 2,
+				clientlib.Coin,
+				2 * clientlib.Coin,
+				5 * clientlib.Coin,
+				10 * clientlib.Coin,
+			},
+			LogNormalSigma:       0.75,
+			HousePoolSize:        3,
+			HouseShuffleInterval: Duration(5 * time.Minute),
+			ReconcileInterval:    Duration(5 * time.Minute),
+			PollStallTimeout:     Duration(time.Minute),
+			DepositTTL:           Duration(24 * time.Hour),
+			ExpiredSweepInterval: Duration(time.Hour),
+			ArchiveAfter:         Duration(7 * 24 * time.Hour),
+			MinDeposit:           clientlib.Coin / 100,
+			OutOfRangePolicy:     HoldDeposits,
+			OutOfRangeFee:        clientlib.Coin / 10,
+		},
+		API: APIConfig{
+			Port:    ":8080",
+			BaseURL: "http://localhost:8080/api",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: JSONLogFormat,
+		},
+		Webhooks: WebhookConfig{
+			Timeout:        Duration(5 * time.Second),
+			MaxAttempts:    6,
+			InitialBackoff: Duration(time.Second),
+			MaxBackoff:     Duration(time.Minute),
+		},
+	}
+}
+
+// LoadConfig returns the default configuration overlaid with the JSON config
+// file at path, if path is not empty, and then with any environment variable
+// overrides. The result is validated before it is returned.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&config)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid config file %s: %s", path, err)
+		}
+	}
+
+	err := config.applyEnv(os.LookupEnv)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return config, config.Validate()
+}
+
+// Validate returns an error describing the first invalid value in the configuration.
+func (c Config) Validate() error {
+	if _, err := url.ParseRequestURI(c.Jobcoin.BaseURL); err != nil {
+		return fmt.Errorf("jobcoin.baseURL is not a valid URL: %q", c.Jobcoin.BaseURL)
+	}
+	if c.Jobcoin.Timeout <= 0 {
+		return errors.New("jobcoin.timeout must be greater than zero")
+	}
+	if c.Jobcoin.MaxAttempts < 1 {
+		return errors.New("jobcoin.maxAttempts must be at least 1")
+	}
+	if c.Jobcoin.InitialBackoff < 0 || c.Jobcoin.MaxBackoff < c.Jobcoin.InitialBackoff {
+		return errors.New("jobcoin.initialBackoff must not be negative or greater than jobcoin.maxBackoff")
+	}
+	if c.Jobcoin.BreakerThreshold < 1 || c.Jobcoin.BreakerCooldown <= 0 {
+		return errors.New("jobcoin.breakerThreshold must be at least 1 and jobcoin.breakerCooldown greater than zero")
+	}
+	if c.Jobcoin.RateLimit < 0 || (c.Jobcoin.RateLimit > 0 && c.Jobcoin.RateBurst < 1) {
+		return errors.New("jobcoin.rateLimit must not be negative and jobcoin.rateBurst must be at least 1")
+	}
+	if c.Mixer.BankFund == "" {
+		return errors.New("mixer.bankFund is required")
+	}
+	if c.Mixer.ServiceFeeBasisPoints < 0 || c.Mixer.ServiceFeeBasisPoints > 10000 {
+		return errors.New("mixer.serviceFeeBasisPoints must be between 0 and 10000")
+	}
+	if c.Mixer.DistributionIncrement <= 0 {
+		return errors.New("mixer.distributionIncrement must be greater than zero")
+	}
+	if c.Mixer.DepositPollInterval <= 0 || c.Mixer.HousePollInterval <= 0 {
+		return errors.New("mixer.depositPollInterval and mixer.housePollInterval must be greater than zero")
+	}
+	if c.Mixer.DepositPollWorkers < 1 {
+		return errors.New("mixer.depositPollWorkers must be at least 1")
+	}
+	if c.Mixer.HouseQueueLimit < 0 {
+		return errors.New("mixer.houseQueueLimit must not be negative")
+	}
+	if c.Mixer.StatePath == "" {
+		return errors.New("mixer.statePath is required")
+	}
+	if c.Mixer.PayoutDistribution != ExponentialDistribution && c.Mixer.PayoutDistribution != UniformDistribution {
+		return fmt.Errorf("mixer.payoutDistribution must be %q or %q", ExponentialDistribution, UniformDistribution)
+	}
+	if c.Mixer.PayoutMinDelay < 0 || c.Mixer.PayoutMaxDelay < c.Mixer.PayoutMinDelay {
+		return errors.New("mixer.payoutMinDelay must not be negative or greater than mixer.payoutMaxDelay")
+	}
+	if c.Mixer.PayoutMeanInterval <= 0 {
+		return errors.New("mixer.payoutMeanInterval must be greater than zero")
+	}
+	if c.Mixer.HousePoolSize < 1 {
+		return errors.New("mixer.housePoolSize must be at least 1")
+	}
+	if c.Mixer.HouseShuffleInterval < 0 {
+		return errors.New("mixer.houseShuffleInterval must not be negative")
+	}
+	if c.Mixer.ReconcileInterval < 0 {
+		return errors.New("mixer.reconcileInterval must not be negative")
+	}
+	if c.Mixer.PollStallTimeout <= 0 {
+		return errors.New("mixer.pollStallTimeout must be greater than zero")
+	}
+	if c.Mixer.DepositTTL < 0 {
+		return errors.New("mixer.depositTTL must not be negative")
+	}
+	if c.Mixer.ExpiredSweepInterval <= 0 {
+		return errors.New("mixer.expiredSweepInterval must be greater than zero")
+	}
+	if c.Mixer.ArchiveAfter < 0 {
+		return errors.New("mixer.archiveAfter must not be negative")
+	}
+	if c.Mixer.MinDeposit < 0 || c.Mixer.MaxDeposit < 0 || c.Mixer.UserDepositLimit < 0 {
+		return errors.New("mixer.minDeposit, mixer.maxDeposit and mixer.userDepositLimit must not be negative")
+	}
+	if c.Mixer.MaxDeposit > 0 && c.Mixer.MaxDeposit < c.Mixer.MinDeposit {
+		return errors.New("mixer.maxDeposit must not be less than mixer.minDeposit")
+	}
+	switch c.Mixer.OutOfRangePolicy {
+	case HoldDeposits, RefundDeposits:
+	case FlatFeeDeposits:
+		if c.Mixer.OutOfRangeFee <= 0 {
+			return errors.New("mixer.outOfRangeFee must be greater than zero")
+		}
+	default:
+		return fmt.Errorf("mixer.outOfRangePolicy must be one of %q, %q or %q", HoldDeposits, RefundDeposits, FlatFeeDeposits)
+	}
+	switch c.Mixer.AmountStrategy {
+	case FixedAmounts:
+	case UniformAmounts, LogNormalAmounts:
+		if c.Mixer.MinPayoutAmount <= 0 || c.Mixer.MaxPayoutAmount < c.Mixer.MinPayoutAmount {
+			return errors.New("mixer.minPayoutAmount must be greater than zero and no more than mixer.maxPayoutAmount")
+		}
+		if c.Mixer.AmountStrategy == LogNormalAmounts && c.Mixer.LogNormalSigma <= 0 {
+			return errors.New("mixer.logNormalSigma must be greater than zero")
+		}
+	case DenominationAmounts:
+		if len(c.Mixer.Denominations) == 0 {
+			return errors.New("mixer.denominations must not be empty")
+		}
+		for _, denomination := range c.Mixer.Denominations {
+			if denomination <= 0 {
+				return errors.New("mixer.denominations must all be greater than zero")
+			}
+		}
+	default:
+		return fmt.Errorf("mixer.amountStrategy must be one of %q, %q, %q or %q", FixedAmounts, UniformAmounts, DenominationAmounts, LogNormalAmounts)
+	}
+	if c.API.Port == "" {
+		return errors.New("api.port is required")
+	}
+	if _, err := url.ParseRequestURI(c.API.BaseURL); err != nil {
+		return fmt.Errorf("api.baseURL is not a valid URL: %q", c.API.BaseURL)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return fmt.Errorf("log.level must be one of debug, info, warn or error: %q", c.Log.Level)
+	}
+	if c.Log.Format != JSONLogFormat && c.Log.Format != TextLogFormat {
+		return fmt.Errorf("log.format must be %q or %q", JSONLogFormat, TextLogFormat)
+	}
+	if c.Webhooks.Timeout <= 0 {
+		return errors.New("webhooks.timeout must be greater than zero")
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		return errors.New("webhooks.maxAttempts must be at least 1")
+	}
+	if c.Webhooks.InitialBackoff < 0 || c.Webhooks.MaxBackoff < c.Webhooks.InitialBackoff {
+		return errors.New("webhooks.initialBackoff must not be negative or greater than webhooks.maxBackoff")
+	}
+	return nil
+}
+
+// applyEnv overrides values with those found by lookupEnv.
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	overrides := []struct {
+		name  string
+		apply func(value string) error
+	}{
+		{"JOBCOIN_BASE_URL", stringSetter(&c.Jobcoin.BaseURL)},
+		{"JOBCOIN_TIMEOUT", c.Jobcoin.Timeout.set},
+		{"JOBCOIN_MAX_ATTEMPTS", intSetter(&c.Jobcoin.MaxAttempts)},
+		{"JOBCOIN_INITIAL_BACKOFF", c.Jobcoin.InitialBackoff.set},
+		{"JOBCOIN_MAX_BACKOFF", c.Jobcoin.MaxBackoff.set},
+		{"JOBCOIN_BREAKER_THRESHOLD", intSetter(&c.Jobcoin.BreakerThreshold)},
+		{"JOBCOIN_BREAKER_COOLDOWN", c.Jobcoin.BreakerCooldown.set},
+		{"JOBCOIN_RATE_LIMIT", float64Setter(&c.Jobcoin.RateLimit)},
+		{"JOBCOIN_RATE_BURST", intSetter(&c.Jobcoin.RateBurst)},
+		{"MIXER_BANK_FUND", stringSetter(&c.Mixer.BankFund)},
+		{"MIXER_SERVICE_FEE_BASIS_POINTS", int64Setter(&c.Mixer.ServiceFeeBasisPoints)},
+		{"MIXER_DISTRIBUTION_INCREMENT", amountSetter(&c.Mixer.DistributionIncrement)},
+		{"MIXER_DEPOSIT_POLL_INTERVAL", c.Mixer.DepositPollInterval.set},
+		{"MIXER_DEPOSIT_POLL_WORKERS", intSetter(&c.Mixer.DepositPollWorkers)},
+		{"MIXER_HOUSE_POLL_INTERVAL", c.Mixer.HousePollInterval.set},
+		{"MIXER_HOUSE_QUEUE_LIMIT", intSetter(&c.Mixer.HouseQueueLimit)},
+		{"MIXER_STATE_PATH", stringSetter(&c.Mixer.StatePath)},
+		{"MIXER_PAYOUT_DISTRIBUTION", stringSetter(&c.Mixer.PayoutDistribution)},
+		{"MIXER_PAYOUT_MIN_DELAY", c.Mixer.PayoutMinDelay.set},
+		{"MIXER_PAYOUT_MAX_DELAY", c.Mixer.PayoutMaxDelay.set},
+		{"MIXER_PAYOUT_MEAN_INTERVAL", c.Mixer.PayoutMeanInterval.set},
+		{"MIXER_AMOUNT_STRATEGY", stringSetter(&c.Mixer.AmountStrategy)},
+		{"MIXER_MIN_PAYOUT_AMOUNT", amountSetter(&c.Mixer.MinPayoutAmount)},
+		{"MIXER_MAX_PAYOUT_AMOUNT", amountSetter(&c.Mixer.MaxPayoutAmount)},
+		{"MIXER_DENOMINATIONS", amountListSetter(&c.Mixer.Denominations)},
+		{"MIXER_LOG_NORMAL_SIGMA", float64Setter(&c.Mixer.LogNormalSigma)},
+		{"MIXER_HOUSE_POOL_SIZE", intSetter(&c.Mixer.HousePoolSize)},
+		{"MIXER_HOUSE_SHUFFLE_INTERVAL", c.Mixer.HouseShuffleInterval.set},
+		{"MIXER_RECONCILE_INTERVAL", c.Mixer.ReconcileInterval.set},
+		{"MIXER_POLL_STALL_TIMEOUT", c.Mixer.PollStallTimeout.set},
+		{"MIXER_DEPOSIT_TTL", c.Mixer.DepositTTL.set},
+		{"MIXER_EXPIRED_SWEEP_INTERVAL", c.Mixer.ExpiredSweepInterval.set},
+		{"MIXER_ARCHIVE_AFTER", c.Mixer.ArchiveAfter.set},
+		{"MIXER_MIN_DEPOSIT", amountSetter(&c.Mixer.MinDeposit)},
+		{"MIXER_MAX_DEPOSIT", amountSetter(&c.Mixer.MaxDeposit)},
+		{"MIXER_USER_DEPOSIT_LIMIT", amountSetter(&c.Mixer.UserDepositLimit)},
+		{"MIXER_OUT_OF_RANGE_POLICY", stringSetter(&c.Mixer.OutOfRangePolicy)},
+		{"MIXER_OUT_OF_RANGE_FEE", amountSetter(&c.Mixer.OutOfRangeFee)},
+		{"MIXER_PORT", stringSetter(&c.API.Port)},
+		{"MIXER_BASE_URL", stringSetter(&c.API.BaseURL)},
+		{"MIXER_LOG_LEVEL", stringSetter(&c.Log.Level)},
+		{"MIXER_LOG_FORMAT", stringSetter(&c.Log.Format)},
+		{"MIXER_WEBHOOK_SECRET", stringSetter(&c.Webhooks.Secret)},
+		{"MIXER_WEBHOOK_TIMEOUT", c.Webhooks.Timeout.set},
+		{"MIXER_WEBHOOK_MAX_ATTEMPTS", intSetter(&c.Webhooks.MaxAttempts)},
+		{"MIXER_WEBHOOK_INITIAL_BACKOFF", c.Webhooks.InitialBackoff.set},
+		{"MIXER_WEBHOOK_MAX_BACKOFF", c.Webhooks.MaxBackoff.set},
+		{"MIXER_WEBHOOK_ALLOW_PRIVATE_HOSTS", boolSetter(&c.Webhooks.AllowPrivateHosts)},
+	}
+
+	for _, override := range overrides {
+		value, ok := lookupEnv(override.name)
+		if !ok {
+			continue
+		}
+		err := override.apply(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", override.name, err)
+		}
+	}
+	return nil
+}
+
+func stringSetter(field *string) func(string) error {
+	return func(value string) error {
+		*field = value
+		return nil
+	}
+}
+
+func boolSetter(field *bool) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+func intSetter(field *int) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+func int64Setter(field *int64) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+func amountSetter(field *clientlib.Amount) func(string) error {
+	return func(value string) error {
+		parsed, err := clientlib.ParseAmount(value)
+		if err != nil {
+			return err
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+func amountListSetter(field *[]clientlib.Amount) func(string) error {
+	return func(value string) error {
+		amounts := []clientlib.Amount{}
+		for _, s := range strings.Split(value, ",") {
+			parsed, err := clientlib.ParseAmount(strings.TrimSpace(s))
+			if err != nil {
+				return err
+			}
+			amounts = append(amounts, parsed)
+		}
+		*field = amounts
+		return nil
+	}
+}
+
+func float64Setter(field *float64) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+// Duration is a time.Duration that is written in config files as a string
+// such as "5s" or "1m30s".
+type Duration time.Duration
+
+// Std returns the duration as a time.Duration.
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes the duration from a string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d *Duration) set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,231 @@
+package jobcoin
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestConfig(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "jobcoin-config")
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(contents), 0600)
+	return path
+}
+
+// Begin LoadConfig tests
+func TestLoadConfig_ReturnsDefaultsWithoutFile(t *testing.T) {
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, DefaultConfig(), config)
+}
+
+func TestLoadConfig_ExampleFileMatchesDefaults(t *testing.T) {
+	config, err := LoadConfig("config.example.json")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, DefaultConfig(), config)
+}
+
+func TestLoadConfig_OverlaysFileOnDefaults(t *testing.T) {
+	path := writeTestConfig(t, `{
+		"jobcoin": {"baseURL": "http://localhost:8081/api"},
+		"mixer": {"serviceFeeBasisPoints": 250, "distributionIncrement": "2.5", "housePollInterval": "1m"}
+	}`)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, "http://localhost:8081/api", config.Jobcoin.BaseURL)
+	assert.Equal(t, int64(250), config.Mixer.ServiceFeeBasisPoints)
+	assert.Equal(t, clientlib.MustParseAmount("2.5"), config.Mixer.DistributionIncrement)
+	assert.Equal(t, time.Minute, config.Mixer.HousePollInterval.Std())
+	assert.Equal(t, DefaultConfig().Mixer.DepositPollInterval, config.Mixer.DepositPollInterval)
+	assert.Equal(t, DefaultConfig().API, config.API)
+}
+
+func TestLoadConfig_ReturnsErrorForUnknownFields(t *testing.T) {
+	path := writeTestConfig(t, `{"mixer": {"serviceFee": 250}}`)
+
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.Contains(t, err.Error(), "unknown field")
+}
+
+func TestLoadConfig_ReturnsErrorIfFileMissing(t *testing.T) {
+	_, err := LoadConfig(filepath.Join(os.TempDir(), "does-not-exist.json"))
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
+
+func TestLoadConfig_ReturnsErrorIfInvalid(t *testing.T) {
+	path := writeTestConfig(t, `{"mixer": {"distributionIncrement": "0"}}`)
+
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.Equal(t, "mixer.distributionIncrement must be greater than zero", err.Error())
+}
+
+// Begin applyEnv tests
+func TestApplyEnv_OverridesValues(t *testing.T) {
+	env := map[string]string{
+		"JOBCOIN_BASE_URL":                  "http://localhost:8081/api",
+		"MIXER_SERVICE_FEE_BASIS_POINTS":    "50",
+		"MIXER_DISTRIBUTION_INCREMENT":      "1.25",
+		"MIXER_DEPOSIT_POLL_INTERVAL":       "500ms",
+		"MIXER_PORT":                        ":9090",
+		"JOBCOIN_MAX_ATTEMPTS":              "5",
+		"MIXER_DENOMINATIONS":               "0.5, 1,2.5",
+		"MIXER_WEBHOOK_SECRET":              "webhook-secret",
+		"JOBCOIN_RATE_LIMIT":                "2.5",
+		"MIXER_WEBHOOK_ALLOW_PRIVATE_HOSTS": "true",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	config := DefaultConfig()
+	err := config.applyEnv(lookupEnv)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, "http://localhost:8081/api", config.Jobcoin.BaseURL)
+	assert.Equal(t, int64(50), config.Mixer.ServiceFeeBasisPoints)
+	assert.Equal(t, clientlib.MustParseAmount("1.25"), config.Mixer.DistributionIncrement)
+	assert.Equal(t, 500*time.Millisecond, config.Mixer.DepositPollInterval.Std())
+	assert.Equal(t, ":9090", config.API.Port)
+	assert.Equal(t, 5, config.Jobcoin.MaxAttempts)
+	assert.Equal(t, []clientlib.Amount{clientlib.Coin / 2, clientlib.Coin, clientlib.MustParseAmount("2.5")}, config.Mixer.Denominations)
+	assert.Equal(t, DefaultConfig().Mixer.BankFund, config.Mixer.BankFund)
+	assert.True(t, config.Webhooks.Enabled())
+	assert.Equal(t, 2.5, config.Jobcoin.RateLimit)
+	assert.True(t, config.Webhooks.AllowPrivateHosts)
+}
+
+func TestApplyEnv_ReturnsErrorForInvalidValue(t *testing.T) {
+	lookupEnv := func(name string) (string, bool) {
+		return "soon", name == "MIXER_HOUSE_POLL_INTERVAL"
+	}
+
+	config := DefaultConfig()
+	err := config.applyEnv(lookupEnv)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.Contains(t, err.Error(), "MIXER_HOUSE_POLL_INTERVAL")
+}
+
+// Begin Validate tests
+func TestValidate_RejectsOutOfRangeServiceFee(t *testing.T) {
+	config := DefaultConfig()
+	config.Mixer.ServiceFeeBasisPoints = 10001
+
+	err := config.Validate()
+
+	assert.Equal(t, "mixer.serviceFeeBasisPoints must be between 0 and 10000", err.Error())
+}
+
+func TestValidate_RejectsMaxBackoffBelowInitialBackoff(t *testing.T) {
+	config := DefaultConfig()
+	config.Jobcoin.InitialBackoff = Duration(time.Second)
+	config.Jobcoin.MaxBackoff = Duration(time.Millisecond)
+
+	err := config.Validate()
+
+	assert.Equal(t, "jobcoin.initialBackoff must not be negative or greater than jobcoin.maxBackoff", err.Error())
+}
+
+func TestValidate_RejectsRateLimitWithoutBurst(t *testing.T) {
+	config := DefaultConfig()
+	config.Jobcoin.RateBurst = 0
+
+	err := config.Validate()
+
+	assert.Equal(t, "jobcoin.rateLimit must not be negative and jobcoin.rateBurst must be at least 1", err.Error())
+
+	config.Jobcoin.RateLimit = 0
+	assert.Nil(t, config.Validate())
+	assert.Nil(t, config.Jobcoin.RateLimiter())
+}
+
+func TestValidate_RejectsUnknownAmountStrategy(t *testing.T) {
+	config := DefaultConfig()
+	config.Mixer.AmountStrategy = "random"
+
+	err := config.Validate()
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.Contains(t, err.Error(), "mixer.amountStrategy")
+}
+
+func TestValidate_RejectsInvalidJobcoinURL(t *testing.T) {
+	config := DefaultConfig()
+	config.Jobcoin.BaseURL = "not a url"
+
+	err := config.Validate()
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
+
+func TestValidate_RejectsUnknownLogLevel(t *testing.T) {
+	config := DefaultConfig()
+	config.Log.Level = "verbose"
+
+	err := config.Validate()
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.Contains(t, err.Error(), "log.level")
+}
+
+// Begin NewLogger tests
+func TestNewLogger_WritesJSONAtConfiguredLevel(t *testing.T) {
+	var out bytes.Buffer
+	logger := LogConfig{Level: "warn", Format: JSONLogFormat}.NewLogger(&out)
+
+	logger.Info("Not written")
+	logger.Warn("Pausing deposit polling", "depositAddress", "1234abcd")
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(out.Bytes(), &entry)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, "Pausing deposit polling", entry["msg"])
+	assert.Equal(t, "1234abcd", entry["depositAddress"])
+}
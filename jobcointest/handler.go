@@ -0,0 +1,117 @@
+package jobcointest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/gorilla/mux"
+)
+
+// DefaultCreateAmount is the number of coins the faucet creates when no amount is given.
+const DefaultCreateAmount = 50 * clientlib.Coin
+
+// CreateRequest is the body accepted by the faucet endpoint.
+type CreateRequest struct {
+	Address string           `json:"address"`
+	Amount  clientlib.Amount `json:"amount"`
+}
+
+type statusPayload struct {
+	Status string `json:"status"`
+}
+
+type errorPayload struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns an http.Handler serving the Jobcoin API backed by the
+// given ledger. As on the hosted API, every route is under /api:
+//
+//	GET  /api/addresses/{address}
+//	GET  /api/transactions
+//	POST /api/transactions
+//	POST /api/create
+func NewHandler(ledger *Ledger) http.Handler {
+	r := mux.NewRouter()
+	api := r.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/addresses/{address}", addressInfoHandler(ledger)).Methods("GET")
+	api.HandleFunc("/transactions", transactionsHandler(ledger)).Methods("GET")
+	api.HandleFunc("/transactions", sendHandler(ledger)).Methods("POST")
+	api.HandleFunc("/create", createHandler(ledger)).Methods("POST")
+	return r
+}
+
+func addressInfoHandler(ledger *Ledger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		address := mux.Vars(r)["address"]
+		respondWithJSON(w, http.StatusOK, ledger.AddressInfo(address))
+	}
+}
+
+func transactionsHandler(ledger *Ledger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, ledger.AllTransactions())
+	}
+}
+
+func sendHandler(ledger *Ledger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var tx clientlib.JobcoinTx
+		err := json.NewDecoder(r.Body).Decode(&tx)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, errorPayload{"Invalid request body"})
+			return
+		}
+		defer r.Body.Close()
+
+		err = ledger.Send(tx.FromAddress, tx.ToAddress, tx.Amount)
+		if err != nil {
+			respondWithJSON(w, statusForError(err), errorPayload{err.Error()})
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, statusPayload{"OK"})
+	}
+}
+
+func createHandler(ledger *Ledger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			respondWithJSON(w, http.StatusBadRequest, errorPayload{"Invalid request body"})
+			return
+		}
+		defer r.Body.Close()
+
+		if req.Amount == 0 {
+			req.Amount = DefaultCreateAmount
+		}
+
+		err = ledger.Create(req.Address, req.Amount)
+		if err != nil {
+			respondWithJSON(w, statusForError(err), errorPayload{err.Error()})
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, statusPayload{"OK"})
+	}
+}
+
+func statusForError(err error) int {
+	switch err {
+	case ErrInsufficientFunds, ErrInvalidAmount, ErrMissingAddress:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
+	response, _ := json.Marshal(payload)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
@@ -0,0 +1,90 @@
+package jobcointest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(ledger *Ledger) (*httptest.Server, *clientlib.JobcoinLib) {
+	server := httptest.NewServer(NewHandler(ledger))
+	jl := &clientlib.JobcoinLib{
+		Client:  server.Client(),
+		BaseURL: server.URL + "/api",
+	}
+	return server, jl
+}
+
+// Begin NewHandler tests
+func TestNewHandler_ServesAddressInfoToJobcoinLib(t *testing.T) {
+	ledger := NewLedger()
+	ledger.Create("alice", 10*clientlib.Coin)
+	ledger.Send("alice", "bob", clientlib.MustParseAmount("3.25"))
+	server, jl := newTestServer(ledger)
+	defer server.Close()
+
+	info, err := jl.GetAddressInfo("bob")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, ledger.AddressInfo("bob"), info)
+}
+
+func TestNewHandler_AcceptsTransactionsFromJobcoinLib(t *testing.T) {
+	ledger := NewLedger()
+	ledger.Create("alice", 10*clientlib.Coin)
+	server, jl := newTestServer(ledger)
+	defer server.Close()
+
+	err := jl.SendJobcoin("alice", "bob", clientlib.MustParseAmount("3.25"))
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, clientlib.MustParseAmount("3.25"), ledger.AddressInfo("bob").Balance)
+}
+
+func TestNewHandler_ReturnsInsufficientFundsError(t *testing.T) {
+	server, jl := newTestServer(NewLedger())
+	defer server.Close()
+
+	err := jl.SendJobcoin("alice", "bob", clientlib.Coin)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.Equal(t, "Failed to create transaction due to: Insufficient Funds", err.Error())
+}
+
+func TestNewHandler_CreatesCoinsThroughFaucet(t *testing.T) {
+	ledger := NewLedger()
+	server := httptest.NewServer(NewHandler(ledger))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/api/create", "application/json", bytes.NewReader([]byte(`{"address": "alice"}`)))
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+	res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, DefaultCreateAmount, ledger.AddressInfo("alice").Balance)
+}
+
+func TestNewHandler_RejectsInvalidFaucetRequest(t *testing.T) {
+	server := httptest.NewServer(NewHandler(NewLedger()))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/api/create", "application/json", bytes.NewReader([]byte(`{"amount": "5"}`)))
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+	res.Body.Close()
+
+	assert.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+}
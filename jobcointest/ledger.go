@@ -0,0 +1,166 @@
+// Package jobcointest provides an in-process simulation of the Jobcoin
+// network, so the mixer can be developed and tested without the hosted API.
+package jobcointest
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// ErrInsufficientFunds is returned when an address tries to send more Jobcoin
+// than it holds.
+var ErrInsufficientFunds = errors.New("Insufficient Funds")
+
+// ErrInvalidAmount is returned when a transaction or faucet amount is not positive.
+var ErrInvalidAmount = errors.New("Amount must be greater than zero")
+
+// ErrMissingAddress is returned when a transaction has no from or to address.
+var ErrMissingAddress = errors.New("An address is required")
+
+// timestampFormat matches the timestamps returned by the Jobcoin API.
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+// Ledger records every Jobcoin transaction in the simulated network. Coins are
+// only created through Create; every other transaction moves existing coins.
+type Ledger struct {
+	mu           sync.Mutex
+	path         string
+	Transactions []clientlib.JobcoinTx `json:"transactions"`
+}
+
+// NewLedger returns an empty Ledger that is only kept in memory.
+func NewLedger() *Ledger {
+	return &Ledger{Transactions: []clientlib.JobcoinTx{}}
+}
+
+// LoadLedger returns a Ledger saved to the file at path after every
+// transaction. If the file already exists its transactions are loaded.
+func LoadLedger(path string) (*Ledger, error) {
+	l := NewLedger()
+	l.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, l)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Create adds new coins to the given address, like the faucet on the
+// Jobcoin website.
+func (l *Ledger) Create(address string, amount clientlib.Amount) error {
+	if address == "" {
+		return ErrMissingAddress
+	}
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.record(clientlib.JobcoinTx{ToAddress: address, Amount: amount})
+}
+
+// Send moves amount from one address to another. ErrInsufficientFunds is
+// returned if fromAddress does not hold enough Jobcoin.
+func (l *Ledger) Send(fromAddress, toAddress string, amount clientlib.Amount) error {
+	if fromAddress == "" || toAddress == "" {
+		return ErrMissingAddress
+	}
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.balance(fromAddress) < amount {
+		return ErrInsufficientFunds
+	}
+	return l.record(clientlib.JobcoinTx{FromAddress: fromAddress, ToAddress: toAddress, Amount: amount})
+}
+
+// AddressInfo returns the balance of the address and every transaction to or
+// from it, oldest first.
+func (l *Ledger) AddressInfo(address string) clientlib.JobcoinAddressInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info := clientlib.JobcoinAddressInfo{
+		Balance:      l.balance(address),
+		Transactions: []clientlib.JobcoinTx{},
+	}
+	for _, tx := range l.Transactions {
+		if tx.FromAddress == address || tx.ToAddress == address {
+			info.Transactions = append(info.Transactions, tx)
+		}
+	}
+	return info
+}
+
+// AllTransactions returns every transaction in the ledger, oldest first.
+func (l *Ledger) AllTransactions() []clientlib.JobcoinTx {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]clientlib.JobcoinTx{}, l.Transactions...)
+}
+
+func (l *Ledger) balance(address string) clientlib.Amount {
+	var balance clientlib.Amount
+	for _, tx := range l.Transactions {
+		if tx.ToAddress == address {
+			balance = balance + tx.Amount
+		}
+		if tx.FromAddress == address {
+			balance = balance - tx.Amount
+		}
+	}
+	return balance
+}
+
+// record appends the transaction, saving the ledger first if it has a file.
+func (l *Ledger) record(tx clientlib.JobcoinTx) error {
+	tx.Timestamp = time.Now().UTC().Format(timestampFormat)
+	transactions := append(l.Transactions, tx)
+
+	if l.path != "" {
+		err := save(l.path, transactions)
+		if err != nil {
+			return err
+		}
+	}
+
+	l.Transactions = transactions
+	return nil
+}
+
+func save(path string, transactions []clientlib.JobcoinTx) error {
+	data, err := json.MarshalIndent(struct {
+		Transactions []clientlib.JobcoinTx `json:"transactions"`
+	}{transactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,91 @@
+package jobcointest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin Create tests
+func TestCreate_AddsCoinsToAddress(t *testing.T) {
+	ledger := NewLedger()
+
+	err := ledger.Create("alice", 50*clientlib.Coin)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	info := ledger.AddressInfo("alice")
+	assert.Equal(t, 50*clientlib.Coin, info.Balance)
+	assert.Equal(t, 1, len(info.Transactions))
+	assert.Equal(t, "", info.Transactions[0].FromAddress)
+	assert.NotEqual(t, "", info.Transactions[0].Timestamp)
+}
+
+func TestCreate_ReturnsErrorIfAmountNotPositive(t *testing.T) {
+	ledger := NewLedger()
+
+	err := ledger.Create("alice", 0)
+
+	assert.Equal(t, ErrInvalidAmount, err)
+}
+
+// Begin Send tests
+func TestSend_MovesCoinsBetweenAddresses(t *testing.T) {
+	ledger := NewLedger()
+	ledger.Create("alice", 10*clientlib.Coin)
+
+	err := ledger.Send("alice", "bob", clientlib.MustParseAmount("2.5"))
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, clientlib.MustParseAmount("7.5"), ledger.AddressInfo("alice").Balance)
+	assert.Equal(t, clientlib.MustParseAmount("2.5"), ledger.AddressInfo("bob").Balance)
+	assert.Equal(t, 1, len(ledger.AddressInfo("bob").Transactions))
+}
+
+func TestSend_ReturnsErrorIfInsufficientFunds(t *testing.T) {
+	ledger := NewLedger()
+	ledger.Create("alice", clientlib.Coin)
+
+	err := ledger.Send("alice", "bob", 2*clientlib.Coin)
+
+	assert.Equal(t, ErrInsufficientFunds, err)
+	assert.Equal(t, clientlib.Coin, ledger.AddressInfo("alice").Balance)
+	assert.Equal(t, 1, len(ledger.AllTransactions()))
+}
+
+func TestSend_ReturnsErrorIfAddressMissing(t *testing.T) {
+	ledger := NewLedger()
+
+	err := ledger.Send("", "bob", clientlib.Coin)
+
+	assert.Equal(t, ErrMissingAddress, err)
+}
+
+// Begin LoadLedger tests
+func TestLoadLedger_PersistsTransactionsAcrossRuns(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jobcointest")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.json")
+
+	ledger, err := LoadLedger(path)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+	ledger.Create("alice", 10*clientlib.Coin)
+	ledger.Send("alice", "bob", 4*clientlib.Coin)
+
+	reloaded, err := LoadLedger(path)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, ledger.AllTransactions(), reloaded.AllTransactions())
+	assert.Equal(t, 4*clientlib.Coin, reloaded.AddressInfo("bob").Balance)
+}
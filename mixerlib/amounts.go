@@ -0,0 +1,117 @@
+package mixerlib
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// AmountStrategy decides how much of a user's balance is returned in each
+// round of payouts. Each round is then split randomly between the user's
+// return addresses.
+type AmountStrategy interface {
+	// NextAmount returns the total of the next round given what is left to
+	// return. Anything outside of 0 < amount <= remaining is treated as remaining.
+	NextAmount(remaining clientlib.Amount) clientlib.Amount
+}
+
+// NewAmountStrategy returns the AmountStrategy selected by config.
+func NewAmountStrategy(config jobcoin.MixerConfig) AmountStrategy {
+	switch config.AmountStrategy {
+	case jobcoin.FixedAmounts:
+		return FixedAmounts{Increment: config.DistributionIncrement}
+	case jobcoin.DenominationAmounts:
+		return DenominationAmounts{Denominations: config.Denominations}
+	case jobcoin.LogNormalAmounts:
+		return LogNormalAmounts{
+			Median: config.DistributionIncrement,
+			Sigma:  config.LogNormalSigma,
+			Min:    config.MinPayoutAmount,
+			Max:    config.MaxPayoutAmount,
+		}
+	default:
+		return UniformAmounts{Min: config.MinPayoutAmount, Max: config.MaxPayoutAmount}
+	}
+}
+
+// FixedAmounts returns Increment every round until less than that is left.
+type FixedAmounts struct {
+	Increment clientlib.Amount
+}
+
+// NextAmount returns Increment, or remaining if that is smaller.
+func (s FixedAmounts) NextAmount(remaining clientlib.Amount) clientlib.Amount {
+	if remaining < s.Increment {
+		return remaining
+	}
+	return s.Increment
+}
+
+// UniformAmounts returns any amount between Min and Max with equal likelihood.
+type UniformAmounts struct {
+	Min clientlib.Amount
+	Max clientlib.Amount
+}
+
+// NextAmount returns a random amount between Min and Max.
+func (s UniformAmounts) NextAmount(remaining clientlib.Amount) clientlib.Amount {
+	amount := s.Min
+	if s.Max > s.Min {
+		amount = s.Min + clientlib.Amount(rand.Int63n(int64(s.Max-s.Min)+1))
+	}
+	return fitToRemaining(amount, remaining, s.Min)
+}
+
+// DenominationAmounts returns one of a fixed set of round amounts shared by
+// every user, so that rounds from different users look alike.
+type DenominationAmounts struct {
+	Denominations []clientlib.Amount
+}
+
+// NextAmount returns a random denomination no larger than remaining. Once
+// remaining is smaller than every denomination it is returned as is.
+func (s DenominationAmounts) NextAmount(remaining clientlib.Amount) clientlib.Amount {
+	fits := []clientlib.Amount{}
+	for _, denomination := range s.Denominations {
+		if denomination > 0 && denomination <= remaining {
+			fits = append(fits, denomination)
+		}
+	}
+	if len(fits) == 0 {
+		return remaining
+	}
+	return fits[rand.Intn(len(fits))]
+}
+
+// LogNormalAmounts returns amounts with a log-normal distribution around
+// Median, which gives mostly small rounds and an occasional large one. Sigma
+// controls the spread. Amounts are kept between Min and Max.
+type LogNormalAmounts struct {
+	Median clientlib.Amount
+	Sigma  float64
+	Min    clientlib.Amount
+	Max    clientlib.Amount
+}
+
+// NextAmount returns a random log-normally distributed amount.
+func (s LogNormalAmounts) NextAmount(remaining clientlib.Amount) clientlib.Amount {
+	amount := clientlib.Amount(math.Round(float64(s.Median) * math.Exp(s.Sigma*rand.NormFloat64())))
+	if amount < s.Min {
+		amount = s.Min
+	}
+	if s.Max > 0 && amount > s.Max {
+		amount = s.Max
+	}
+	return fitToRemaining(amount, remaining, s.Min)
+}
+
+// fitToRemaining caps amount at remaining, and takes everything if less
+// than min would otherwise be left for the final round.
+func fitToRemaining(amount, remaining, min clientlib.Amount) clientlib.Amount {
+	if amount >= remaining || remaining-amount < min {
+		return remaining
+	}
+	return amount
+}
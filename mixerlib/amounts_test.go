@@ -0,0 +1,124 @@
+package mixerlib
+
+import (
+	"testing"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// drawRounds returns the rounds strategy splits balance into.
+func drawRounds(strategy AmountStrategy, balance clientlib.Amount) []clientlib.Amount {
+	rounds := []clientlib.Amount{}
+	for remaining := balance; remaining > 0; {
+		amount := strategy.NextAmount(remaining)
+		rounds = append(rounds, amount)
+		remaining = remaining - amount
+	}
+	return rounds
+}
+
+func sumAmounts(amounts []clientlib.Amount) clientlib.Amount {
+	var total clientlib.Amount
+	for _, amount := range amounts {
+		total = total + amount
+	}
+	return total
+}
+
+// Begin NewAmountStrategy tests
+func TestNewAmountStrategy_ReturnsConfiguredStrategy(t *testing.T) {
+	config := jobcoin.DefaultConfig().Mixer
+
+	config.AmountStrategy = jobcoin.FixedAmounts
+	assert.Equal(t, FixedAmounts{Increment: config.DistributionIncrement}, NewAmountStrategy(config))
+
+	config.AmountStrategy = jobcoin.DenominationAmounts
+	assert.Equal(t, DenominationAmounts{Denominations: config.Denominations}, NewAmountStrategy(config))
+
+	config.AmountStrategy = jobcoin.UniformAmounts
+	assert.Equal(t, UniformAmounts{Min: config.MinPayoutAmount, Max: config.MaxPayoutAmount}, NewAmountStrategy(config))
+}
+
+// Begin FixedAmounts tests
+func TestFixedAmounts_ReturnsIncrementUntilRemainderIsSmaller(t *testing.T) {
+	rounds := drawRounds(FixedAmounts{Increment: 5 * clientlib.Coin}, clientlib.MustParseAmount("12.5"))
+
+	expected := []clientlib.Amount{5 * clientlib.Coin, 5 * clientlib.Coin, clientlib.MustParseAmount("2.5")}
+	assert.Equal(t, expected, rounds)
+}
+
+// Begin UniformAmounts tests
+func TestUniformAmounts_RoundsStayWithinBoundsAndSumToBalance(t *testing.T) {
+	strategy := UniformAmounts{Min: clientlib.Coin, Max: 3 * clientlib.Coin}
+	balance := clientlib.MustParseAmount("100.12345678")
+
+	rounds := drawRounds(strategy, balance)
+
+	assert.Equal(t, balance, sumAmounts(rounds))
+	for _, amount := range rounds[:len(rounds)-1] {
+		assert.True(t, amount >= strategy.Min)
+		assert.True(t, amount <= strategy.Max)
+	}
+}
+
+func TestUniformAmounts_NeverLeavesLessThanMinForLastRound(t *testing.T) {
+	strategy := UniformAmounts{Min: clientlib.Coin, Max: 3 * clientlib.Coin}
+
+	for i := 0; i < 100; i++ {
+		rounds := drawRounds(strategy, clientlib.MustParseAmount("7.3"))
+		assert.True(t, rounds[len(rounds)-1] >= strategy.Min)
+	}
+}
+
+// Begin DenominationAmounts tests
+func TestDenominationAmounts_OnlyUsesDenominationsUntilRemainderIsSmaller(t *testing.T) {
+	denominations := []clientlib.Amount{clientlib.Coin / 2, clientlib.Coin, 5 * clientlib.Coin}
+	strategy := DenominationAmounts{Denominations: denominations}
+	balance := clientlib.MustParseAmount("23.3")
+
+	rounds := drawRounds(strategy, balance)
+
+	assert.Equal(t, balance, sumAmounts(rounds))
+	for _, amount := range rounds[:len(rounds)-1] {
+		assert.Contains(t, denominations, amount)
+	}
+	assert.Equal(t, clientlib.MustParseAmount("0.3"), rounds[len(rounds)-1])
+}
+
+// Begin LogNormalAmounts tests
+func TestLogNormalAmounts_RoundsStayWithinBoundsAndSumToBalance(t *testing.T) {
+	strategy := LogNormalAmounts{
+		Median: 2 * clientlib.Coin,
+		Sigma:  1,
+		Min:    clientlib.Coin / 2,
+		Max:    8 * clientlib.Coin,
+	}
+	balance := 250 * clientlib.Coin
+
+	rounds := drawRounds(strategy, balance)
+
+	assert.Equal(t, balance, sumAmounts(rounds))
+	for _, amount := range rounds {
+		assert.True(t, amount >= strategy.Min)
+		assert.True(t, amount <= strategy.Max+strategy.Min)
+	}
+}
+
+// Begin schedulePayouts tests
+func TestSchedulePayouts_UsesAmountsOverride(t *testing.T) {
+	user := MixerUser{
+		DepositAddress:  "1234abcd",
+		ReturnAddresses: []string{"1111aaaa"},
+	}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Amounts = FixedAmounts{Increment: 2 * clientlib.Coin}
+
+	payouts, _ := ml.schedulePayouts(user, 6*clientlib.Coin)
+
+	assert.Equal(t, 3, len(payouts))
+	for _, payout := range payouts {
+		assert.Equal(t, 2*clientlib.Coin, payout.Amount)
+	}
+}
@@ -0,0 +1,122 @@
+package mixerlib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// appendLog is a file of JSON values, one per line, that is only ever added
+// to, or emptied once its values are kept elsewhere. Adding a value costs the
+// same however many came before it, which keeps a FileStore from rewriting the
+// state file on every change. Each append is synced to disk before it
+// returns. The file is only created once something is appended. A read-only
+// log is never changed, and appending to it returns ErrReadOnlyStore.
+type appendLog struct {
+	path     string
+	readOnly bool
+	file     *os.File
+	size     int64
+}
+
+// read calls each with every value in the log, oldest first. A last line with
+// no newline was cut short by a crash part way through an append, so it is
+// dropped and, unless the log is read-only, cut from the file. Any other line that is not valid JSON means
+// the log is corrupt, and an error is returned.
+func (l *appendLog) read(each func(line []byte) error) error {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 && !l.readOnly {
+				// A write that was cut short. Drop it so that the next
+				// append starts on a line of its own.
+				err = os.Truncate(l.path, offset)
+				if err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = each(bytes.TrimSpace(line))
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", l.path, lineNumber, err)
+		}
+		offset = offset + int64(len(line))
+	}
+
+	l.size = offset
+	return nil
+}
+
+// append adds value to the end of the log as a single line. If the write or
+// sync fails, the log is cut back to where it was so no partial line is left
+// behind.
+func (l *appendLog) append(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if l.readOnly {
+		return ErrReadOnlyStore
+	}
+	if l.file == nil {
+		l.file, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = l.file.Write(data)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		l.file.Truncate(l.size)
+		return err
+	}
+	l.size = l.size + int64(len(data))
+	return nil
+}
+
+// close closes the log's file if it has been opened.
+func (l *appendLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// truncate empties the log, once everything in it has been kept elsewhere.
+func (l *appendLog) truncate() error {
+	if l.readOnly {
+		return ErrReadOnlyStore
+	}
+	err := os.Truncate(l.path, 0)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l.size = 0
+	return nil
+}
@@ -0,0 +1,138 @@
+package mixerlib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// EventKind describes what happened to a user's funds in an Event.
+type EventKind string
+
+// The kinds of event published as a user's funds move through the mixer.
+const (
+	// EventDepositDetected is published when a deposit is found in the
+	// user's deposit address and the sweep to the house begins. Amount is
+	// the deposit.
+	EventDepositDetected EventKind = "deposit_detected"
+	// EventFeeCharged is published once the service fee has been sent to the
+	// bank fund. Amount is the fee.
+	EventFeeCharged EventKind = "fee_charged"
+	// EventSwept is published once the deposit, less the fee, has reached the
+	// house. Amount is what reached the house.
+	EventSwept EventKind = "swept"
+	// EventPayoutSent is published for each payout to one of the user's
+	// return addresses. Amount is the payout and ToAddress where it went.
+	EventPayoutSent EventKind = "payout_sent"
+	// EventComplete is published once all of the user's funds have been returned.
+	EventComplete EventKind = "complete"
+	// EventExpired is published when the user's deposit address expires
+	// without a deposit. Later deposits are still found, only more slowly.
+	EventExpired EventKind = "expired"
+	// EventDepositHeld is published when a deposit outside the deposit
+	// limits is left in the user's deposit address. Amount is what is held
+	// and Message why.
+	EventDepositHeld EventKind = "deposit_held"
+	// EventRefunded is published once a deposit outside the deposit limits
+	// has been sent to the user's refund address. Amount is the refund,
+	// ToAddress where it went and Message why.
+	EventRefunded EventKind = "refunded"
+	// EventError is published when moving the user's funds failed and will
+	// be tried again. Message says what failed.
+	EventError EventKind = "error"
+)
+
+// Event is something that happened to a user's funds.
+type Event struct {
+	Kind           EventKind        `json:"kind"`
+	DepositAddress string           `json:"depositAddress"`
+	Amount         clientlib.Amount `json:"amount,omitempty"`
+	ToAddress      string           `json:"toAddress,omitempty"`
+	Message        string           `json:"message,omitempty"`
+	Time           time.Time        `json:"time"`
+}
+
+// eventBuffer is how many events a subscriber can fall behind by before
+// further events are dropped for it.
+const eventBuffer = 32
+
+// Events passes the events for each user on to whoever has subscribed to
+// them. It is safe for concurrent use. A nil *Events publishes nothing, so
+// MixerLib works without one.
+type Events struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan Event]bool
+	closed      bool
+}
+
+// NewEvents returns an Events with no subscribers.
+func NewEvents() *Events {
+	return &Events{subscribers: map[string]map[chan Event]bool{}}
+}
+
+// Subscribe returns a channel receiving the events for the user with the
+// given deposit address, and a function that unsubscribes and closes the
+// channel. A subscriber that falls too far behind misses events rather than
+// holding up the mixer. The channel is also closed by Close.
+func (e *Events) Subscribe(depositAddress string) (<-chan Event, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ch := make(chan Event, eventBuffer)
+	if e.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if e.subscribers[depositAddress] == nil {
+		e.subscribers[depositAddress] = map[chan Event]bool{}
+	}
+	e.subscribers[depositAddress][ch] = true
+
+	unsubscribe := func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.subscribers[depositAddress][ch] {
+			delete(e.subscribers[depositAddress], ch)
+			if len(e.subscribers[depositAddress]) == 0 {
+				delete(e.subscribers, depositAddress)
+			}
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// Close closes every subscriber's channel. Nothing is published afterwards.
+func (e *Events) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	for _, subscribers := range e.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+	}
+	e.subscribers = map[string]map[chan Event]bool{}
+}
+
+// publish passes event on to everyone subscribed to its user, without waiting
+// for any of them.
+func (e *Events) publish(event Event) {
+	if e == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers[event.DepositAddress] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package mixerlib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiveEvents returns the events waiting on ch without blocking.
+func receiveEvents(ch <-chan Event) []EventKind {
+	kinds := []EventKind{}
+	for {
+		select {
+		case event := <-ch:
+			kinds = append(kinds, event.Kind)
+		default:
+			return kinds
+		}
+	}
+}
+
+// Begin Events tests
+func TestEvents_PublishesOnlyToSubscribersOfUser(t *testing.T) {
+	events := NewEvents()
+	mine, unsubscribe := events.Subscribe("1234abcd")
+	defer unsubscribe()
+	theirs, unsubscribeTheirs := events.Subscribe("5678efgh")
+	defer unsubscribeTheirs()
+
+	events.publish(Event{Kind: EventComplete, DepositAddress: "1234abcd"})
+
+	event := <-mine
+	assert.Equal(t, EventComplete, event.Kind)
+	assert.False(t, event.Time.IsZero())
+	assert.Empty(t, receiveEvents(theirs))
+}
+
+func TestEvents_UnsubscribeClosesChannel(t *testing.T) {
+	events := NewEvents()
+	ch, unsubscribe := events.Subscribe("1234abcd")
+
+	unsubscribe()
+	unsubscribe()
+	events.publish(Event{Kind: EventComplete, DepositAddress: "1234abcd"})
+
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestEvents_CloseClosesEverySubscriber(t *testing.T) {
+	events := NewEvents()
+	ch, unsubscribe := events.Subscribe("1234abcd")
+	defer unsubscribe()
+
+	events.Close()
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	late, _ := events.Subscribe("1234abcd")
+	_, ok = <-late
+	assert.False(t, ok)
+}
+
+func TestEvents_DropsEventsForSlowSubscribers(t *testing.T) {
+	events := NewEvents()
+	ch, unsubscribe := events.Subscribe("1234abcd")
+	defer unsubscribe()
+
+	for i := 0; i < eventBuffer+5; i++ {
+		events.publish(Event{Kind: EventPayoutSent, DepositAddress: "1234abcd"})
+	}
+
+	assert.Equal(t, eventBuffer, len(receiveEvents(ch)))
+}
+
+func TestTransferDepositToHouse_PublishesSweepEvents(t *testing.T) {
+	mockAddressInfo := clientlib.JobcoinAddressInfo{
+		Balance: 10 * clientlib.Coin,
+	}
+	ml := newTestMixerLib(newJobcoinMock(mockAddressInfo, nil, nil))
+	ml.Events = NewEvents()
+	ch, unsubscribe := ml.Events.Subscribe("1234abcd")
+	defer unsubscribe()
+
+	_, err := ml.transferDepositToHouse(MixerUser{DepositAddress: "1234abcd"})
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, []EventKind{EventDepositDetected, EventFeeCharged, EventSwept}, receiveEvents(ch))
+}
+
+func TestSendDuePayouts_PublishesPayoutEvents(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Events = NewEvents()
+	ch, unsubscribe := ml.Events.Subscribe(user.DepositAddress)
+	defer unsubscribe()
+	creditUser(ml, user.DepositAddress, 3*clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.MustParseAmount("1.5"), DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+
+	event := <-ch
+	assert.Equal(t, EventPayoutSent, event.Kind)
+	assert.Equal(t, "1111aaaa", event.ToAddress)
+	assert.Equal(t, clientlib.MustParseAmount("1.5"), event.Amount)
+}
+
+func TestSendDuePayouts_PublishesErrorIfPayoutFails(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, errors.New("SendJobcoin failed")))
+	ml.Events = NewEvents()
+	ch, unsubscribe := ml.Events.Subscribe(user.DepositAddress)
+	defer unsubscribe()
+	creditUser(ml, user.DepositAddress, 3*clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+
+	event := <-ch
+	assert.Equal(t, EventError, event.Kind)
+	assert.NotContains(t, event.Message, "SendJobcoin failed")
+}
@@ -0,0 +1,141 @@
+package mixerlib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// pollsFrequently reports whether the user's deposit address should be
+// checked on every deposit poll. A user still awaiting a deposit DepositTTL
+// after registering is expired first. Expired users, and completed users
+// whose funds have all been returned, are only checked by the expired sweep
+// so that they stop costing a Jobcoin API call on every poll.
+func (ml *MixerLib) pollsFrequently(user MixerUser, now time.Time) (bool, error) {
+	progress, err := ml.Store.Progress(user.DepositAddress)
+	if err != nil {
+		return false, err
+	}
+
+	switch progress.State {
+	case StateExpired:
+		return false, nil
+	case StateComplete:
+		balance, err := ml.userHouseBalance(user.DepositAddress)
+		if err != nil {
+			return false, err
+		}
+		return balance > 0, nil
+	case StateAwaitingDeposit:
+		expiresAt, expires := ml.expiresAt(user)
+		if expires && !now.Before(expiresAt) {
+			ml.expireUser(user)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// expiresAt returns when the user's deposit address expires if no deposit is
+// made, and false if it never does.
+func (ml *MixerLib) expiresAt(user MixerUser) (time.Time, bool) {
+	if ml.Config.DepositTTL <= 0 || user.RegisteredAt.IsZero() {
+		return time.Time{}, false
+	}
+	return user.RegisteredAt.Add(ml.Config.DepositTTL.Std()), true
+}
+
+// expireUser moves a user who never deposited to StateExpired.
+func (ml *MixerLib) expireUser(user MixerUser) {
+	ml.userLogger(user.DepositAddress).Info("Deposit address expired", "checkedEvery", ml.Config.ExpiredSweepInterval.Std().String())
+	ml.recordProgress(user.DepositAddress, func(p *DistributionProgress) {
+		p.State = StateExpired
+	})
+	ml.publish(Event{Kind: EventExpired, DepositAddress: user.DepositAddress})
+}
+
+// archiveIfFinished archives the user once ArchiveAfter has passed since
+// they completed or their deposit address expired, so that they are no
+// longer kept in the state. The expired sweep still checks them for late
+// deposits, see checkArchivedDeposit. Users with a deposit being held, or
+// anything still owed to them, are kept.
+func (ml *MixerLib) archiveIfFinished(user MixerUser, now time.Time) {
+	if ml.Config.ArchiveAfter <= 0 {
+		return
+	}
+	logger := ml.userLogger(user.DepositAddress)
+	progress, err := ml.Store.Progress(user.DepositAddress)
+	if err != nil {
+		logger.Error("Failed to load progress", "error", err)
+		return
+	}
+
+	var finishedAt time.Time
+	switch progress.State {
+	case StateComplete:
+		finishedAt = progress.CompletedAt
+	case StateExpired:
+		expiresAt, expires := ml.expiresAt(user)
+		if !expires {
+			return
+		}
+		finishedAt = expiresAt
+	default:
+		return
+	}
+	if progress.Held > 0 || now.Before(finishedAt.Add(ml.Config.ArchiveAfter.Std())) {
+		return
+	}
+
+	archived, err := ml.Store.ArchiveSettledUser(user.DepositAddress)
+	if err != nil {
+		logger.Error("Failed to archive user", "error", err)
+		return
+	}
+	if archived {
+		logger.Info("Archived user", "state", progress.State)
+	}
+}
+
+// checkArchivedDeposit looks for a deposit made to an archived user's deposit
+// address after they were archived. If there is one the user is restored and
+// passed to checkDeposit, so it is mixed like any other late deposit. pause is
+// called if the Jobcoin API circuit breaker is open.
+func (ml *MixerLib) checkArchivedDeposit(user MixerUser, now time.Time, pause func(error)) {
+	logger := ml.userLogger(user.DepositAddress)
+	info, err := ml.JobcoinClient.GetAddressInfo(user.DepositAddress)
+	if errors.Is(err, clientlib.ErrCircuitOpen) {
+		pause(err)
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to check archived deposit address", "error", err)
+		return
+	}
+	if info.Balance <= 0 {
+		return
+	}
+
+	restored, err := ml.Store.RestoreArchivedUser(user.DepositAddress)
+	if err != nil {
+		logger.Error("Failed to restore archived user", "error", err)
+		return
+	}
+	if !restored {
+		return
+	}
+	logger.Info("Restored archived user after a late deposit", "balance", info.Balance)
+	ml.checkDeposit(user, now, true, pause)
+}
+
+// expiredSweepDue reports whether the users that are not polled frequently
+// should be checked for late deposits on the poll at now. The first poll
+// always checks them, so deposits made while the mixer was down are found.
+func (ml *MixerLib) expiredSweepDue(now time.Time) bool {
+	if !ml.lastExpiredSweep.IsZero() && now.Sub(ml.lastExpiredSweep) < ml.Config.ExpiredSweepInterval.Std() {
+		return false
+	}
+	ml.lastExpiredSweep = now
+	return true
+}
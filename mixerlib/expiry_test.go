@@ -0,0 +1,212 @@
+package mixerlib
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// pollDeposits runs a single deposit poll at now.
+func pollDeposits(ml *MixerLib, now time.Time) {
+	tick := make(chan time.Time, 1)
+	tick <- now
+	ml.processMixerUsers(context.Background(), &time.Ticker{C: tick})
+}
+
+// Begin expiry tests
+func TestProcessMixerUsers_ExpiresUsersWithoutDepositAfterTTL(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Events = NewEvents()
+	now := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: now.Add(-ml.Config.DepositTTL.Std())})
+	ml.Store.AddUser(MixerUser{DepositAddress: "5678efgh", RegisteredAt: now})
+	events, unsubscribe := ml.Events.Subscribe("1234abcd")
+	defer unsubscribe()
+
+	pollDeposits(ml, now)
+
+	expired, _ := ml.Store.Progress("1234abcd")
+	fresh, _ := ml.Store.Progress("5678efgh")
+	assert.Equal(t, StateExpired, expired.State)
+	assert.Equal(t, StateAwaitingDeposit, fresh.State)
+	assert.Equal(t, EventExpired, (<-events).Kind)
+	assert.Equal(t, 2, jobcoinMock.Lookups)
+}
+
+func TestProcessMixerUsers_OnlyChecksExpiredUsersOnExpiredSweep(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	now := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: now.Add(-48 * time.Hour)})
+
+	pollDeposits(ml, now)
+	pollDeposits(ml, now.Add(ml.Config.DepositPollInterval.Std()))
+	assert.Equal(t, 1, jobcoinMock.Lookups)
+
+	pollDeposits(ml, now.Add(ml.Config.ExpiredSweepInterval.Std()))
+	assert.Equal(t, 2, jobcoinMock.Lookups)
+}
+
+func TestProcessMixerUsers_SweepsLateDepositOfExpiredUser(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	now := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: now.Add(-48 * time.Hour)})
+	pollDeposits(ml, now)
+
+	jobcoinMock.AddressInfo = clientlib.JobcoinAddressInfo{Balance: 10 * clientlib.Coin}
+	pollDeposits(ml, now.Add(ml.Config.ExpiredSweepInterval.Std()))
+
+	progress, _ := ml.Store.Progress("1234abcd")
+	assert.Equal(t, StateInHouse, progress.State)
+	assert.Equal(t, 10*clientlib.Coin, progress.Deposited)
+}
+
+func TestProcessMixerUsers_StopsPollingCompletedUsersOnceHouseBalanceIsZero(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	now := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: now})
+	ml.Store.AddUser(MixerUser{DepositAddress: "5678efgh", RegisteredAt: now})
+	for _, depositAddress := range []string{"1234abcd", "5678efgh"} {
+		ml.Store.UpdateProgress(depositAddress, func(p *DistributionProgress) {
+			p.State = StateComplete
+		})
+	}
+	creditUser(ml, "5678efgh", clientlib.Coin)
+
+	pollDeposits(ml, now)
+	pollDeposits(ml, now.Add(ml.Config.DepositPollInterval.Std()))
+
+	assert.Equal(t, 3, jobcoinMock.Lookups)
+}
+
+func TestProcessMixerUsers_NeverExpiresUsersWithoutRegistrationTime(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd"})
+
+	pollDeposits(ml, time.Now())
+
+	progress, _ := ml.Store.Progress("1234abcd")
+	assert.Equal(t, StateAwaitingDeposit, progress.State)
+}
+
+func TestProcessMixerUsers_DoesNotExpireUsersIfTTLDisabled(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Config.DepositTTL = 0
+	now := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: now.Add(-48 * time.Hour)})
+
+	pollDeposits(ml, now)
+
+	progress, _ := ml.Store.Progress("1234abcd")
+	assert.Equal(t, StateAwaitingDeposit, progress.State)
+}
+
+func TestProcessMixerUsers_ArchivesFinishedUsersAfterArchiveAfter(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	now := time.Now()
+	archiveAfter := ml.Config.ArchiveAfter.Std()
+	ml.Store.AddUser(MixerUser{DepositAddress: "completed-long-ago", RegisteredAt: now.Add(-2 * archiveAfter)})
+	ml.Store.AddUser(MixerUser{DepositAddress: "completed-recently", RegisteredAt: now})
+	ml.Store.AddUser(MixerUser{DepositAddress: "expired-long-ago", RegisteredAt: now.Add(-ml.Config.DepositTTL.Std() - archiveAfter)})
+	ml.Store.UpdateProgress("completed-long-ago", func(p *DistributionProgress) {
+		p.State = StateComplete
+		p.CompletedAt = now.Add(-archiveAfter)
+	})
+	ml.Store.UpdateProgress("completed-recently", func(p *DistributionProgress) {
+		p.State = StateComplete
+		p.CompletedAt = now
+	})
+
+	pollDeposits(ml, now)
+	pollDeposits(ml, now.Add(ml.Config.ExpiredSweepInterval.Std()))
+
+	users, _ := ml.Store.Users()
+	archived, _ := ml.Store.ArchivedUsers()
+	status, err := ml.UserStatus("expired-long-ago")
+	assert.Equal(t, []MixerUser{{DepositAddress: "completed-recently", RegisteredAt: now}}, users)
+	assert.Equal(t, 2, len(archived))
+	assert.Nil(t, err)
+	assert.Equal(t, StateExpired, status.State)
+	// Archived users are still checked for late deposits by the expired sweep.
+	assert.Equal(t, 6, jobcoinMock.Lookups)
+}
+
+func TestProcessMixerUsers_OnlyChecksArchivedUsersOnExpiredSweep(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	now := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: now})
+	ml.Store.UpdateProgress("1234abcd", func(p *DistributionProgress) {
+		p.State = StateComplete
+	})
+	ml.Store.ArchiveSettledUser("1234abcd")
+
+	pollDeposits(ml, now)
+	pollDeposits(ml, now.Add(ml.Config.DepositPollInterval.Std()))
+	assert.Equal(t, 1, jobcoinMock.Lookups)
+
+	pollDeposits(ml, now.Add(ml.Config.ExpiredSweepInterval.Std()))
+	assert.Equal(t, 2, jobcoinMock.Lookups)
+}
+
+func TestProcessMixerUsers_RestoresArchivedUserToSweepLateDeposit(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	now := time.Now()
+	user := MixerUser{DepositAddress: "1234abcd", ReturnAddresses: []string{"1111aaaa"}, RegisteredAt: now}
+	ml.Store.AddUser(user)
+	ml.Store.UpdateProgress("1234abcd", func(p *DistributionProgress) {
+		p.State = StateComplete
+	})
+	ml.Store.ArchiveSettledUser("1234abcd")
+
+	jobcoinMock.AddressInfo = clientlib.JobcoinAddressInfo{Balance: 10 * clientlib.Coin}
+	pollDeposits(ml, now)
+
+	users, _ := ml.Store.Users()
+	archived, _ := ml.Store.ArchivedUsers()
+	houseQueue, _ := ml.Store.HouseQueue()
+	progress, _ := ml.Store.Progress("1234abcd")
+	assert.Equal(t, []MixerUser{user}, users)
+	assert.Equal(t, 0, len(archived))
+	assert.Equal(t, []MixerUser{user}, houseQueue)
+	assert.Equal(t, StateInHouse, progress.State)
+	assert.Equal(t, 10*clientlib.Coin, progress.Deposited)
+}
+
+func TestProcessMixerUsers_DoesNotArchiveUserWithHeldDeposit(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	now := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: now})
+	ml.Store.UpdateProgress("1234abcd", func(p *DistributionProgress) {
+		p.State = StateComplete
+		p.Held = clientlib.Coin
+	})
+
+	pollDeposits(ml, now)
+
+	users, _ := ml.Store.Users()
+	assert.Equal(t, 1, len(users))
+}
+
+func TestProcessMixerUsers_DoesNotArchiveUsersIfArchiveAfterDisabled(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Config.ArchiveAfter = 0
+	now := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: now})
+	ml.Store.UpdateProgress("1234abcd", func(p *DistributionProgress) {
+		p.State = StateComplete
+	})
+
+	pollDeposits(ml, now)
+
+	users, _ := ml.Store.Users()
+	assert.Equal(t, 1, len(users))
+}
@@ -0,0 +1,161 @@
+package mixerlib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// The components whose health Readiness reports.
+const (
+	// DepositPollerComponent is the poller sweeping deposits to the house.
+	DepositPollerComponent = "depositPoller"
+	// ReturnPollerComponent is the poller returning funds from the house.
+	ReturnPollerComponent = "returnPoller"
+	// JobcoinComponent is the Jobcoin API.
+	JobcoinComponent = "jobcoin"
+	// StoreComponent is where the mixer saves its state.
+	StoreComponent = "store"
+)
+
+// ComponentHealth is the health of one part of the mixer. LastSuccess is when
+// it was last seen working and is left out if it never has been.
+type ComponentHealth struct {
+	Healthy     bool       `json:"healthy"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Readiness reports whether the mixer is able to do its work, and the health
+// of each of its components.
+type Readiness struct {
+	Ready      bool                       `json:"ready"`
+	Components map[string]ComponentHealth `json:"components"`
+}
+
+// health records when each poller started and last finished a pass, and when
+// the store was last found working. The zero value is ready to use.
+type health struct {
+	mu          sync.Mutex
+	started     map[string]time.Time
+	lastSuccess map[string]time.Time
+}
+
+// pollerStarted records that the component's poll loop is running.
+func (h *health) pollerStarted(component string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started == nil {
+		h.started = map[string]time.Time{}
+	}
+	h.started[component] = time.Now()
+}
+
+// pollerStopped records that the component's poll loop has returned.
+func (h *health) pollerStopped(component string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.started, component)
+}
+
+// succeeded records that the component was just seen working.
+func (h *health) succeeded(component string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastSuccess == nil {
+		h.lastSuccess = map[string]time.Time{}
+	}
+	h.lastSuccess[component] = time.Now()
+}
+
+func (h *health) times(component string) (started, lastSuccess time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.started[component], h.lastSuccess[component]
+}
+
+// Readiness checks that both pollers are running and have finished a pass
+// within Config.PollStallTimeout, that requests to the Jobcoin API are not
+// paused by Breaker and that the store can be written to. The Jobcoin API is
+// only reported if Breaker is set, and is never called. The store is checked
+// each time it is called, so it should not be called more often than a probe
+// needs.
+func (ml *MixerLib) Readiness() Readiness {
+	readiness := Readiness{Ready: true, Components: map[string]ComponentHealth{}}
+	add := func(component string, h ComponentHealth) {
+		readiness.Components[component] = h
+		readiness.Ready = readiness.Ready && h.Healthy
+	}
+
+	now := time.Now()
+	add(DepositPollerComponent, ml.pollerHealth(DepositPollerComponent, now))
+	add(ReturnPollerComponent, ml.pollerHealth(ReturnPollerComponent, now))
+
+	if ml.Breaker != nil {
+		add(JobcoinComponent, ml.jobcoinHealth())
+	}
+
+	err := ml.Store.CheckWritable()
+	add(StoreComponent, ml.checkedHealth(StoreComponent, err))
+
+	return readiness
+}
+
+// pollerHealth reports a poller as stalled if it has gone longer than
+// Config.PollStallTimeout without finishing a pass, counting from when it
+// started if it has not finished one yet.
+func (ml *MixerLib) pollerHealth(component string, now time.Time) ComponentHealth {
+	started, lastSuccess := ml.health.times(component)
+	h := ComponentHealth{}
+	if !lastSuccess.IsZero() {
+		h.LastSuccess = &lastSuccess
+	}
+	if started.IsZero() {
+		h.Error = "not running"
+		return h
+	}
+
+	since := lastSuccess
+	if since.Before(started) {
+		since = started
+	}
+	if idle := now.Sub(since); idle > ml.Config.PollStallTimeout.Std() {
+		h.Error = fmt.Sprintf("no pass finished in %s", idle.Round(time.Second))
+		return h
+	}
+	h.Healthy = true
+	return h
+}
+
+// jobcoinHealth reports the Jobcoin API as unhealthy while Breaker is open,
+// with when it last responded to any of the mixer's requests.
+func (ml *MixerLib) jobcoinHealth() ComponentHealth {
+	h := ComponentHealth{Healthy: !ml.Breaker.Open()}
+	lastResponse := ml.Breaker.LastResponse()
+	if !lastResponse.IsZero() {
+		h.LastSuccess = &lastResponse
+	}
+	if !h.Healthy {
+		h.Error = clientlib.ErrCircuitOpen.Error()
+	}
+	return h
+}
+
+// checkedHealth records the result of checking the component and reports its health.
+func (ml *MixerLib) checkedHealth(component string, err error) ComponentHealth {
+	if err == nil {
+		ml.health.succeeded(component)
+	}
+	_, lastSuccess := ml.health.times(component)
+
+	h := ComponentHealth{Healthy: err == nil}
+	if !lastSuccess.IsZero() {
+		h.LastSuccess = &lastSuccess
+	}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	return h
+}
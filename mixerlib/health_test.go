@@ -0,0 +1,171 @@
+package mixerlib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// newReadyTestMixerLib returns a MixerLib whose pollers have both just
+// finished a pass, and whose Breaker has just seen the Jobcoin API respond.
+func newReadyTestMixerLib(jc clientlib.JobcoinClient) *MixerLib {
+	ml := newTestMixerLib(jc)
+	for _, component := range []string{DepositPollerComponent, ReturnPollerComponent} {
+		ml.health.pollerStarted(component)
+		ml.health.succeeded(component)
+	}
+	ml.Breaker = clientlib.NewCircuitBreaker(1, time.Minute)
+	requestThroughBreaker(ml.Breaker, clientlib.NewClientMock(http.StatusOK, []byte(`{"balance": "0"}`), nil))
+	return ml
+}
+
+// requestThroughBreaker makes a Jobcoin request with client, recording its
+// result with breaker.
+func requestThroughBreaker(breaker *clientlib.CircuitBreaker, client clientlib.HTTPClient) {
+	jl := &clientlib.JobcoinLib{Client: client, Breaker: breaker}
+	jl.GetAddressInfo(testBankFund)
+}
+
+// Begin Readiness tests
+func TestReadiness_ReadyWhenEveryComponentIsHealthy(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newReadyTestMixerLib(jobcoinMock)
+
+	readiness := ml.Readiness()
+
+	assert.True(t, readiness.Ready)
+	assert.Equal(t, 4, len(readiness.Components))
+	for component, h := range readiness.Components {
+		assert.True(t, h.Healthy, component)
+		assert.NotNil(t, h.LastSuccess, component)
+	}
+	assert.Equal(t, 0, jobcoinMock.Lookups)
+}
+
+func TestReadiness_ReportsStalledPoller(t *testing.T) {
+	ml := newReadyTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Config.PollStallTimeout = jobcoin.Duration(time.Minute)
+	stalledAt := time.Now().Add(-2 * time.Minute)
+	ml.health.started[DepositPollerComponent] = stalledAt
+	ml.health.lastSuccess[DepositPollerComponent] = stalledAt
+
+	readiness := ml.Readiness()
+
+	assert.False(t, readiness.Ready)
+	assert.False(t, readiness.Components[DepositPollerComponent].Healthy)
+	assert.Equal(t, "no pass finished in 2m0s", readiness.Components[DepositPollerComponent].Error)
+	assert.True(t, readiness.Components[ReturnPollerComponent].Healthy)
+}
+
+func TestReadiness_AllowsPollerTimeToFinishFirstPass(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.health.pollerStarted(DepositPollerComponent)
+
+	readiness := ml.Readiness()
+
+	assert.True(t, readiness.Components[DepositPollerComponent].Healthy)
+	assert.Nil(t, readiness.Components[DepositPollerComponent].LastSuccess)
+	assert.Equal(t, "not running", readiness.Components[ReturnPollerComponent].Error)
+}
+
+func TestReadiness_KeepsLastSuccessWhenJobcoinFails(t *testing.T) {
+	ml := newReadyTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+
+	requestThroughBreaker(ml.Breaker, clientlib.NewClientMock(0, nil, errors.New("connection refused")))
+	readiness := ml.Readiness()
+
+	assert.False(t, readiness.Ready)
+	assert.False(t, readiness.Components[JobcoinComponent].Healthy)
+	assert.NotNil(t, readiness.Components[JobcoinComponent].LastSuccess)
+	assert.Equal(t, clientlib.ErrCircuitOpen.Error(), readiness.Components[JobcoinComponent].Error)
+}
+
+func TestReadiness_LeavesOutJobcoinWithoutBreaker(t *testing.T) {
+	ml := newReadyTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Breaker = nil
+
+	readiness := ml.Readiness()
+
+	_, reported := readiness.Components[JobcoinComponent]
+	assert.True(t, readiness.Ready)
+	assert.False(t, reported)
+}
+
+func TestReadiness_ReportsStoreThatCannotBeWritten(t *testing.T) {
+	ml := newReadyTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	fs, path := newTestFileStore(t)
+	fs.path = path + "-missing/state.json"
+	ml.Store = fs
+
+	readiness := ml.Readiness()
+
+	assert.False(t, readiness.Ready)
+	assert.False(t, readiness.Components[StoreComponent].Healthy)
+	assert.Nil(t, readiness.Components[StoreComponent].LastSuccess)
+}
+
+// Begin poller health tests
+func TestPollForNewDeposits_RecordsFinishedPasses(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, errors.New("GetAddressInfo failed"), nil))
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd"})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	done := make(chan bool)
+	go func() {
+		ml.PollForNewDeposits(ctx, &time.Ticker{C: tick})
+		done <- true
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, lastSuccess := ml.health.times(DepositPollerComponent)
+		return !lastSuccess.IsZero()
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+	started, _ := ml.health.times(DepositPollerComponent)
+	assert.True(t, started.IsZero())
+}
+
+// unreadableStore is a Store whose users and house queue cannot be loaded.
+type unreadableStore struct {
+	Store
+}
+
+func (s *unreadableStore) Users() ([]MixerUser, error) {
+	return nil, errors.New("Users failed")
+}
+
+func (s *unreadableStore) HouseQueue() ([]MixerUser, error) {
+	return nil, errors.New("HouseQueue failed")
+}
+
+func TestProcessMixerUsers_DoesNotRecordSuccessIfUsersCannotBeLoaded(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Store = &unreadableStore{Store: ml.Store}
+
+	pollDeposits(ml, time.Now())
+
+	_, lastSuccess := ml.health.times(DepositPollerComponent)
+	assert.True(t, lastSuccess.IsZero())
+}
+
+func TestProcessHouseUsers_DoesNotRecordSuccessIfHouseQueueCannotBeLoaded(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Store = &unreadableStore{Store: ml.Store}
+
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	ml.processHouseUsers(context.Background(), &time.Ticker{C: tick})
+
+	_, lastSuccess := ml.health.times(ReturnPollerComponent)
+	assert.True(t, lastSuccess.IsZero())
+}
@@ -0,0 +1,191 @@
+package mixerlib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/google/uuid"
+)
+
+// HouseAccount holds the addresses the mixer moves user funds through.
+// It is loaded from the Store so that the same addresses are used across
+// restarts. User funds are mixed through Address and the other addresses in
+// Pool, see Mixing. Previous house addresses are kept in Rotations because
+// user deposits sent to them still count towards each user's house balance.
+type HouseAccount struct {
+	Address   string          `json:"address"`
+	Pool      []string        `json:"pool"`
+	BankFund  string          `json:"bankFund"`
+	Rotations []HouseRotation `json:"rotations"`
+}
+
+// HouseRotation is the audit record of the house address being replaced.
+type HouseRotation struct {
+	PreviousAddress string           `json:"previousAddress"`
+	NewAddress      string           `json:"newAddress"`
+	Reason          string           `json:"reason"`
+	MigratedAmount  clientlib.Amount `json:"migratedAmount"`
+	Migrated        bool             `json:"migrated"`
+	RotatedAt       time.Time        `json:"rotatedAt"`
+}
+
+// Addresses returns the current house addresses followed by every address
+// the main house address has previously replaced, most recent first.
+func (ha HouseAccount) Addresses() []string {
+	addresses := ha.Mixing()
+	for i := len(ha.Rotations) - 1; i >= 0; i-- {
+		addresses = append(addresses, ha.Rotations[i].PreviousAddress)
+	}
+	return addresses
+}
+
+// Retired reports whether address is a house address that has been replaced
+// by a rotation.
+func (ha HouseAccount) Retired(address string) bool {
+	for _, rotation := range ha.Rotations {
+		if rotation.PreviousAddress == address {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadHouseAccount sets the house account from the store. The first time the
+// mixer runs a new house address is generated and saved. The bank fund is
+// updated if it has been changed in the config, and new addresses are added
+// to the pool if it is smaller than the configured size. Any rotation whose
+// funds were not fully migrated, e.g. due to a crash, is completed.
+func (ml *MixerLib) LoadHouseAccount() error {
+	house, err := ml.Store.HouseAccount()
+	if err != nil {
+		return err
+	}
+
+	if house.Address == "" {
+		address, err := newHouseAddress()
+		if err != nil {
+			return err
+		}
+		house = HouseAccount{
+			Address:  address,
+			BankFund: ml.Config.BankFund,
+		}
+		err = ml.Store.SaveHouseAccount(house)
+		if err != nil {
+			return err
+		}
+		ml.logger().Info("Created house address", "address", house.Address)
+	}
+
+	if ml.Config.BankFund != "" && house.BankFund != ml.Config.BankFund {
+		ml.logger().Info("Changing bank fund", "from", house.BankFund, "to", ml.Config.BankFund)
+		house.BankFund = ml.Config.BankFund
+		err = ml.Store.SaveHouseAccount(house)
+		if err != nil {
+			return err
+		}
+	}
+	ml.House = house
+
+	err = ml.growHousePool()
+	if err != nil {
+		return err
+	}
+
+	for i, rotation := range ml.House.Rotations {
+		if !rotation.Migrated {
+			err = ml.migrateHouseFunds(i)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RotateHouseAddress replaces the main house address with a new one and
+// moves the old address's balance over to it. The rest of the pool is kept. The reason is kept alongside
+// the rotation so that every change of house address can be audited.
+func (ml *MixerLib) RotateHouseAddress(reason string) (HouseRotation, error) {
+	if reason == "" {
+		return HouseRotation{}, errors.New("a reason is required to rotate the house address")
+	}
+
+	address, err := newHouseAddress()
+	if err != nil {
+		return HouseRotation{}, err
+	}
+
+	// Record the rotation before moving any funds so that the old address is
+	// never forgotten, even if the migration below fails part way through.
+	house := ml.House
+	house.Rotations = append(append([]HouseRotation{}, house.Rotations...), HouseRotation{
+		PreviousAddress: house.Address,
+		NewAddress:      address,
+		Reason:          reason,
+		RotatedAt:       time.Now(),
+	})
+	house.Address = address
+
+	err = ml.Store.SaveHouseAccount(house)
+	if err != nil {
+		return HouseRotation{}, err
+	}
+	ml.House = house
+
+	idx := len(house.Rotations) - 1
+	err = ml.migrateHouseFunds(idx)
+	if err != nil {
+		return ml.House.Rotations[idx], err
+	}
+
+	return ml.House.Rotations[idx], nil
+}
+
+// migrateHouseFunds sends whatever balance is left in the previous address of
+// the given rotation to the current house address and marks it as migrated.
+func (ml *MixerLib) migrateHouseFunds(idx int) error {
+	rotation := ml.House.Rotations[idx]
+
+	info, err := ml.JobcoinClient.GetAddressInfo(rotation.PreviousAddress)
+	if err != nil {
+		return err
+	}
+
+	balance := info.Balance
+	if balance > 0 {
+		err = ml.JobcoinClient.SendJobcoin(rotation.PreviousAddress, ml.House.Address, balance)
+		if err != nil {
+			return err
+		}
+		ml.recordHouseTransfer(rotation.PreviousAddress, ml.House.Address, balance, "rotation")
+	}
+
+	house := ml.House
+	house.Rotations = append([]HouseRotation{}, house.Rotations...)
+	house.Rotations[idx].MigratedAmount = rotation.MigratedAmount + balance
+	house.Rotations[idx].Migrated = true
+
+	err = ml.Store.SaveHouseAccount(house)
+	if err != nil {
+		return err
+	}
+	ml.House = house
+
+	ml.logger().Info(
+		"Rotated house address",
+		"from", rotation.PreviousAddress, "to", house.Address, "reason", rotation.Reason, "migrated", house.Rotations[idx].MigratedAmount,
+	)
+
+	return nil
+}
+
+func newHouseAddress() (string, error) {
+	address, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+	return address.String(), nil
+}
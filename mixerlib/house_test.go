@@ -0,0 +1,135 @@
+package mixerlib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin LoadHouseAccount tests
+func TestLoadHouseAccount_CreatesAndSavesHouseAccountOnFirstRun(t *testing.T) {
+	ml := &MixerLib{Store: NewMemoryStore(), Config: jobcoin.MixerConfig{BankFund: testBankFund}}
+
+	err := ml.LoadHouseAccount()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	saved, _ := ml.Store.HouseAccount()
+
+	assert.Equal(t, 36, len(ml.House.Address))
+	assert.Equal(t, testBankFund, ml.House.BankFund)
+	assert.Equal(t, saved, ml.House)
+}
+
+func TestLoadHouseAccount_UsesSavedHouseAccount(t *testing.T) {
+	house := HouseAccount{
+		Address:  "saved-house",
+		BankFund: "saved-bank-fund",
+	}
+	ml := &MixerLib{Store: NewMemoryStore()}
+	ml.Store.SaveHouseAccount(house)
+
+	err := ml.LoadHouseAccount()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, house, ml.House)
+}
+
+func TestLoadHouseAccount_UpdatesBankFundFromConfig(t *testing.T) {
+	house := HouseAccount{
+		Address:  "saved-house",
+		BankFund: "saved-bank-fund",
+	}
+	ml := &MixerLib{Store: NewMemoryStore(), Config: jobcoin.MixerConfig{BankFund: "new-bank-fund"}}
+	ml.Store.SaveHouseAccount(house)
+
+	err := ml.LoadHouseAccount()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	saved, _ := ml.Store.HouseAccount()
+
+	assert.Equal(t, "saved-house", ml.House.Address)
+	assert.Equal(t, "new-bank-fund", ml.House.BankFund)
+	assert.Equal(t, saved, ml.House)
+}
+
+func TestLoadHouseAccount_FinishesIncompleteMigrations(t *testing.T) {
+	house := HouseAccount{
+		Address:  "new-house",
+		BankFund: testBankFund,
+		Rotations: []HouseRotation{
+			{PreviousAddress: "old-house", NewAddress: "new-house", Reason: "testing"},
+		},
+	}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: clientlib.MustParseAmount("12.5")}, nil, nil)
+	ml := &MixerLib{JobcoinClient: jobcoinMock, Store: NewMemoryStore()}
+	ml.Store.SaveHouseAccount(house)
+
+	err := ml.LoadHouseAccount()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedTx := clientlib.JobcoinTx{FromAddress: "old-house", ToAddress: "new-house", Amount: clientlib.MustParseAmount("12.5")}
+	assert.Equal(t, []clientlib.JobcoinTx{expectedTx}, jobcoinMock.(*mockJobcoinClient).Sent)
+	assert.True(t, ml.House.Rotations[0].Migrated)
+	assert.Equal(t, clientlib.MustParseAmount("12.5"), ml.House.Rotations[0].MigratedAmount)
+}
+
+// Begin RotateHouseAddress tests
+func TestRotateHouseAddress_MovesBalanceToNewAddressAndKeepsHistory(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: clientlib.MustParseAmount("40")}, nil, nil)
+	ml := newTestMixerLib(jobcoinMock)
+
+	rotation, err := ml.RotateHouseAddress("suspected address leak")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	saved, _ := ml.Store.HouseAccount()
+	expectedTx := clientlib.JobcoinTx{FromAddress: testHouseAddress, ToAddress: ml.House.Address, Amount: clientlib.MustParseAmount("40")}
+
+	assert.NotEqual(t, testHouseAddress, ml.House.Address)
+	assert.Equal(t, testHouseAddress, rotation.PreviousAddress)
+	assert.Equal(t, ml.House.Address, rotation.NewAddress)
+	assert.Equal(t, "suspected address leak", rotation.Reason)
+	assert.Equal(t, clientlib.MustParseAmount("40"), rotation.MigratedAmount)
+	assert.True(t, rotation.Migrated)
+	assert.Equal(t, []string{ml.House.Address, testHouseAddress}, ml.House.Addresses())
+	assert.Equal(t, ml.House, saved)
+	assert.Equal(t, []clientlib.JobcoinTx{expectedTx}, jobcoinMock.(*mockJobcoinClient).Sent)
+}
+
+func TestRotateHouseAddress_ReturnsErrorIfNoReasonGiven(t *testing.T) {
+	ml := newTestMixerLib(nil)
+
+	_, err := ml.RotateHouseAddress("")
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	assert.Equal(t, testHouseAddress, ml.House.Address)
+}
+
+func TestRotateHouseAddress_KeepsRotationIfMigrationFails(t *testing.T) {
+	expectedErr := errors.New("SendJobcoin failed")
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: clientlib.MustParseAmount("40")}, nil, expectedErr)
+	ml := newTestMixerLib(jobcoinMock)
+
+	rotation, err := ml.RotateHouseAddress("scheduled rotation")
+
+	saved, _ := ml.Store.HouseAccount()
+
+	assert.Equal(t, expectedErr, err)
+	assert.False(t, rotation.Migrated)
+	assert.Equal(t, ml.House, saved)
+	assert.Equal(t, testHouseAddress, saved.Rotations[0].PreviousAddress)
+}
@@ -1,27 +1,52 @@
 package mixerlib
 
 import (
+	"sync"
+
 	"github.com/ckaminer/jobcoin/clientlib"
 )
 
 type mockJobcoinClient struct {
 	AddressInfo     clientlib.JobcoinAddressInfo
+	AddressInfos    map[string]clientlib.JobcoinAddressInfo
 	GetAddressError error
 	SendError       error
+	Sent            []clientlib.JobcoinTx
+	Lookups         int
+
+	mu sync.Mutex
 }
 
 func (mc *mockJobcoinClient) GetAddressInfo(address string) (clientlib.JobcoinAddressInfo, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.Lookups++
+	if info, ok := mc.AddressInfos[address]; ok {
+		return info, mc.GetAddressError
+	}
 	return mc.AddressInfo, mc.GetAddressError
 }
 
-func (mc *mockJobcoinClient) SendJobcoin(fromAddress, toAddress, amount string) error {
+func (mc *mockJobcoinClient) SendJobcoin(fromAddress, toAddress string, amount clientlib.Amount) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.SendError == nil {
+		mc.Sent = append(mc.Sent, clientlib.JobcoinTx{
+			FromAddress: fromAddress,
+			ToAddress:   toAddress,
+			Amount:      amount,
+		})
+	}
 	return mc.SendError
 }
 
 // newJobcoinMock returns a mock implementation of the JobcoinClient interface.
-// addressInfo will be the value returned in GetAddressInfo.
+// addressInfo will be the value returned in GetAddressInfo, unless the address
+// has its own entry in AddressInfos.
 // addressErr will be the error returned in GetAddressInfo.
 // sendErr will be the error returned in SendJobcoin.
+// Successful sends are recorded in Sent and calls to GetAddressInfo counted in Lookups.
+// It is safe for concurrent use.
 func newJobcoinMock(addressInfo clientlib.JobcoinAddressInfo, addressErr, sendErr error) clientlib.JobcoinClient {
 	return &mockJobcoinClient{
 		AddressInfo:     addressInfo,
@@ -0,0 +1,133 @@
+package mixerlib
+
+import (
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/google/uuid"
+)
+
+// LedgerEntry is a single posting in the mixer's double-entry ledger. Amount
+// is added to the Debit account and taken from the Credit account, so the
+// balances of every account always add up to zero.
+//
+// The ledger is the source of truth for how much each user has left in the
+// house. A sweep credits the user's account and debits the house address it
+// was sent to, a payout does the opposite and moving funds between house
+// addresses only touches house accounts.
+type LedgerEntry struct {
+	ID             string           `json:"id"`
+	Debit          string           `json:"debit"`
+	Credit         string           `json:"credit"`
+	Amount         clientlib.Amount `json:"amount"`
+	DepositAddress string           `json:"depositAddress,omitempty"`
+	Memo           string           `json:"memo"`
+	PostedAt       time.Time        `json:"postedAt"`
+}
+
+// houseLedgerAccount is the account holding what the mixer believes a house
+// address holds. It has a debit, i.e. positive, balance.
+func houseLedgerAccount(address string) string {
+	return "house:" + address
+}
+
+// userLedgerAccount is the account holding what the house owes a user. It has
+// a credit, i.e. negative, balance.
+func userLedgerAccount(depositAddress string) string {
+	return "user:" + depositAddress
+}
+
+// LedgerDiscrepancy is a house address whose balance on the Jobcoin network
+// does not match its balance in the ledger.
+type LedgerDiscrepancy struct {
+	Address string           `json:"address"`
+	Ledger  clientlib.Amount `json:"ledger"`
+	Jobcoin clientlib.Amount `json:"jobcoin"`
+}
+
+// Difference is how much more the address holds than the ledger says it should.
+func (d LedgerDiscrepancy) Difference() clientlib.Amount {
+	return d.Jobcoin - d.Ledger
+}
+
+// postLedger saves entry to the ledger, giving it an ID if it has none. An
+// entry with the ID of one already posted is ignored, so entries for the same
+// movement of funds can safely be posted more than once.
+func (ml *MixerLib) postLedger(entry LedgerEntry) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	entry.PostedAt = time.Now()
+	return ml.Store.PostLedgerEntries(entry)
+}
+
+// recordHouseTransfer posts the movement of funds from one house address to
+// another. The funds have already moved, so a failure to post is logged rather
+// than returned and will show up when the ledger is next reconciled.
+func (ml *MixerLib) recordHouseTransfer(from, to string, amount clientlib.Amount, memo string) {
+	err := ml.postLedger(LedgerEntry{
+		Debit:  houseLedgerAccount(to),
+		Credit: houseLedgerAccount(from),
+		Amount: amount,
+		Memo:   memo,
+	})
+	if err != nil {
+		ml.logger().Error("Failed to record house transfer in ledger", "memo", memo, "amount", amount, "from", from, "to", to, "error", err)
+	}
+}
+
+// userHouseBalance returns how much the house still owes the user according
+// to the ledger.
+func (ml *MixerLib) userHouseBalance(depositAddress string) (clientlib.Amount, error) {
+	balance, err := ml.Store.LedgerBalance(userLedgerAccount(depositAddress))
+	if err != nil {
+		return 0, err
+	}
+	return -balance, nil
+}
+
+// ReconcileLedger compares the ledger balance of every house address, current
+// and previous, with its balance on the Jobcoin network and returns those that
+// differ. A sweep that is being sent while this runs can show up as a
+// discrepancy until it has been recorded.
+func (ml *MixerLib) ReconcileLedger() ([]LedgerDiscrepancy, error) {
+	balances, err := ml.Store.LedgerBalances()
+	if err != nil {
+		return nil, err
+	}
+
+	houses := ml.House.Addresses()
+	infos, err := ml.addressInfos(houses)
+	if err != nil {
+		return nil, err
+	}
+	return ledgerDiscrepancies(houses, balances, infos), nil
+}
+
+func ledgerDiscrepancies(houses []string, balances map[string]clientlib.Amount, infos map[string]clientlib.JobcoinAddressInfo) []LedgerDiscrepancy {
+	discrepancies := []LedgerDiscrepancy{}
+	for _, address := range houses {
+		expected := balances[houseLedgerAccount(address)]
+		if infos[address].Balance != expected {
+			discrepancies = append(discrepancies, LedgerDiscrepancy{
+				Address: address,
+				Ledger:  expected,
+				Jobcoin: infos[address].Balance,
+			})
+		}
+	}
+	return discrepancies
+}
+
+// addressInfos fetches the info of each of the given addresses.
+func (ml *MixerLib) addressInfos(addresses []string) (map[string]clientlib.JobcoinAddressInfo, error) {
+	infos := map[string]clientlib.JobcoinAddressInfo{}
+	for _, address := range addresses {
+		info, err := ml.JobcoinClient.GetAddressInfo(address)
+		if err != nil {
+			return nil, err
+		}
+		infos[address] = info
+	}
+	return infos, nil
+}
@@ -0,0 +1,140 @@
+package mixerlib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin ledger sweep tests
+func TestTransferDepositToHouse_CreditsUserInLedger(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	mockAddressInfo := clientlib.JobcoinAddressInfo{
+		Balance: 10 * clientlib.Coin,
+	}
+	ml := newTestMixerLib(newJobcoinMock(mockAddressInfo, nil, nil))
+
+	_, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	balance, _ := ml.userHouseBalance(user.DepositAddress)
+	balances, _ := ml.Store.LedgerBalances()
+
+	assert.Equal(t, clientlib.MustParseAmount("9.9"), balance)
+	assert.Equal(t, clientlib.MustParseAmount("9.9"), balances[houseLedgerAccount(testHouseAddress)])
+}
+
+func TestMarkSweep_CreditsUserOnlyOnceIfSweepNotSaved(t *testing.T) {
+	sweep := Sweep{
+		DepositAddress: "1234abcd",
+		Balance:        10 * clientlib.Coin,
+		HouseAmount:    10 * clientlib.Coin,
+		HouseAddress:   testHouseAddress,
+		FeeSent:        true,
+		StartedAt:      time.Now(),
+	}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+
+	// The same leg is marked twice, as if the sweep failed to save after the
+	// first time and was found in the transaction history again.
+	ml.markSweep(sweep, true, true)
+	ml.markSweep(sweep, true, true)
+
+	balance, _ := ml.userHouseBalance(sweep.DepositAddress)
+	entries, _ := ml.Store.LedgerEntries()
+
+	assert.Equal(t, 10*clientlib.Coin, balance)
+	assert.Equal(t, 1, len(entries))
+}
+
+// Begin ledger payout tests
+func TestSendDuePayouts_DebitsUserInLedger(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	creditUser(ml, user.DepositAddress, 3*clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+
+	balance, _ := ml.userHouseBalance(user.DepositAddress)
+	balances, _ := ml.Store.LedgerBalances()
+
+	assert.Equal(t, 2*clientlib.Coin, balance)
+	assert.Equal(t, 2*clientlib.Coin, balances[houseLedgerAccount(testHouseAddress)])
+}
+
+func TestSendDuePayouts_ReversesDebitIfPayoutFails(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, errors.New("SendJobcoin failed")))
+	creditUser(ml, user.DepositAddress, 3*clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+
+	balance, _ := ml.userHouseBalance(user.DepositAddress)
+	entries, _ := ml.Store.LedgerEntries()
+
+	assert.Equal(t, 3*clientlib.Coin, balance)
+	assert.Equal(t, 3, len(entries))
+}
+
+func TestShuffleHouseFunds_RecordsTransferInLedger(t *testing.T) {
+	jobcoinMock := newTestPoolMock(map[string]clientlib.Amount{
+		testHouseAddress: 100 * clientlib.Coin,
+		"pool-one":       0,
+	})
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Pool = []string{"pool-one"}
+	creditUser(ml, "1234abcd", 100*clientlib.Coin)
+
+	ml.ShuffleHouseFunds()
+
+	balances, _ := ml.Store.LedgerBalances()
+	moved := jobcoinMock.Sent[0].Amount
+
+	assert.Equal(t, 100*clientlib.Coin-moved, balances[houseLedgerAccount(testHouseAddress)])
+	assert.Equal(t, moved, balances[houseLedgerAccount("pool-one")])
+	assert.Equal(t, -100*clientlib.Coin, balances[userLedgerAccount("1234abcd")])
+}
+
+// Begin ReconcileLedger tests
+func TestReconcileLedger_ReturnsHousesThatDoNotMatchJobcoin(t *testing.T) {
+	jobcoinMock := newTestPoolMock(map[string]clientlib.Amount{
+		testHouseAddress: 5 * clientlib.Coin,
+		"pool-one":       3 * clientlib.Coin,
+	})
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Pool = []string{"pool-one"}
+	creditUser(ml, "1234abcd", 5*clientlib.Coin)
+
+	discrepancies, err := ml.ReconcileLedger()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expected := []LedgerDiscrepancy{
+		{Address: "pool-one", Ledger: 0, Jobcoin: 3 * clientlib.Coin},
+	}
+	assert.Equal(t, expected, discrepancies)
+	assert.Equal(t, 3*clientlib.Coin, discrepancies[0].Difference())
+}
+
+func TestReconcileIfDue_WaitsForReconcileInterval(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Config.ReconcileInterval = jobcoin.Duration(time.Minute)
+	start := time.Now()
+
+	ml.reconcileIfDue(start)
+	ml.reconcileIfDue(start.Add(30 * time.Second))
+	assert.Equal(t, 0, jobcoinMock.Lookups)
+
+	ml.reconcileIfDue(start.Add(time.Minute))
+	assert.NotEqual(t, 0, jobcoinMock.Lookups)
+}
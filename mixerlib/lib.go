@@ -1,186 +1,239 @@
 package mixerlib
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
 	"math/rand"
-	"strconv"
 	"time"
 
+	"github.com/ckaminer/jobcoin"
 	"github.com/ckaminer/jobcoin/clientlib"
 )
 
-// MixerUsers is a collection of user information.
-// When users use the Jobcoin mixer their information will be stored here.
-var MixerUsers = []MixerUser{}
+// minimumSplitAmount is the smallest amount assignReturnAmounts will split
+// between more than one return address.
+const minimumSplitAmount = clientlib.Coin / 10000
 
-// HouseQueue is a collection of users whose money has been moved from
-// their deposit address to the house address. It is a subset of MixerUsers.
-var HouseQueue = []MixerUser{}
-
-// HouseAddress is the address used for the house account. This will
-// be the Jobcoin repository for user submitted coins though no user
-// transactions should send Jobcoins directly to the house address.
-// Note it is reset when the app server starts.
-var HouseAddress = "121212-house-address-121212"
-
-// MixerBankFund is the address used for the collection of service fees.
-// When users deposit money into their deposit addresses, a percentage will
-// be taken from that deposit and sent to the MixerBankFund.
-const MixerBankFund = "121212-bank-fund-121212"
-
-// ServiceFeePctg is the amount of each deposit that will be collected
-// by the Mixer. This value times each deposit will be sent to the
-// MixerBankFund upond delivery of money from deposit address to the house.
-const ServiceFeePctg = 0.01
-
-// DistributionIncrement represents the amount of Jobcoin that will be returned
-// back to users during each round of returns.
-const DistributionIncrement = 5.0
-
-// MixerUser organizes addresses and transactions for a client of the Jobcoin Mixer
+// MixerUser organizes addresses and transactions for a client of the Jobcoin
+// Mixer. WebhookURL, if set, is where the user's events are sent, see Webhooks.
+// RefundAddress, if set, is where deposits outside the deposit limits are
+// sent if the mixer refunds them. RegisteredAt is when the user registered,
+// from which their deposit address expires. A user without one never expires.
 type MixerUser struct {
-	DepositAddress  string   `json:"depositAddress"`
-	ReturnAddresses []string `json:"returnAddresses"`
+	DepositAddress  string    `json:"depositAddress"`
+	ReturnAddresses []string  `json:"returnAddresses"`
+	WebhookURL      string    `json:"webhookUrl,omitempty"`
+	RefundAddress   string    `json:"refundAddress,omitempty"`
+	RegisteredAt    time.Time `json:"registeredAt"`
 }
 
 // MixerClient is an interface respresenting functionality needed to
-// interact with the Jobcoin Mixer.
+// interact with the Jobcoin Mixer. Polling stops when the given context is cancelled.
 type MixerClient interface {
-	PollForNewDeposits(ticker *time.Ticker, userChan, houseChan chan MixerUser)
-	PollForUserReturns(ticker *time.Ticker, houseChan chan MixerUser)
+	PollForNewDeposits(ctx context.Context, ticker *time.Ticker)
+	PollForUserReturns(ctx context.Context, ticker *time.Ticker)
 }
 
 // MixerLib is an implementation of the MixerClient interface. It requires
-// a JobcoinClient to interact with the Jobcoin API.
+// a JobcoinClient to interact with the Jobcoin API and a Store to keep track
+// of users, the house queue and the ledger. Users should only be added to the Store
+// through a Registry. House is the house account user funds are
+// mixed through, see LoadHouseAccount. Config sets the service fee and how
+// funds are returned to users. Amounts, if set, replaces the AmountStrategy
+// chosen by Config. HouseQueue hands users from the deposit poller to the
+// return poller; if it is nil a queue without a limit is kept in Store.
+// Breaker, if set, is the circuit breaker JobcoinClient uses, which Readiness
+// reports the health of the Jobcoin API from. Metrics, if set, records the movement of funds, Events,
+// if set, publishes it for each user and Webhooks, if set, sends it to users
+// with a webhook URL. Logger is where the mixer logs what it does,
+// slog.Default() if it is nil.
 type MixerLib struct {
 	JobcoinClient clientlib.JobcoinClient
+	Store         Store
+	House         HouseAccount
+	Config        jobcoin.MixerConfig
+	Amounts       AmountStrategy
+	HouseQueue    *HouseQueue
+	Breaker       *clientlib.CircuitBreaker
+	Metrics       *Metrics
+	Events        *Events
+	Webhooks      *Webhooks
+	Logger        *slog.Logger
+
+	lastShuffle      time.Time
+	lastReconcile    time.Time
+	lastExpiredSweep time.Time
+	nextDepositUser  int
+	health           health
 }
 
-func (ml *MixerLib) transferDepositToHouse(user MixerUser) (bool, error) {
-	sentToHouse := false
+func (ml *MixerLib) logger() *slog.Logger {
+	if ml.Logger == nil {
+		return slog.Default()
+	}
+	return ml.Logger
+}
+
+// houseQueue returns HouseQueue, or a queue without a limit kept in Store if
+// it is nil.
+func (ml *MixerLib) houseQueue() *HouseQueue {
+	if ml.HouseQueue == nil {
+		return &HouseQueue{Store: ml.Store, Logger: ml.Logger}
+	}
+	return ml.HouseQueue
+}
 
+// userLogger returns a logger that tags every entry with the user's deposit address.
+func (ml *MixerLib) userLogger(depositAddress string) *slog.Logger {
+	return ml.logger().With("depositAddress", depositAddress)
+}
+
+// publish passes event on to the user's subscribers and webhook.
+func (ml *MixerLib) publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	ml.Events.publish(event)
+	ml.Webhooks.notify(event)
+}
+
+// transferDepositToHouse sweeps the balance of the user's deposit address to
+// the house, less the service fee, and returns true once it has reached the
+// house. If an earlier sweep for the user is still in the journal it is
+// finished instead, and any newer deposit is left for the next call.
+func (ml *MixerLib) transferDepositToHouse(user MixerUser) (bool, error) {
 	info, err := ml.JobcoinClient.GetAddressInfo(user.DepositAddress)
 	if err != nil {
-		return sentToHouse, err
+		return false, err
 	}
 
-	balance, _ := strconv.ParseFloat(info.Balance, 64)
-	if balance > 0 {
-		sentToHouse = true
-		bankFee := balance * ServiceFeePctg
-
-		bankAmount := fmt.Sprintf("%g", bankFee)
-		houseAmount := fmt.Sprintf("%g", balance-bankFee)
+	sweep, pending, err := ml.Store.Sweep(user.DepositAddress)
+	if err != nil {
+		return false, err
+	}
 
-		err = ml.JobcoinClient.SendJobcoin(user.DepositAddress, MixerBankFund, bankAmount)
-		if err != nil {
-			return false, err
+	var fee clientlib.Amount
+	var admitted bool
+	if pending {
+		sweep, err = ml.reconcileSweep(sweep, info)
+		if err == nil {
+			sweep, err = ml.retargetSweep(sweep)
 		}
-		err = ml.JobcoinClient.SendJobcoin(user.DepositAddress, HouseAddress, houseAmount)
-		if err != nil {
+	} else if info.Balance > 0 {
+		fee, admitted, err = ml.admitDeposit(user, info.Balance)
+		if err != nil || !admitted {
 			return false, err
 		}
+		sweep, err = ml.startSweep(info, user.DepositAddress, fee)
+	} else {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	return sentToHouse, nil
+	err = ml.finishSweep(sweep)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
+// returnFundsToUser sends whichever of the user's scheduled payouts are due.
+// If the user has no payouts scheduled, whatever the ledger says they still
+// have in the house is scheduled first. It returns true once nothing is left to return.
 func (ml *MixerLib) returnFundsToUser(user MixerUser) (bool, error) {
-	sendingEntireBalance := true
-
-	houseBalance, err := ml.calculateHouseBalanceForUser(user)
+	payouts, err := ml.Store.Payouts(user.DepositAddress)
 	if err != nil {
 		return false, err
 	}
 
-	if houseBalance > 0 {
-		distAmount := houseBalance
-		if houseBalance > DistributionIncrement {
-			distAmount = DistributionIncrement
-			sendingEntireBalance = false
+	if len(payouts) == 0 {
+		houseBalance, err := ml.userHouseBalance(user.DepositAddress)
+		if err != nil {
+			return false, err
+		}
+		if houseBalance <= 0 {
+			return true, nil
 		}
 
-		returnAmounts := ml.assignReturnAmounts(user.ReturnAddresses, distAmount)
-
-		for address, amount := range returnAmounts {
-			err := ml.JobcoinClient.SendJobcoin(HouseAddress, address, amount)
-			if err != nil {
-				sendingEntireBalance = false
-				continue
-			}
+		payouts, err = ml.schedulePayouts(user, houseBalance)
+		if err != nil {
+			return false, err
 		}
 	}
-	return sendingEntireBalance, nil
+
+	return ml.sendDuePayouts(user, payouts)
 }
 
-func (ml *MixerLib) calculateHouseBalanceForUser(user MixerUser) (float64, error) {
-	houseInfo, err := ml.JobcoinClient.GetAddressInfo(HouseAddress)
+// recordProgress applies change to the user's stored distribution progress.
+// Progress is only used to report on users, so a failure to save it is logged
+// rather than interrupting the movement of funds.
+func (ml *MixerLib) recordProgress(depositAddress string, change func(p *DistributionProgress)) {
+	_, err := ml.Store.UpdateProgress(depositAddress, change)
 	if err != nil {
-		return 0, err
+		ml.userLogger(depositAddress).Error("Failed to record progress", "error", err)
 	}
+}
 
-	var userHouseDepositTotal float64
-	var returnedToUserTotal float64
-
-	for _, tx := range houseInfo.Transactions {
-		if tx.FromAddress == user.DepositAddress && tx.ToAddress == HouseAddress {
-			amount, _ := strconv.ParseFloat(tx.Amount, 64)
-			userHouseDepositTotal = userHouseDepositTotal + amount
+// calculateHouseBalanceForUser sums what the user has sent to, less what has been
+// returned from, every house address in the pool and every house address before them.
+// Transfers between house addresses do not involve the user's addresses, so they
+// do not change the result. It fetches the full history of every house address,
+// so returns are worked out from the ledger instead.
+func (ml *MixerLib) calculateHouseBalanceForUser(user MixerUser) (clientlib.Amount, error) {
+	var userHouseDepositTotal clientlib.Amount
+	var returnedToUserTotal clientlib.Amount
+
+	for _, houseAddress := range ml.House.Addresses() {
+		houseInfo, err := ml.JobcoinClient.GetAddressInfo(houseAddress)
+		if err != nil {
+			return 0, err
 		}
 
-		sentToUserAddress := containsElement(user.ReturnAddresses, tx.ToAddress)
-		if tx.FromAddress == HouseAddress && sentToUserAddress {
-			amount, _ := strconv.ParseFloat(tx.Amount, 64)
-			returnedToUserTotal = returnedToUserTotal + amount
+		for _, tx := range houseInfo.Transactions {
+			if tx.FromAddress == user.DepositAddress && tx.ToAddress == houseAddress {
+				userHouseDepositTotal = userHouseDepositTotal + tx.Amount
+			}
+
+			sentToUserAddress := containsElement(user.ReturnAddresses, tx.ToAddress)
+			if tx.FromAddress == houseAddress && sentToUserAddress {
+				returnedToUserTotal = returnedToUserTotal + tx.Amount
+			}
 		}
 	}
 
 	return userHouseDepositTotal - returnedToUserTotal, nil
 }
 
-func (ml *MixerLib) assignReturnAmounts(addresses []string, distAmount float64) map[string]string {
+// assignReturnAmounts splits distAmount between the given addresses in random
+// amounts. The amounts assigned always add up to exactly distAmount.
+func (ml *MixerLib) assignReturnAmounts(addresses []string, distAmount clientlib.Amount) map[string]clientlib.Amount {
 	rand.Seed(time.Now().UnixNano())
-	returnAmounts := make(map[string]string)
+	returnAmounts := make(map[string]clientlib.Amount)
 
 	if len(addresses) > 0 {
 		// Randomly assign values to all but last address
 		for i := 0; i < len(addresses)-1; i++ {
 			returnAddress := addresses[i]
-			if distAmount < 0.0001 {
-				amount := fmt.Sprintf("%g", distAmount)
-				returnAmounts[returnAddress] = amount
+			if distAmount < minimumSplitAmount {
 				break
 			}
-			randAmount := distAmount * 0.9 * rand.Float64()
+			randAmount := clientlib.Amount(rand.Int63n(int64(distAmount.BasisPoints(9000)) + 1))
 			if randAmount > 0 {
-				amount := fmt.Sprintf("%g", randAmount)
-				returnAmounts[returnAddress] = amount
+				returnAmounts[returnAddress] = randAmount
 			}
 
 			distAmount = distAmount - randAmount
 		}
 
 		// Assign reminder to last address
-		returnAmounts[addresses[len(addresses)-1]] = fmt.Sprintf("%g", distAmount)
-	}
-
-	return returnAmounts
-}
-
-// ValidUserAddresses returns a thing
-func ValidUserAddresses(addresses []string) (string, bool) {
-	allReturnAddresses := []string{}
-	for _, user := range MixerUsers {
-		allReturnAddresses = append(allReturnAddresses, user.ReturnAddresses...)
-	}
-
-	for _, address := range addresses {
-		if containsElement(allReturnAddresses, address) {
-			return address, false
+		if distAmount > 0 {
+			returnAmounts[addresses[len(addresses)-1]] = distAmount
 		}
 	}
 
-	return "", true
+	return returnAmounts
 }
 
 func containsElement(collection []string, item string) bool {
@@ -2,13 +2,50 @@ package mixerlib
 
 import (
 	"errors"
-	"strconv"
 	"testing"
+	"time"
 
+	"github.com/ckaminer/jobcoin"
 	"github.com/ckaminer/jobcoin/clientlib"
 	"github.com/stretchr/testify/assert"
 )
 
+const testHouseAddress = "121212-house-address-121212"
+
+const testBankFund = "121212-bank-fund-121212"
+
+// newTestMixerLib returns a MixerLib using the default config without payout
+// delays, backed by an empty MemoryStore with testHouseAddress as its house address.
+func newTestMixerLib(jc clientlib.JobcoinClient) *MixerLib {
+	config := jobcoin.DefaultConfig().Mixer
+	config.BankFund = testBankFund
+	// Payouts are due as soon as they are scheduled.
+	config.PayoutMinDelay = 0
+	config.PayoutMaxDelay = 0
+
+	return &MixerLib{
+		JobcoinClient: jc,
+		Store:         NewMemoryStore(),
+		House: HouseAccount{
+			Address:  testHouseAddress,
+			BankFund: testBankFund,
+		},
+		Config: config,
+	}
+}
+
+// creditUser posts amount to the user's ledger account as if it had been
+// swept to testHouseAddress.
+func creditUser(ml *MixerLib, depositAddress string, amount clientlib.Amount) {
+	ml.postLedger(LedgerEntry{
+		Debit:          houseLedgerAccount(testHouseAddress),
+		Credit:         userLedgerAccount(depositAddress),
+		Amount:         amount,
+		DepositAddress: depositAddress,
+		Memo:           "sweep",
+	})
+}
+
 // Begin transferDepositToHouse tests
 func TestTransferDepositToHouse_ReturnsTrueIfBalanceSentToHouse(t *testing.T) {
 	user := MixerUser{
@@ -21,11 +58,11 @@ func TestTransferDepositToHouse_ReturnsTrueIfBalanceSentToHouse(t *testing.T) {
 	}
 
 	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "0.54",
+		Balance: clientlib.MustParseAmount("0.54"),
 	}
 	jobcoinMock := newJobcoinMock(mockAddressInfo, nil, nil)
 
-	ml := &MixerLib{jobcoinMock}
+	ml := newTestMixerLib(jobcoinMock)
 
 	sentToHouse, err := ml.transferDepositToHouse(user)
 	if err != nil {
@@ -35,6 +72,38 @@ func TestTransferDepositToHouse_ReturnsTrueIfBalanceSentToHouse(t *testing.T) {
 	assert.True(t, sentToHouse)
 }
 
+func TestTransferDepositToHouse_FeeAndHouseAmountSumToDeposit(t *testing.T) {
+	user := MixerUser{
+		DepositAddress: "1234abcd",
+	}
+
+	mockAddressInfo := clientlib.JobcoinAddressInfo{
+		Balance: clientlib.MustParseAmount("12.34567891"),
+	}
+	jobcoinMock := newJobcoinMock(mockAddressInfo, nil, nil)
+
+	ml := newTestMixerLib(jobcoinMock)
+
+	_, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedTxs := []clientlib.JobcoinTx{
+		{
+			FromAddress: user.DepositAddress,
+			ToAddress:   testBankFund,
+			Amount:      clientlib.MustParseAmount("0.12345678"),
+		},
+		{
+			FromAddress: user.DepositAddress,
+			ToAddress:   testHouseAddress,
+			Amount:      clientlib.MustParseAmount("12.22222213"),
+		},
+	}
+	assert.Equal(t, expectedTxs, jobcoinMock.(*mockJobcoinClient).Sent)
+}
+
 func TestTransferDepositToHouse_DoesNotCreateTransactionIfBalanceZero(t *testing.T) {
 	user := MixerUser{
 		DepositAddress: "1234abcd",
@@ -46,13 +115,13 @@ func TestTransferDepositToHouse_DoesNotCreateTransactionIfBalanceZero(t *testing
 	}
 
 	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "0",
+		Balance: clientlib.MustParseAmount("0"),
 	}
 	// Make SendJobcoin return error since this test should not be calling that function.
 	sendErr := errors.New("SendJobcoin failed")
 	jobcoinMock := newJobcoinMock(mockAddressInfo, nil, sendErr)
 
-	ml := &MixerLib{jobcoinMock}
+	ml := newTestMixerLib(jobcoinMock)
 
 	sentToHouse, err := ml.transferDepositToHouse(user)
 	if err != nil {
@@ -70,7 +139,7 @@ func TestTransferDepositToHouse_ReturnsErrorIfInfoRetrievalFails(t *testing.T) {
 	expectedErr := errors.New("GetAddressInfo failed")
 	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, expectedErr, nil)
 
-	ml := &MixerLib{jobcoinMock}
+	ml := newTestMixerLib(jobcoinMock)
 
 	sentToHouse, err := ml.transferDepositToHouse(user)
 	if err == nil {
@@ -87,12 +156,12 @@ func TestTransferDepositToHouse_ReturnsErrorIfSendJobcoinFails(t *testing.T) {
 	}
 
 	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "1",
+		Balance: clientlib.MustParseAmount("1"),
 	}
 	expectedErr := errors.New("SendJobcoin failed")
 	jobcoinMock := newJobcoinMock(mockAddressInfo, nil, expectedErr)
 
-	ml := &MixerLib{jobcoinMock}
+	ml := newTestMixerLib(jobcoinMock)
 
 	sentToHouse, err := ml.transferDepositToHouse(user)
 	if err == nil {
@@ -114,33 +183,19 @@ func TestReturnFundsToUser_ReturnsTrueIfFullBalanceReturned(t *testing.T) {
 		},
 	}
 
-	// Transactions from user's deposit address to house total up to less than return increment
-	// No funds have returned back to user yet
-	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "1000.045",
-		Transactions: []clientlib.JobcoinTx{
-			{
-				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "3.045",
-			},
-			{
-				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "1.324",
-			},
-		},
-	}
-
-	jc := newJobcoinMock(mockAddressInfo, nil, nil)
-	ml := MixerLib{jc}
+	jc := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil)
+	ml := newTestMixerLib(jc)
+	creditUser(ml, user.DepositAddress, clientlib.MustParseAmount("4.369"))
 
 	emptyBalance, err := ml.returnFundsToUser(user)
 	if err != nil {
 		t.Errorf("Did not expect error. Got: %s", err.Error())
 	}
 
+	balance, _ := ml.userHouseBalance(user.DepositAddress)
+
 	assert.True(t, emptyBalance)
+	assert.Equal(t, clientlib.Amount(0), balance)
 }
 
 func TestReturnFundsToUser_ReturnsFalseIfFullBalanceNotReturned(t *testing.T) {
@@ -152,41 +207,25 @@ func TestReturnFundsToUser_ReturnsFalseIfFullBalanceNotReturned(t *testing.T) {
 		},
 	}
 
-	// Transactions from user's deposit address to house total up to more than return increment
-	// Some funds have returned back to user yet
-	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "1000.045",
-		Transactions: []clientlib.JobcoinTx{
-			{
-				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "3.045",
-			},
-			{
-				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "14.324",
-			},
-			{
-				FromAddress: HouseAddress,
-				ToAddress:   user.ReturnAddresses[0],
-				Amount:      "2.8623",
-			},
-		},
-	}
-
-	jc := newJobcoinMock(mockAddressInfo, nil, nil)
-	ml := MixerLib{jc}
+	jc := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil)
+	ml := newTestMixerLib(jc)
+	creditUser(ml, user.DepositAddress, clientlib.MustParseAmount("14.5067"))
+	// Payouts are spread over the next hour so they are not all due yet.
+	ml.Config.PayoutMinDelay = jobcoin.Duration(time.Minute)
+	ml.Config.PayoutMaxDelay = jobcoin.Duration(time.Hour)
 
 	emptyBalance, err := ml.returnFundsToUser(user)
 	if err != nil {
 		t.Errorf("Did not expect error. Got: %s", err.Error())
 	}
 
+	payouts, _ := ml.Store.Payouts(user.DepositAddress)
+
 	assert.False(t, emptyBalance)
+	assert.NotEqual(t, 0, len(payouts))
 }
 
-func TestReturnFundsToUser_ErrorsIfUnableToRetrieveUserInfo(t *testing.T) {
+func TestReturnFundsToUser_DoesNotLookUpHouseHistory(t *testing.T) {
 	user := MixerUser{
 		DepositAddress: "1111aaaa",
 		ReturnAddresses: []string{
@@ -195,15 +234,16 @@ func TestReturnFundsToUser_ErrorsIfUnableToRetrieveUserInfo(t *testing.T) {
 		},
 	}
 
-	userError := errors.New("Unable to retrieve user info")
-
-	jc := newJobcoinMock(clientlib.JobcoinAddressInfo{}, userError, nil)
-	ml := MixerLib{jc}
+	jc := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jc)
+	creditUser(ml, user.DepositAddress, 3*clientlib.Coin)
 
 	_, err := ml.returnFundsToUser(user)
-	if err == nil {
-		t.Errorf("Expectd error but did not receive one.")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
 	}
+
+	assert.Equal(t, 0, jc.Lookups)
 }
 
 func TestReturnFundsToUser_ReturnsFalseIfFailsToCreateTransaction(t *testing.T) {
@@ -215,36 +255,22 @@ func TestReturnFundsToUser_ReturnsFalseIfFailsToCreateTransaction(t *testing.T)
 		},
 	}
 
-	// Transactions from user's deposit address to house total up to less than return increment
-	// No funds have returned back to user yet
 	// With no transaction failures, this would typically cause true to be returned
-	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "1000.045",
-		Transactions: []clientlib.JobcoinTx{
-			{
-				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "3.045",
-			},
-			{
-				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "1.324",
-			},
-		},
-	}
-
 	sendError := errors.New("Unable to send Jobcoin")
 
-	jc := newJobcoinMock(mockAddressInfo, nil, sendError)
-	ml := MixerLib{jc}
+	jc := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, sendError)
+	ml := newTestMixerLib(jc)
+	creditUser(ml, user.DepositAddress, clientlib.MustParseAmount("4.369"))
 
 	emptyBalance, err := ml.returnFundsToUser(user)
 	if err != nil {
 		t.Errorf("Did not expect error. Got: %s", err.Error())
 	}
 
+	balance, _ := ml.userHouseBalance(user.DepositAddress)
+
 	assert.False(t, emptyBalance)
+	assert.Equal(t, clientlib.MustParseAmount("4.369"), balance)
 }
 
 // Begin calculateHouseBalanceForUser tests
@@ -259,40 +285,40 @@ func TestCalculateHouseBalanceForUser_ReturnsDiffOfHouseDepositAndReturns(t *tes
 	}
 
 	houseInfo := clientlib.JobcoinAddressInfo{
-		Balance: "168.37",
+		Balance: clientlib.MustParseAmount("168.37"),
 		Transactions: []clientlib.JobcoinTx{
 			{
 				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "10.79485481",
+				ToAddress:   testHouseAddress,
+				Amount:      clientlib.MustParseAmount("10.79485481"),
 			},
 			{
 				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "5.23684368",
+				ToAddress:   testHouseAddress,
+				Amount:      clientlib.MustParseAmount("5.23684368"),
 			},
 			{
-				FromAddress: HouseAddress,
+				FromAddress: testHouseAddress,
 				ToAddress:   user.ReturnAddresses[0],
-				Amount:      "1.96342871",
+				Amount:      clientlib.MustParseAmount("1.96342871"),
 			},
 			{
-				FromAddress: HouseAddress,
+				FromAddress: testHouseAddress,
 				ToAddress:   user.ReturnAddresses[1],
-				Amount:      "3.56385431",
+				Amount:      clientlib.MustParseAmount("3.56385431"),
 			},
 			{
-				FromAddress: HouseAddress,
+				FromAddress: testHouseAddress,
 				ToAddress:   user.ReturnAddresses[2],
-				Amount:      "2.99763821",
+				Amount:      clientlib.MustParseAmount("2.99763821"),
 			},
 		},
 	}
 
 	jobcoinMock := newJobcoinMock(houseInfo, nil, nil)
-	ml := &MixerLib{jobcoinMock}
+	ml := newTestMixerLib(jobcoinMock)
 
-	expectedBalance := 7.50677726
+	expectedBalance := clientlib.MustParseAmount("7.50677726")
 	actualBalance, err := ml.calculateHouseBalanceForUser(user)
 	if err != nil {
 		t.Errorf("Did not expect error. Got: %s", err.Error())
@@ -312,35 +338,83 @@ func TestCalculateHouseBalanceForUser_ReturnsDiff_Zero(t *testing.T) {
 	}
 
 	houseInfo := clientlib.JobcoinAddressInfo{
-		Balance: "168.37",
+		Balance: clientlib.MustParseAmount("168.37"),
 		Transactions: []clientlib.JobcoinTx{
 			{
 				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "9.97",
+				ToAddress:   testHouseAddress,
+				Amount:      clientlib.MustParseAmount("9.97"),
 			},
 			{
-				FromAddress: HouseAddress,
+				FromAddress: testHouseAddress,
 				ToAddress:   user.ReturnAddresses[0],
-				Amount:      "3.324",
+				Amount:      clientlib.MustParseAmount("3.324"),
 			},
 			{
-				FromAddress: HouseAddress,
+				FromAddress: testHouseAddress,
 				ToAddress:   user.ReturnAddresses[1],
-				Amount:      "3.323",
+				Amount:      clientlib.MustParseAmount("3.323"),
 			},
 			{
-				FromAddress: HouseAddress,
+				FromAddress: testHouseAddress,
 				ToAddress:   user.ReturnAddresses[2],
-				Amount:      "3.323",
+				Amount:      clientlib.MustParseAmount("3.323"),
 			},
 		},
 	}
 
 	jobcoinMock := newJobcoinMock(houseInfo, nil, nil)
-	ml := &MixerLib{jobcoinMock}
+	ml := newTestMixerLib(jobcoinMock)
 
-	expectedBalance := 0.0
+	expectedBalance := clientlib.Amount(0)
+	actualBalance, err := ml.calculateHouseBalanceForUser(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, expectedBalance, actualBalance)
+}
+
+func TestCalculateHouseBalanceForUser_IncludesPreviousHouseAddresses(t *testing.T) {
+	user := MixerUser{
+		DepositAddress: "user-deposit-address",
+		ReturnAddresses: []string{
+			"return-address-1",
+		},
+	}
+
+	houseInfo := clientlib.JobcoinAddressInfo{
+		Transactions: []clientlib.JobcoinTx{
+			{
+				FromAddress: user.DepositAddress,
+				ToAddress:   "previous-house-address",
+				Amount:      clientlib.MustParseAmount("10"),
+			},
+			{
+				FromAddress: "previous-house-address",
+				ToAddress:   user.ReturnAddresses[0],
+				Amount:      clientlib.MustParseAmount("2"),
+			},
+			{
+				FromAddress: "previous-house-address",
+				ToAddress:   testHouseAddress,
+				Amount:      clientlib.MustParseAmount("8"),
+			},
+			{
+				FromAddress: testHouseAddress,
+				ToAddress:   user.ReturnAddresses[0],
+				Amount:      clientlib.MustParseAmount("3"),
+			},
+		},
+	}
+
+	jobcoinMock := newJobcoinMock(houseInfo, nil, nil)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Rotations = []HouseRotation{
+		{PreviousAddress: "previous-house-address", NewAddress: testHouseAddress, Migrated: true},
+	}
+
+	expectedBalance := 5 * clientlib.Coin
 	actualBalance, err := ml.calculateHouseBalanceForUser(user)
 	if err != nil {
 		t.Errorf("Did not expect error. Got: %s", err.Error())
@@ -361,7 +435,7 @@ func TestCalculateHouseBalanceForUser_ReturnsErrorIfUnableToRetrieveInfo(t *test
 
 	expectedErr := errors.New("Failed to get address info")
 	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, expectedErr, nil)
-	ml := &MixerLib{jobcoinMock}
+	ml := newTestMixerLib(jobcoinMock)
 
 	_, err := ml.calculateHouseBalanceForUser(user)
 	if err == nil {
@@ -378,15 +452,35 @@ func TestAssignReturnAmounts_ReturnsMapOfAddressToRandomReturnAmounts(t *testing
 		"2222bbbb",
 		"3333cccc",
 	}
-	distAmount := 5.0
+	distAmount := 5 * clientlib.Coin
 
-	ml := &MixerLib{}
+	ml := newTestMixerLib(nil)
 	returnAmounts := ml.assignReturnAmounts(addresses, distAmount)
 
 	for _, returnAmount := range returnAmounts {
-		amount, _ := strconv.ParseFloat(returnAmount, 64)
-		assert.GreaterOrEqual(t, amount, 0.01)
-		assert.LessOrEqual(t, amount, distAmount)
+		assert.GreaterOrEqual(t, int64(returnAmount), int64(clientlib.Coin/100))
+		assert.LessOrEqual(t, int64(returnAmount), int64(distAmount))
+	}
+}
+
+func TestAssignReturnAmounts_AmountsSumExactlyToDistAmount(t *testing.T) {
+	addresses := []string{
+		"1111aaaa",
+		"2222bbbb",
+		"3333cccc",
+		"4444dddd",
+	}
+	distAmount := clientlib.MustParseAmount("4.99999999")
+
+	ml := newTestMixerLib(nil)
+	for i := 0; i < 100; i++ {
+		returnAmounts := ml.assignReturnAmounts(addresses, distAmount)
+
+		var total clientlib.Amount
+		for _, returnAmount := range returnAmounts {
+			total = total + returnAmount
+		}
+		assert.Equal(t, distAmount, total)
 	}
 }
 
@@ -394,13 +488,13 @@ func TestAssignReturnAmounts_DistributesEntireAmountIfOneAddress(t *testing.T) {
 	addresses := []string{
 		"1111aaaa",
 	}
-	distAmount := 5.0
+	distAmount := 5 * clientlib.Coin
 
-	expectedReturns := map[string]string{
-		"1111aaaa": "5",
+	expectedReturns := map[string]clientlib.Amount{
+		"1111aaaa": 5 * clientlib.Coin,
 	}
 
-	ml := &MixerLib{}
+	ml := newTestMixerLib(nil)
 	returnAmounts := ml.assignReturnAmounts(addresses, distAmount)
 
 	assert.Equal(t, expectedReturns, returnAmounts)
@@ -408,66 +502,16 @@ func TestAssignReturnAmounts_DistributesEntireAmountIfOneAddress(t *testing.T) {
 
 func TestAssignReturnAmounts_ReturnsEmptyMapIfNoAddress(t *testing.T) {
 	addresses := []string{}
-	distAmount := 5.0
+	distAmount := 5 * clientlib.Coin
 
-	expectedReturns := map[string]string{}
+	expectedReturns := map[string]clientlib.Amount{}
 
-	ml := &MixerLib{}
+	ml := newTestMixerLib(nil)
 	returnAmounts := ml.assignReturnAmounts(addresses, distAmount)
 
 	assert.Equal(t, expectedReturns, returnAmounts)
 }
 
-// Begin ValidUserAddresses tests
-func TestValidUserAddresses_ReturnsTrueIfAllUniqueAddresses(t *testing.T) {
-	userOne := MixerUser{
-		ReturnAddresses: []string{
-			"return-one",
-			"return-two",
-		},
-	}
-	userTwo := MixerUser{
-		ReturnAddresses: []string{
-			"return-three",
-			"return-four",
-			"return-five",
-		},
-	}
-
-	MixerUsers = []MixerUser{userOne, userTwo}
-
-	newAddresses := []string{"return-six", "return-seven"}
-
-	_, valid := ValidUserAddresses(newAddresses)
-
-	assert.True(t, valid)
-}
-
-func TestValidUserAddresses_ReturnsFalseAndCulpritIfNotUnique(t *testing.T) {
-	userOne := MixerUser{
-		ReturnAddresses: []string{
-			"return-one",
-			"return-two",
-		},
-	}
-	userTwo := MixerUser{
-		ReturnAddresses: []string{
-			"return-three",
-			"return-four",
-			"return-five",
-		},
-	}
-
-	MixerUsers = []MixerUser{userOne, userTwo}
-
-	newAddresses := []string{"return-six", "return-five"}
-
-	badAddress, valid := ValidUserAddresses(newAddresses)
-
-	assert.False(t, valid)
-	assert.Equal(t, "return-five", badAddress)
-}
-
 // Begin containsElement tests
 func TestContainsElement_ReturnsTrueIfElementInSlice(t *testing.T) {
 	collection := []string{"1111aaaa", "2222bbbb", "3333cccc"}
@@ -0,0 +1,109 @@
+package mixerlib
+
+import (
+	"fmt"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// admitDeposit decides what to do with a balance found in the user's deposit
+// address. A deposit within the deposit limits is swept less the service fee.
+// Otherwise it is dealt with following Config.OutOfRangePolicy: held in the
+// deposit address, refunded to the user's refund address, or swept less
+// Config.OutOfRangeFee. It returns the fee to sweep the deposit with, and
+// false if the deposit should not be swept.
+func (ml *MixerLib) admitDeposit(user MixerUser, balance clientlib.Amount) (clientlib.Amount, bool, error) {
+	progress, err := ml.Store.Progress(user.DepositAddress)
+	if err != nil {
+		return 0, false, err
+	}
+
+	reason := ml.outOfRange(progress, balance)
+	if reason == "" {
+		// The fee is rounded down and the house gets the rest, so the
+		// two always add up to exactly the deposit.
+		return balance.BasisPoints(ml.Config.ServiceFeeBasisPoints), true, nil
+	}
+
+	switch ml.Config.OutOfRangePolicy {
+	case jobcoin.RefundDeposits:
+		if user.RefundAddress != "" {
+			return 0, false, ml.refundDeposit(user, balance, reason)
+		}
+	case jobcoin.FlatFeeDeposits:
+		if balance > ml.Config.OutOfRangeFee {
+			ml.userLogger(user.DepositAddress).Info("Charging flat fee for deposit outside the deposit limits", "amount", balance, "fee", ml.Config.OutOfRangeFee, "reason", reason)
+			return ml.Config.OutOfRangeFee, true, nil
+		}
+	}
+	return 0, false, ml.holdDeposit(user.DepositAddress, progress, balance, reason)
+}
+
+// outOfRange returns why a deposit of amount is outside the deposit limits
+// for a user with the given progress, or "" if it is within them.
+func (ml *MixerLib) outOfRange(progress DistributionProgress, amount clientlib.Amount) string {
+	switch {
+	case ml.Config.MinDeposit > 0 && amount < ml.Config.MinDeposit:
+		return fmt.Sprintf("Deposit is below the minimum of %s Jobcoin", ml.Config.MinDeposit)
+	case ml.Config.MaxDeposit > 0 && amount > ml.Config.MaxDeposit:
+		return fmt.Sprintf("Deposit is above the maximum of %s Jobcoin", ml.Config.MaxDeposit)
+	case ml.Config.UserDepositLimit > 0 && progress.Deposited+amount > ml.Config.UserDepositLimit:
+		return fmt.Sprintf("Deposit would take the user over their limit of %s Jobcoin", ml.Config.UserDepositLimit)
+	}
+	return ""
+}
+
+// depositLimits returns the limits on further deposits by a user with the
+// given progress.
+func (ml *MixerLib) depositLimits(progress DistributionProgress) DepositLimits {
+	limits := DepositLimits{Min: ml.Config.MinDeposit, Max: ml.Config.MaxDeposit}
+	if ml.Config.UserDepositLimit > 0 {
+		remaining := ml.Config.UserDepositLimit - progress.Deposited
+		if remaining < 0 {
+			remaining = 0
+		}
+		limits.Remaining = &remaining
+	}
+	return limits
+}
+
+// holdDeposit records that amount is being held in the user's deposit
+// address. Nothing is recorded or published if it was already being held for
+// the same reason, so a held deposit is only reported once.
+func (ml *MixerLib) holdDeposit(depositAddress string, progress DistributionProgress, amount clientlib.Amount, reason string) error {
+	if progress.Held == amount && progress.HoldReason == reason {
+		return nil
+	}
+
+	ml.userLogger(depositAddress).Warn("Holding deposit outside the deposit limits", "amount", amount, "reason", reason)
+	_, err := ml.Store.UpdateProgress(depositAddress, func(p *DistributionProgress) {
+		p.Held = amount
+		p.HoldReason = reason
+	})
+	if err != nil {
+		return err
+	}
+	ml.publish(Event{Kind: EventDepositHeld, DepositAddress: depositAddress, Amount: amount, Message: reason})
+	return nil
+}
+
+// refundDeposit sends amount from the user's deposit address to their refund
+// address. A refund that fails is tried again on the next poll. The
+// transaction is not retried on a network error or 5xx response, so it is
+// never sent twice, but one sent despite such an error is not recorded.
+func (ml *MixerLib) refundDeposit(user MixerUser, amount clientlib.Amount, reason string) error {
+	err := ml.JobcoinClient.SendJobcoin(user.DepositAddress, user.RefundAddress, amount)
+	if err != nil {
+		return err
+	}
+
+	ml.userLogger(user.DepositAddress).Info("Refunded deposit outside the deposit limits", "amount", amount, "refundAddress", user.RefundAddress, "reason", reason)
+	ml.recordProgress(user.DepositAddress, func(p *DistributionProgress) {
+		p.Refunded = p.Refunded + amount
+		p.Held = 0
+		p.HoldReason = ""
+	})
+	ml.publish(Event{Kind: EventRefunded, DepositAddress: user.DepositAddress, Amount: amount, ToAddress: user.RefundAddress, Message: reason})
+	return nil
+}
@@ -0,0 +1,141 @@
+package mixerlib
+
+import (
+	"testing"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin deposit limits tests
+func TestTransferDepositToHouse_HoldsDepositBelowMinimumOnce(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: clientlib.MustParseAmount("0.001")}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Events = NewEvents()
+	events, unsubscribe := ml.Events.Subscribe(user.DepositAddress)
+	defer unsubscribe()
+
+	for i := 0; i < 2; i++ {
+		sentToHouse, err := ml.transferDepositToHouse(user)
+		if err != nil {
+			t.Errorf("Did not expect error. Got: %s", err.Error())
+		}
+		assert.False(t, sentToHouse)
+	}
+
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+	assert.Empty(t, jobcoinMock.Sent)
+	assert.Equal(t, clientlib.MustParseAmount("0.001"), progress.Held)
+	assert.Equal(t, "Deposit is below the minimum of 0.01 Jobcoin", progress.HoldReason)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, EventDepositHeld, (<-events).Kind)
+}
+
+func TestTransferDepositToHouse_SweepsHeldDepositOnceWithinLimits(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: clientlib.MustParseAmount("0.001")}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.transferDepositToHouse(user)
+
+	jobcoinMock.AddressInfo.Balance = clientlib.Coin
+	sentToHouse, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+	assert.True(t, sentToHouse)
+	assert.Equal(t, clientlib.Amount(0), progress.Held)
+	assert.Equal(t, "", progress.HoldReason)
+	assert.Equal(t, clientlib.Coin, progress.Deposited)
+}
+
+func TestTransferDepositToHouse_RefundsDepositAboveMaximum(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd", RefundAddress: "9999zzzz"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: 50 * clientlib.Coin}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Config.MaxDeposit = 20 * clientlib.Coin
+	ml.Config.OutOfRangePolicy = jobcoin.RefundDeposits
+
+	sentToHouse, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+	assert.False(t, sentToHouse)
+	assert.Equal(t, []clientlib.JobcoinTx{{FromAddress: "1234abcd", ToAddress: "9999zzzz", Amount: 50 * clientlib.Coin}}, jobcoinMock.Sent)
+	assert.Equal(t, 50*clientlib.Coin, progress.Refunded)
+	assert.Equal(t, clientlib.Amount(0), progress.Held)
+}
+
+func TestTransferDepositToHouse_HoldsDepositIfUserHasNoRefundAddress(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: 50 * clientlib.Coin}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Config.MaxDeposit = 20 * clientlib.Coin
+	ml.Config.OutOfRangePolicy = jobcoin.RefundDeposits
+
+	ml.transferDepositToHouse(user)
+
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+	assert.Empty(t, jobcoinMock.Sent)
+	assert.Equal(t, 50*clientlib.Coin, progress.Held)
+}
+
+func TestTransferDepositToHouse_ChargesFlatFeeForDepositOverUserLimit(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: 10 * clientlib.Coin}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Config.UserDepositLimit = 15 * clientlib.Coin
+	ml.Config.OutOfRangePolicy = jobcoin.FlatFeeDeposits
+	ml.recordProgress(user.DepositAddress, func(p *DistributionProgress) {
+		p.Deposited = 10 * clientlib.Coin
+	})
+
+	sentToHouse, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.True(t, sentToHouse)
+	assert.Equal(t, ml.Config.OutOfRangeFee, jobcoinMock.Sent[0].Amount)
+	assert.Equal(t, 10*clientlib.Coin-ml.Config.OutOfRangeFee, jobcoinMock.Sent[1].Amount)
+}
+
+func TestTransferDepositToHouse_HoldsDepositNoLargerThanFlatFee(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: clientlib.MustParseAmount("0.005")}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Config.OutOfRangePolicy = jobcoin.FlatFeeDeposits
+
+	ml.transferDepositToHouse(user)
+
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+	assert.Empty(t, jobcoinMock.Sent)
+	assert.Equal(t, clientlib.MustParseAmount("0.005"), progress.Held)
+}
+
+func TestUserStatus_ReportsHeldDepositAndRemainingLimit(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: 10 * clientlib.Coin}, nil, nil)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Config.UserDepositLimit = 15 * clientlib.Coin
+	ml.Store.AddUser(user)
+	ml.recordProgress(user.DepositAddress, func(p *DistributionProgress) {
+		p.Deposited = 10 * clientlib.Coin
+	})
+	ml.transferDepositToHouse(user)
+
+	status, err := ml.UserStatus(user.DepositAddress)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	remaining := 5 * clientlib.Coin
+	assert.Equal(t, 10*clientlib.Coin, status.Held)
+	assert.Equal(t, "Deposit would take the user over their limit of 15 Jobcoin", status.HoldReason)
+	assert.Equal(t, DepositLimits{Min: ml.Config.MinDeposit, Remaining: &remaining}, status.Limits)
+}
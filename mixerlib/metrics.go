@@ -0,0 +1,155 @@
+package mixerlib
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the counters and gauges recorded as the mixer moves funds. A
+// nil *Metrics records nothing, so MixerLib works without one.
+type Metrics struct {
+	DepositsDetected      prometheus.Counter
+	Swept                 prometheus.Counter
+	FeesCollected         prometheus.Counter
+	PayoutsSent           prometheus.Counter
+	PayoutsFailed         prometheus.Counter
+	PaidOut               prometheus.Counter
+	Discrepancies         prometheus.Gauge
+	PollDuration          *prometheus.HistogramVec
+	DepositChecksDeferred prometheus.Counter
+}
+
+// NewMetrics registers the mixer's metrics with registerer. Gauges describing
+// the stored state, such as the number of users and the length of the house
+// queue, are read from store each time the metrics are served, and failures
+// to read them are logged to logger, or to slog.Default() if it is nil. An
+// error is returned if the metrics cannot be registered, for example because
+// metrics with the same names already are.
+func NewMetrics(registerer prometheus.Registerer, store Store, logger *slog.Logger) (*Metrics, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	m := &Metrics{
+		DepositsDetected: newCounter("mixer_deposits_detected_total", "Deposits found in deposit addresses and swept to the house."),
+		Swept:            newCounter("mixer_swept_jobcoin_total", "Jobcoin swept from deposit addresses to the house, after fees."),
+		FeesCollected:    newCounter("mixer_fees_collected_jobcoin_total", "Jobcoin sent from deposit addresses to the bank fund as fees."),
+		PayoutsSent:      newCounter("mixer_payouts_sent_total", "Payouts sent from the house to return addresses."),
+		PayoutsFailed:    newCounter("mixer_payouts_failed_total", "Payouts that failed to send and were put back on the schedule."),
+		PaidOut:          newCounter("mixer_paid_out_jobcoin_total", "Jobcoin returned from the house to return addresses."),
+		Discrepancies: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mixer_reconciliation_discrepancies",
+			Help: "Discrepancies found by the last reconciliation with Jobcoin.",
+		}),
+		PollDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mixer_poll_duration_seconds",
+			Help:    "How long each pass of a poll loop took, by loop.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"loop"}),
+		DepositChecksDeferred: newCounter("mixer_deposit_checks_deferred_total", "Deposit address checks left for the next poll because a poll ran out of time."),
+	}
+
+	collectors := []prometheus.Collector{
+		m.DepositsDetected, m.Swept, m.FeesCollected, m.PayoutsSent, m.PayoutsFailed,
+		m.PaidOut, m.Discrepancies, m.PollDuration, m.DepositChecksDeferred,
+		newGaugeFunc("mixer_users_registered", "Users registered with the mixer.", func() float64 {
+			users, err := store.Users()
+			if err != nil {
+				logger.Error("Failed to load users for metrics", "error", err)
+			}
+			archived, err := store.ArchivedUsers()
+			if err != nil {
+				logger.Error("Failed to load archived users for metrics", "error", err)
+			}
+			return float64(len(users) + len(archived))
+		}),
+		newGaugeFunc("mixer_house_queue_length", "Users whose funds are in the house waiting to be returned.", func() float64 {
+			houseQueue, err := store.HouseQueue()
+			if err != nil {
+				logger.Error("Failed to load house queue for metrics", "error", err)
+			}
+			return float64(len(houseQueue))
+		}),
+		newGaugeFunc("mixer_house_balance_jobcoin", "Jobcoin held by the house addresses according to the ledger.", func() float64 {
+			balances, err := store.LedgerBalances()
+			if err != nil {
+				logger.Error("Failed to load ledger balances for metrics", "error", err)
+			}
+			var total clientlib.Amount
+			for account, balance := range balances {
+				if strings.HasPrefix(account, houseLedgerAccount("")) {
+					total = total + balance
+				}
+			}
+			return total.Float64()
+		}),
+	}
+	for _, collector := range collectors {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func newCounter(name, help string) prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{Name: name, Help: help})
+}
+
+func newGaugeFunc(name, help string, value func() float64) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, value)
+}
+
+func (m *Metrics) depositDetected() {
+	if m != nil {
+		m.DepositsDetected.Inc()
+	}
+}
+
+func (m *Metrics) feeCollected(fee clientlib.Amount) {
+	if m != nil {
+		m.FeesCollected.Add(fee.Float64())
+	}
+}
+
+func (m *Metrics) swept(houseAmount clientlib.Amount) {
+	if m != nil {
+		m.Swept.Add(houseAmount.Float64())
+	}
+}
+
+func (m *Metrics) payoutSent(amount clientlib.Amount) {
+	if m != nil {
+		m.PayoutsSent.Inc()
+		m.PaidOut.Add(amount.Float64())
+	}
+}
+
+func (m *Metrics) payoutFailed() {
+	if m != nil {
+		m.PayoutsFailed.Inc()
+	}
+}
+
+func (m *Metrics) reconciled(report ReconciliationReport) {
+	if m != nil {
+		m.Discrepancies.Set(float64(len(report.Discrepancies)))
+	}
+}
+
+func (m *Metrics) depositChecksDeferred(count int) {
+	if m != nil {
+		m.DepositChecksDeferred.Add(float64(count))
+	}
+}
+
+func (m *Metrics) pollFinished(loop string, start time.Time) {
+	if m != nil {
+		m.PollDuration.WithLabelValues(loop).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,129 @@
+package mixerlib
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestMetrics returns Metrics reading from store, registered with a new registry.
+func newTestMetrics(t *testing.T, store Store) *Metrics {
+	m, err := NewMetrics(prometheus.NewRegistry(), store, nil)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	return m
+}
+
+// Begin Metrics tests
+func TestTransferDepositToHouse_RecordsSweepMetrics(t *testing.T) {
+	mockAddressInfo := clientlib.JobcoinAddressInfo{
+		Balance: 10 * clientlib.Coin,
+	}
+	ml := newTestMixerLib(newJobcoinMock(mockAddressInfo, nil, nil))
+	ml.Metrics = newTestMetrics(t, ml.Store)
+
+	_, err := ml.transferDepositToHouse(MixerUser{DepositAddress: "1234abcd"})
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(ml.Metrics.DepositsDetected))
+	assert.Equal(t, 9.9, testutil.ToFloat64(ml.Metrics.Swept))
+	assert.Equal(t, 0.1, testutil.ToFloat64(ml.Metrics.FeesCollected))
+}
+
+func TestSendDuePayouts_RecordsPayoutMetrics(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Metrics = newTestMetrics(t, ml.Store)
+	creditUser(ml, user.DepositAddress, 3*clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.MustParseAmount("1.5"), DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(ml.Metrics.PayoutsSent))
+	assert.Equal(t, 1.5, testutil.ToFloat64(ml.Metrics.PaidOut))
+	assert.Equal(t, float64(0), testutil.ToFloat64(ml.Metrics.PayoutsFailed))
+}
+
+func TestSendDuePayouts_RecordsFailedPayouts(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, errors.New("SendJobcoin failed")))
+	ml.Metrics = newTestMetrics(t, ml.Store)
+	creditUser(ml, user.DepositAddress, 3*clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(ml.Metrics.PayoutsSent))
+	assert.Equal(t, float64(1), testutil.ToFloat64(ml.Metrics.PayoutsFailed))
+}
+
+func TestNewMetrics_ReadsGaugesFromStore(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	registry := prometheus.NewRegistry()
+	NewMetrics(registry, ml.Store, nil)
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml.Store.AddUser(user)
+	ml.Store.AddToHouseQueue(user)
+	creditUser(ml, user.DepositAddress, clientlib.MustParseAmount("2.5"))
+
+	expected := `
+# HELP mixer_users_registered Users registered with the mixer.
+# TYPE mixer_users_registered gauge
+mixer_users_registered 1
+# HELP mixer_house_queue_length Users whose funds are in the house waiting to be returned.
+# TYPE mixer_house_queue_length gauge
+mixer_house_queue_length 1
+# HELP mixer_house_balance_jobcoin Jobcoin held by the house addresses according to the ledger.
+# TYPE mixer_house_balance_jobcoin gauge
+mixer_house_balance_jobcoin 2.5
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"mixer_users_registered", "mixer_house_queue_length", "mixer_house_balance_jobcoin")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+}
+
+func TestNewMetrics_LogsGaugesThatCannotBeReadToLogger(t *testing.T) {
+	var out bytes.Buffer
+	registry := prometheus.NewRegistry()
+	NewMetrics(registry, &unreadableStore{Store: NewMemoryStore()}, slog.New(slog.NewJSONHandler(&out, nil)))
+
+	testutil.GatherAndCount(registry, "mixer_users_registered", "mixer_house_queue_length")
+
+	assert.Contains(t, out.String(), "Failed to load users for metrics")
+	assert.Contains(t, out.String(), "Failed to load house queue for metrics")
+}
+
+func TestNewMetrics_ReturnsErrorIfMetricsAlreadyRegistered(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	store := NewMemoryStore()
+	NewMetrics(registry, store, nil)
+
+	_, err := NewMetrics(registry, store, nil)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
+
+func TestSendDuePayouts_WorksWithoutMetrics(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	creditUser(ml, user.DepositAddress, clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	assert.NotPanics(t, func() {
+		ml.sendDuePayouts(user, []ScheduledPayout{due})
+	})
+}
@@ -1,60 +1,232 @@
 package mixerlib
 
 import (
-	"log"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
 )
 
-// PollForNewDeposits is an endlessly looping function handling the input of new users.
-func (ml *MixerLib) PollForNewDeposits(ticker *time.Ticker, userChan, houseChan chan MixerUser) {
-	for {
-		ml.processMixerUsers(ticker, userChan, houseChan)
+// PollForNewDeposits is a looping function checking registered users for new deposits.
+// It returns once ctx is cancelled, after finishing any transfers already in progress.
+func (ml *MixerLib) PollForNewDeposits(ctx context.Context, ticker *time.Ticker) {
+	ml.health.pollerStarted(DepositPollerComponent)
+	for ctx.Err() == nil {
+		ml.processMixerUsers(ctx, ticker)
 	}
+	ml.health.pollerStopped(DepositPollerComponent)
+	ml.logger().Info("Stopped polling for new deposits")
 }
 
 // processMixerUsers gets called inside PollForNewDeposits.
-// When a user comes in through the provided user channel they are added to MixerUsers.
-// On a steady time interval each MixerUser is passed to transferDepositToHouse to
-// potentially move funds if necessary.
-func (ml *MixerLib) processMixerUsers(ticker *time.Ticker, userChan, houseChan chan MixerUser) {
+// On a steady time interval each registered user is passed to checkDeposit,
+// DepositPollWorkers users at a time. Every user should be started on before
+// the next tick; any that are not are left for the next tick, which starts
+// with them, so a slow poll never delays the next one or starves the users
+// at the end of the list. Archived users are checked for late deposits after
+// every other user, on the polls that run the expired sweep. No further users
+// are started on once ctx is cancelled or the Jobcoin API circuit breaker opens.
+func (ml *MixerLib) processMixerUsers(ctx context.Context, ticker *time.Ticker) {
+	var now time.Time
 	select {
-	case <-ticker.C:
-		for _, user := range MixerUsers {
-			sentToHouse, _ := ml.transferDepositToHouse(user)
-			if sentToHouse {
-				houseChan <- user
-			}
+	case <-ctx.Done():
+		return
+	case now = <-ticker.C:
+	}
+	defer ml.Metrics.pollFinished("deposits", time.Now())
+
+	users, err := ml.Store.Users()
+	if err != nil {
+		ml.logger().Error("Failed to load users", "error", err)
+		return
+	}
+	defer ml.health.succeeded(DepositPollerComponent)
+	sweepExpired := ml.expiredSweepDue(now)
+	var archived []ArchivedUser
+	if sweepExpired {
+		archived, err = ml.Store.ArchivedUsers()
+		if err != nil {
+			ml.logger().Error("Failed to load archived users", "error", err)
 		}
-	case newUser := <-userChan:
-		log.Printf("Adding user %s to MixerUsers", newUser.DepositAddress)
-		MixerUsers = append(MixerUsers, newUser)
+	}
+	total := len(users) + len(archived)
+	if total == 0 {
+		return
+	}
+
+	tickCtx, cancel := context.WithDeadline(ctx, now.Add(ml.Config.DepositPollInterval.Std()))
+	defer cancel()
+	var paused sync.Once
+	pause := func(err error) {
+		paused.Do(func() {
+			ml.logger().Warn("Pausing deposit polling", "error", err)
+			cancel()
+		})
+	}
+
+	workerCount := ml.Config.DepositPollWorkers
+	if workerCount < 1 {
+		workerCount = 1
+	}
+	first := 0
+	if len(users) > 0 {
+		first = ml.nextDepositUser % len(users)
+	}
+	var next atomic.Int64
+	var workers sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for tickCtx.Err() == nil {
+				index := int(next.Add(1)) - 1
+				switch {
+				case index < len(users):
+					ml.checkDeposit(users[(first+index)%len(users)], now, sweepExpired, pause)
+				case index < total:
+					ml.checkArchivedDeposit(archived[index-len(users)].User, now, pause)
+				default:
+					return
+				}
+			}
+		}()
+	}
+	workers.Wait()
+
+	started := int(next.Load())
+	if started > total {
+		started = total
+	}
+	if started < len(users) {
+		ml.nextDepositUser = (first + started) % len(users)
+	}
+	deferred := total - started
+	if deferred > 0 && errors.Is(tickCtx.Err(), context.DeadlineExceeded) {
+		ml.logger().Warn("Deposit poll ran out of time, the remaining users will be checked first next time", "checked", started, "deferred", deferred)
+		ml.Metrics.depositChecksDeferred(deferred)
 	}
 }
 
-// PollForUserReturns is an endlessly looping function handling the redistribution of money.
-func (ml *MixerLib) PollForUserReturns(ticker *time.Ticker, houseChan chan MixerUser) {
-	for {
-		ml.processHouseUsers(ticker, houseChan)
+// checkDeposit passes the user to transferDepositToHouse and adds them to the
+// house queue once their funds have reached the house. Expired and finished
+// users are only checked once every ExpiredSweepInterval, see pollsFrequently,
+// and are archived once ArchiveAfter has passed with nothing left to do for
+// them. pause is called if the Jobcoin API circuit breaker is open.
+func (ml *MixerLib) checkDeposit(user MixerUser, now time.Time, sweepExpired bool, pause func(error)) {
+	frequent, err := ml.pollsFrequently(user, now)
+	if err != nil {
+		ml.userLogger(user.DepositAddress).Error("Failed to check whether deposit address has expired", "error", err)
+	}
+	if !frequent && !sweepExpired {
+		return
+	}
+
+	sentToHouse, err := ml.transferDepositToHouse(user)
+	if errors.Is(err, clientlib.ErrCircuitOpen) {
+		pause(err)
+		return
+	}
+	if err != nil {
+		ml.userLogger(user.DepositAddress).Error("Failed to transfer deposit to house", "error", err)
+		ml.publish(Event{
+			Kind:           EventError,
+			DepositAddress: user.DepositAddress,
+			Message:        "Failed to move deposit into the mixer, it will be retried",
+		})
+	}
+	if sentToHouse {
+		ml.addToHouseQueue(user)
+	} else if err == nil && !frequent {
+		ml.archiveIfFinished(user, now)
 	}
 }
 
-// processHouseUsers gets called inside PollForUserReturns
-// When a user comes in through the provided house channel they are added to the
-// HouseQueue. On a steady time interval each user in the queue will have some of their
-// funds returned back to them.
-func (ml *MixerLib) processHouseUsers(ticker *time.Ticker, houseChan chan MixerUser) {
+// PollForUserReturns is a looping function handling the redistribution of money.
+// It returns once ctx is cancelled, after finishing any return already in progress.
+func (ml *MixerLib) PollForUserReturns(ctx context.Context, ticker *time.Ticker) {
+	ml.health.pollerStarted(ReturnPollerComponent)
+	for ctx.Err() == nil {
+		ml.processHouseUsers(ctx, ticker)
+	}
+	ml.health.pollerStopped(ReturnPollerComponent)
+	ml.logger().Info("Stopped polling for user returns")
+}
+
+// processHouseUsers gets called inside PollForUserReturns.
+// On a steady time interval each user in the house queue will have any
+// payouts that are due sent, funds are shuffled between house addresses and the
+// ledger is reconciled with Jobcoin if due.
+func (ml *MixerLib) processHouseUsers(ctx context.Context, ticker *time.Ticker) {
+	var now time.Time
 	select {
-	case <-ticker.C:
-		usersStillInHouse := []MixerUser{}
-		for _, user := range HouseQueue {
-			emptyBalance, _ := ml.returnFundsToUser(user)
-			if !emptyBalance {
-				usersStillInHouse = append(usersStillInHouse, user)
-			}
+	case <-ctx.Done():
+		return
+	case now = <-ticker.C:
+	}
+	defer ml.Metrics.pollFinished("returns", time.Now())
+
+	queue := ml.houseQueue()
+	houseQueue, err := queue.Users()
+	if err != nil {
+		ml.logger().Error("Failed to load house queue", "error", err)
+		return
+	}
+	defer ml.health.succeeded(ReturnPollerComponent)
+	for _, user := range houseQueue {
+		if ctx.Err() != nil {
+			return
+		}
+
+		emptyBalance, err := ml.returnFundsToUser(user)
+		if errors.Is(err, clientlib.ErrCircuitOpen) {
+			ml.logger().Warn("Pausing user returns", "error", err)
+			return
 		}
-		HouseQueue = usersStillInHouse
-	case houseUser := <-houseChan:
-		log.Printf("Adding user %s to HouseQueue", houseUser.DepositAddress)
-		HouseQueue = addOrReplaceUserInCollection(HouseQueue, houseUser)
+		if err != nil {
+			ml.userLogger(user.DepositAddress).Error("Failed to return funds to user", "error", err)
+			ml.publish(Event{
+				Kind:           EventError,
+				DepositAddress: user.DepositAddress,
+				Message:        "Failed to return funds, it will be retried",
+			})
+		}
+		if emptyBalance {
+			ml.completeUser(queue, user)
+		}
+	}
+
+	ml.shuffleIfDue(now)
+	ml.reconcileIfDue(now)
+}
+
+// completeUser takes a user whose funds have all been returned out of the house
+// queue and marks them complete. A user with new funds in the house since their
+// balance was checked is left in the queue for the next pass.
+func (ml *MixerLib) completeUser(queue *HouseQueue, user MixerUser) {
+	removed, err := queue.RemoveSettled(user.DepositAddress)
+	if err != nil {
+		ml.userLogger(user.DepositAddress).Error("Failed to remove user from house queue", "error", err)
+		return
+	}
+	if !removed {
+		return
+	}
+	ml.recordProgress(user.DepositAddress, func(p *DistributionProgress) {
+		p.State = StateComplete
+		p.CompletedAt = time.Now()
+	})
+	ml.publish(Event{Kind: EventComplete, DepositAddress: user.DepositAddress})
+}
+
+// addToHouseQueue hands the user to the return poller without waiting for it.
+func (ml *MixerLib) addToHouseQueue(user MixerUser) {
+	logger := ml.userLogger(user.DepositAddress)
+	logger.Info("Adding user to house queue")
+	_, err := ml.houseQueue().Enqueue(user)
+	if err != nil {
+		logger.Error("Failed to add user to house queue", "error", err)
 	}
 }
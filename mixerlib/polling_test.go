@@ -1,42 +1,60 @@
 package mixerlib
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ckaminer/jobcoin"
 	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/ckaminer/jobcoin/jobcointest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
-// Begin processMixerUsers tests
-func TestProcessMixerUsers_AddsUsersFromChannelToMixerUsers(t *testing.T) {
-	user := MixerUser{
-		DepositAddress: "1234abcd",
-		ReturnAddresses: []string{
-			"1111aaaa",
-			"2222bbbb",
-			"3333cccc",
-		},
-	}
+// slowJobcoinClient calls delay before each address lookup, and records the
+// order addresses are looked up in and the most lookups made at once.
+type slowJobcoinClient struct {
+	clientlib.JobcoinClient
+	delay func(address string)
 
-	MixerUsers = []MixerUser{}
-	assert.Equal(t, 0, len(MixerUsers))
+	mu          sync.Mutex
+	lookedUp    []string
+	inFlight    int
+	maxInFlight int
+}
 
-	// expectedErr := errors.New("GetAddressInfo failed")
-	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil)
-	ml := &MixerLib{jobcoinMock}
+func (c *slowJobcoinClient) GetAddressInfo(address string) (clientlib.JobcoinAddressInfo, error) {
+	c.mu.Lock()
+	c.lookedUp = append(c.lookedUp, address)
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
 
-	ticker := time.NewTicker(1 * time.Second)
-	userChan := make(chan MixerUser, 1)
-	userChan <- user
+	c.delay(address)
 
-	ml.processMixerUsers(ticker, userChan, nil)
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	return c.JobcoinClient.GetAddressInfo(address)
+}
 
-	assert.Equal(t, 1, len(MixerUsers))
-	assert.Equal(t, user, MixerUsers[0])
+func (c *slowJobcoinClient) snapshot() ([]string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.lookedUp...), c.maxInFlight
 }
 
-func TestProcessMixerUsers_SendsUsersToHouseChannelIfFundsSentToHouse(t *testing.T) {
+// Begin processMixerUsers tests
+func TestProcessMixerUsers_AddsUserToHouseQueueIfFundsSentToHouse(t *testing.T) {
 	user := MixerUser{
 		DepositAddress: "1234abcd",
 		ReturnAddresses: []string{
@@ -45,51 +63,118 @@ func TestProcessMixerUsers_SendsUsersToHouseChannelIfFundsSentToHouse(t *testing
 			"3333cccc",
 		},
 	}
-	MixerUsers = []MixerUser{user}
 
 	// Balance greater than zero ensures user funds sent to house
 	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "10",
+		Balance: clientlib.MustParseAmount("10"),
 	}
 	jobcoinMock := newJobcoinMock(mockAddressInfo, nil, nil)
-	ml := &MixerLib{jobcoinMock}
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Store.AddUser(user)
 
 	ticker := time.NewTicker(1 * time.Second)
-	houseChan := make(chan MixerUser, 1)
 
-	ml.processMixerUsers(ticker, nil, houseChan)
+	ml.processMixerUsers(context.Background(), ticker)
+
+	houseQueue, _ := ml.Store.HouseQueue()
+	assert.Equal(t, []MixerUser{user}, houseQueue)
+}
+
+func TestProcessMixerUsers_StopsCheckingUsersWhileCircuitOpen(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, clientlib.ErrCircuitOpen, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	// A single worker, so the second user would only be started after the first
+	ml.Config.DepositPollWorkers = 1
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd"})
+	ml.Store.AddUser(MixerUser{DepositAddress: "5678efgh"})
 
-	houseUser := <-houseChan
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	ticker := &time.Ticker{C: tick}
 
-	assert.Equal(t, user, houseUser)
+	ml.processMixerUsers(context.Background(), ticker)
+
+	assert.Equal(t, 1, jobcoinMock.Lookups)
 }
 
-// Begin processHouseUsers tests
-func TestProcessHouseUsers_AddsUsersFromChannelToHouseQueue(t *testing.T) {
-	user := MixerUser{
-		DepositAddress: "1234abcd",
-		ReturnAddresses: []string{
-			"1111aaaa",
-			"2222bbbb",
-			"3333cccc",
-		},
+func TestProcessMixerUsers_LogsFailuresWithDepositAddress(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, errors.New("GetAddressInfo failed"), nil))
+	var out bytes.Buffer
+	ml.Logger = slog.New(slog.NewJSONHandler(&out, nil))
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd"})
+
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	ml.processMixerUsers(context.Background(), &time.Ticker{C: tick})
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(out.Bytes(), &entry)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
 	}
 
-	HouseQueue = []MixerUser{}
-	assert.Equal(t, 0, len(HouseQueue))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, "Failed to transfer deposit to house", entry["msg"])
+	assert.Equal(t, "1234abcd", entry["depositAddress"])
+	assert.Equal(t, "GetAddressInfo failed", entry["error"])
+}
 
-	ml := &MixerLib{}
+func TestProcessMixerUsers_ChecksDepositAddressesConcurrently(t *testing.T) {
+	client := &slowJobcoinClient{
+		JobcoinClient: newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil),
+		delay:         func(string) { time.Sleep(100 * time.Millisecond) },
+	}
+	ml := newTestMixerLib(client)
+	ml.Config.DepositPollWorkers = 3
+	for _, address := range []string{"1111aaaa", "2222bbbb", "3333cccc", "4444dddd"} {
+		ml.Store.AddUser(MixerUser{DepositAddress: address})
+	}
 
-	ticker := time.NewTicker(1 * time.Second)
-	houseChan := make(chan MixerUser, 1)
-	houseChan <- user
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	ml.processMixerUsers(context.Background(), &time.Ticker{C: tick})
 
-	ml.processHouseUsers(ticker, houseChan)
+	lookedUp, maxInFlight := client.snapshot()
+	assert.Equal(t, 4, len(lookedUp))
+	assert.Equal(t, 3, maxInFlight)
+}
 
-	assert.Equal(t, 1, len(HouseQueue))
-	assert.Equal(t, user, HouseQueue[0])
+func TestProcessMixerUsers_DefersUsersNotStartedBeforeNextTick(t *testing.T) {
+	now := time.Now()
+	interval := 200 * time.Millisecond
+	client := &slowJobcoinClient{
+		JobcoinClient: newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil),
+		// The first lookup lasts until after the next tick is due
+		delay: func(string) { time.Sleep(time.Until(now.Add(interval + 50*time.Millisecond))) },
+	}
+	ml := newTestMixerLib(client)
+	ml.Config.DepositPollInterval = jobcoin.Duration(interval)
+	ml.Config.DepositPollWorkers = 1
+	ml.Metrics = newTestMetrics(t, ml.Store)
+	var out bytes.Buffer
+	ml.Logger = slog.New(slog.NewJSONHandler(&out, nil))
+	for _, address := range []string{"1111aaaa", "2222bbbb", "3333cccc"} {
+		ml.Store.AddUser(MixerUser{DepositAddress: address})
+	}
+
+	tick := make(chan time.Time, 1)
+	tick <- now
+	ml.processMixerUsers(context.Background(), &time.Ticker{C: tick})
+
+	lookedUp, _ := client.snapshot()
+	assert.Equal(t, []string{"1111aaaa"}, lookedUp)
+	assert.Contains(t, out.String(), "Deposit poll ran out of time")
+	assert.Equal(t, float64(2), testutil.ToFloat64(ml.Metrics.DepositChecksDeferred))
+
+	client.delay = func(string) {}
+	tick <- time.Now()
+	ml.processMixerUsers(context.Background(), &time.Ticker{C: tick})
+
+	lookedUp, _ = client.snapshot()
+	assert.Equal(t, []string{"1111aaaa", "2222bbbb", "3333cccc", "1111aaaa"}, lookedUp)
 }
 
+// Begin processHouseUsers tests
 func TestProcessHouseUsers_RemoveUserFromHouseIfAllFundsReturned(t *testing.T) {
 	user := MixerUser{
 		DepositAddress: "1234abcd",
@@ -99,28 +184,20 @@ func TestProcessHouseUsers_RemoveUserFromHouseIfAllFundsReturned(t *testing.T) {
 			"3333cccc",
 		},
 	}
-	HouseQueue = []MixerUser{user}
 
-	// Amount sent to house from user less than deposit increment
+	// Payouts are due as soon as they are scheduled
 	// All funds will be returned
-	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "10",
-		Transactions: []clientlib.JobcoinTx{
-			{
-				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "3",
-			},
-		},
-	}
-	jobcoinMock := newJobcoinMock(mockAddressInfo, nil, nil)
-	ml := &MixerLib{jobcoinMock}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil)
+	ml := newTestMixerLib(jobcoinMock)
+	creditUser(ml, user.DepositAddress, 3*clientlib.Coin)
+	ml.Store.AddToHouseQueue(user)
 
 	ticker := time.NewTicker(1 * time.Second)
 
-	ml.processHouseUsers(ticker, nil)
+	ml.processHouseUsers(context.Background(), ticker)
 
-	assert.Equal(t, 0, len(HouseQueue))
+	houseQueue, _ := ml.Store.HouseQueue()
+	assert.Equal(t, 0, len(houseQueue))
 }
 
 func TestProcessHouseUsers_LeaveUserInHouseIfNotAllFundsReturned(t *testing.T) {
@@ -132,27 +209,181 @@ func TestProcessHouseUsers_LeaveUserInHouseIfNotAllFundsReturned(t *testing.T) {
 			"3333cccc",
 		},
 	}
-	HouseQueue = []MixerUser{user}
 
-	// Amount sent to house from user more than deposit increment
-	// Deposit increment will be returned
-	mockAddressInfo := clientlib.JobcoinAddressInfo{
-		Balance: "500",
-		Transactions: []clientlib.JobcoinTx{
-			{
-				FromAddress: user.DepositAddress,
-				ToAddress:   HouseAddress,
-				Amount:      "100",
-			},
-		},
-	}
-	jobcoinMock := newJobcoinMock(mockAddressInfo, nil, nil)
-	ml := &MixerLib{jobcoinMock}
+	// Amount sent to house from user will be scheduled to be
+	// returned later, so none of it is returned yet
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil)
+	ml := newTestMixerLib(jobcoinMock)
+	creditUser(ml, user.DepositAddress, 100*clientlib.Coin)
+	ml.Config.PayoutMinDelay = jobcoin.Duration(time.Minute)
+	ml.Config.PayoutMaxDelay = jobcoin.Duration(time.Hour)
+	ml.Store.AddToHouseQueue(user)
 
 	ticker := time.NewTicker(1 * time.Second)
 
-	ml.processHouseUsers(ticker, nil)
+	ml.processHouseUsers(context.Background(), ticker)
+
+	houseQueue, _ := ml.Store.HouseQueue()
+	assert.Equal(t, 1, len(houseQueue))
+	assert.Equal(t, user, houseQueue[0])
+}
+
+func TestProcessHouseUsers_PausesWithoutPostingWhileCircuitOpen(t *testing.T) {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, clientlib.ErrCircuitOpen).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Events = NewEvents()
+	ml.Breaker = clientlib.NewCircuitBreaker(1, time.Minute)
+	requestThroughBreaker(ml.Breaker, clientlib.NewClientMock(0, nil, errors.New("connection refused")))
+	depositAddresses := []string{"1111aaaa", "2222bbbb", "3333cccc"}
+	for _, depositAddress := range depositAddresses {
+		creditUser(ml, depositAddress, 3*clientlib.Coin)
+		ml.Store.AddToHouseQueue(MixerUser{DepositAddress: depositAddress, ReturnAddresses: []string{depositAddress + "-return"}})
+	}
+	ledgerBefore, _ := ml.Store.LedgerEntries()
+	events, unsubscribe := ml.Events.Subscribe("1111aaaa")
+	defer unsubscribe()
+
+	tick := make(chan time.Time, 1)
+	for i := 0; i < 3; i++ {
+		tick <- time.Now()
+		ml.processHouseUsers(context.Background(), &time.Ticker{C: tick})
+	}
+
+	ledgerAfter, _ := ml.Store.LedgerEntries()
+	assert.Equal(t, len(ledgerBefore), len(ledgerAfter))
+	assert.Empty(t, jobcoinMock.Sent)
+	assert.Equal(t, 0, len(events))
+	payouts, _ := ml.Store.Payouts("1111aaaa")
+	var scheduled clientlib.Amount
+	for _, payout := range payouts {
+		scheduled = scheduled + payout.Amount
+	}
+	assert.Equal(t, 3*clientlib.Coin, scheduled)
+}
+
+// sweepingStore is a Store in which a new deposit reaches the house, and the
+// user is queued again, just after the user's house balance is first read.
+type sweepingStore struct {
+	Store
+	swept bool
+}
+
+func (s *sweepingStore) LedgerBalance(account string) (clientlib.Amount, error) {
+	balance, err := s.Store.LedgerBalance(account)
+	if !s.swept {
+		s.swept = true
+		s.Store.PostLedgerEntries(LedgerEntry{ID: "new-sweep", Debit: houseLedgerAccount(testHouseAddress), Credit: account, Amount: clientlib.Coin})
+		s.Store.AddToHouseQueue(MixerUser{DepositAddress: "1234abcd"})
+	}
+	return balance, err
+}
+
+func TestProcessHouseUsers_KeepsUserWhoseNewDepositArrivesWhileFinishing(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Store = &sweepingStore{Store: ml.Store}
+	ml.Store.AddToHouseQueue(user)
+
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	ml.processHouseUsers(context.Background(), &time.Ticker{C: tick})
+
+	houseQueue, _ := ml.Store.HouseQueue()
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+	assert.Equal(t, []MixerUser{user}, houseQueue)
+	assert.NotEqual(t, StateComplete, progress.State)
+}
+
+// Begin shutdown tests
+func TestPollForNewDeposits_ReturnsOnceContextCancelled(t *testing.T) {
+	ml := newTestMixerLib(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	assert.Equal(t, 1, len(HouseQueue))
-	assert.Equal(t, user, HouseQueue[0])
+	done := make(chan bool)
+	go func() {
+		ml.PollForNewDeposits(ctx, time.NewTicker(time.Hour))
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expected PollForNewDeposits to return after cancellation.")
+	}
+}
+
+func TestPollForUserReturns_ReturnsOnceContextCancelled(t *testing.T) {
+	ml := newTestMixerLib(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan bool)
+	go func() {
+		ml.PollForUserReturns(ctx, time.NewTicker(time.Hour))
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expected PollForUserReturns to return after cancellation.")
+	}
+}
+
+// Begin end-to-end tests
+func TestPolling_MixesDepositThroughSimulatedJobcoinNetwork(t *testing.T) {
+	ledger := jobcointest.NewLedger()
+	server := httptest.NewServer(jobcointest.NewHandler(ledger))
+	defer server.Close()
+
+	ml := newTestMixerLib(&clientlib.JobcoinLib{
+		Client:  server.Client(),
+		BaseURL: server.URL + "/api",
+	})
+	user := MixerUser{
+		DepositAddress:  "1234abcd",
+		ReturnAddresses: []string{"1111aaaa", "2222bbbb", "3333cccc"},
+	}
+	ml.Store.AddUser(user)
+
+	ledger.Create("sender", 20*clientlib.Coin)
+	ledger.Send("sender", user.DepositAddress, 12*clientlib.Coin)
+
+	tick := make(chan time.Time, 1)
+	ticker := &time.Ticker{C: tick}
+
+	tick <- time.Now()
+	ml.processMixerUsers(context.Background(), ticker)
+
+	for i := 0; i < 3; i++ {
+		tick <- time.Now()
+		ml.processHouseUsers(context.Background(), ticker)
+	}
+
+	var returned clientlib.Amount
+	for _, address := range user.ReturnAddresses {
+		returned = returned + ledger.AddressInfo(address).Balance
+	}
+	houseQueue, _ := ml.Store.HouseQueue()
+	status, _ := ml.UserStatus(user.DepositAddress)
+	discrepancies, _ := ml.ReconcileLedger()
+	userBalance, _ := ml.userHouseBalance(user.DepositAddress)
+
+	assert.Equal(t, clientlib.MustParseAmount("0.12"), ledger.AddressInfo(testBankFund).Balance)
+	assert.Equal(t, clientlib.MustParseAmount("11.88"), returned)
+	assert.Equal(t, clientlib.Amount(0), ledger.AddressInfo(testHouseAddress).Balance)
+	assert.Equal(t, 0, len(houseQueue))
+	assert.Equal(t, []LedgerDiscrepancy{}, discrepancies)
+	assert.Equal(t, clientlib.Amount(0), userBalance)
+
+	assert.Equal(t, StateComplete, status.State)
+	assert.Equal(t, 12*clientlib.Coin, status.Deposited)
+	assert.Equal(t, clientlib.MustParseAmount("0.12"), status.Fee)
+	assert.Equal(t, clientlib.Amount(0), status.Remaining)
+	for _, address := range user.ReturnAddresses {
+		assert.Equal(t, ledger.AddressInfo(address).Balance, status.Returned[address])
+	}
 }
@@ -0,0 +1,194 @@
+package mixerlib
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// Mixing returns the house addresses currently used to mix user funds: the
+// main house address followed by the rest of the pool.
+func (ha HouseAccount) Mixing() []string {
+	return append([]string{ha.Address}, ha.Pool...)
+}
+
+// growHousePool adds new addresses to the house pool until it holds
+// HousePoolSize addresses. The pool is never shrunk, as addresses being
+// removed could still hold user funds.
+func (ml *MixerLib) growHousePool() error {
+	house := ml.House
+	added := 0
+	for len(house.Mixing()) < ml.Config.HousePoolSize {
+		address, err := newHouseAddress()
+		if err != nil {
+			return err
+		}
+		house.Pool = append(append([]string{}, house.Pool...), address)
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+
+	err := ml.Store.SaveHouseAccount(house)
+	if err != nil {
+		return err
+	}
+	ml.House = house
+	ml.logger().Info("Added addresses to the house pool", "added", added)
+
+	return nil
+}
+
+// randomHouse returns one of the house addresses in the pool at random.
+func (ml *MixerLib) randomHouse() string {
+	addresses := ml.House.Mixing()
+	return addresses[rand.Intn(len(addresses))]
+}
+
+// HouseBalances returns the Jobcoin balance of every house address in the pool.
+func (ml *MixerLib) HouseBalances() (map[string]clientlib.Amount, error) {
+	balances := map[string]clientlib.Amount{}
+	for _, address := range ml.House.Mixing() {
+		info, err := ml.JobcoinClient.GetAddressInfo(address)
+		if err != nil {
+			return nil, err
+		}
+		balances[address] = info.Balance
+	}
+	return balances, nil
+}
+
+// payoutHouse returns a house address holding at least amount, picked at
+// random from those that do. If no single house holds enough, funds are moved
+// from the others into the house holding the most.
+func (ml *MixerLib) payoutHouse(amount clientlib.Amount) (string, error) {
+	if len(ml.House.Mixing()) == 1 {
+		return ml.House.Address, nil
+	}
+
+	balances, err := ml.HouseBalances()
+	if err != nil {
+		return "", err
+	}
+
+	addresses := sortedByBalance(balances)
+	candidates := []string{}
+	for _, address := range addresses {
+		if balances[address] >= amount {
+			candidates = append(candidates, address)
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[rand.Intn(len(candidates))], nil
+	}
+
+	richest := addresses[0]
+	needed := amount - balances[richest]
+	for _, address := range addresses[1:] {
+		if needed <= 0 {
+			break
+		}
+		transfer := balances[address]
+		if transfer > needed {
+			transfer = needed
+		}
+		if transfer <= 0 {
+			continue
+		}
+		err = ml.JobcoinClient.SendJobcoin(address, richest, transfer)
+		if err != nil {
+			return "", err
+		}
+		ml.recordHouseTransfer(address, richest, transfer, "consolidation")
+		needed = needed - transfer
+	}
+	if needed > 0 {
+		return "", fmt.Errorf("house pool is %s short of a payout of %s", needed, amount)
+	}
+
+	return richest, nil
+}
+
+// ShuffleHouseFunds moves a random share, between 10% and 50%, of one house
+// address's balance to another house address in the pool, so that the flow of
+// funds in and out of any one house does not line up with user deposits.
+func (ml *MixerLib) ShuffleHouseFunds() error {
+	if len(ml.House.Mixing()) < 2 {
+		return nil
+	}
+
+	balances, err := ml.HouseBalances()
+	if err != nil {
+		return err
+	}
+
+	funded := []string{}
+	for _, address := range sortedByBalance(balances) {
+		if balances[address] > 0 {
+			funded = append(funded, address)
+		}
+	}
+	if len(funded) == 0 {
+		return nil
+	}
+
+	from := funded[rand.Intn(len(funded))]
+	to := from
+	for to == from {
+		to = ml.randomHouse()
+	}
+	amount := balances[from].BasisPoints(1000 + rand.Int63n(4001))
+	if amount <= 0 {
+		return nil
+	}
+
+	err = ml.JobcoinClient.SendJobcoin(from, to, amount)
+	if err != nil {
+		return err
+	}
+	ml.recordHouseTransfer(from, to, amount, "shuffle")
+	ml.logger().Info("Shuffled house funds", "amount", amount, "from", from, "to", to)
+
+	return nil
+}
+
+// shuffleIfDue calls ShuffleHouseFunds if HouseShuffleInterval has passed
+// since the last shuffle. The first shuffle happens one interval after the
+// mixer starts.
+func (ml *MixerLib) shuffleIfDue(now time.Time) {
+	if ml.Config.HouseShuffleInterval <= 0 {
+		return
+	}
+	if ml.lastShuffle.IsZero() {
+		ml.lastShuffle = now
+		return
+	}
+	if now.Sub(ml.lastShuffle) < ml.Config.HouseShuffleInterval.Std() {
+		return
+	}
+
+	ml.lastShuffle = now
+	err := ml.ShuffleHouseFunds()
+	if err != nil {
+		ml.logger().Error("Failed to shuffle house funds", "error", err)
+	}
+}
+
+// sortedByBalance returns the addresses in balances, largest balance first.
+func sortedByBalance(balances map[string]clientlib.Amount) []string {
+	addresses := []string{}
+	for address := range balances {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		if balances[addresses[i]] == balances[addresses[j]] {
+			return addresses[i] < addresses[j]
+		}
+		return balances[addresses[i]] > balances[addresses[j]]
+	})
+	return addresses
+}
@@ -0,0 +1,160 @@
+package mixerlib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPoolMock returns a Jobcoin mock where each house address in the pool
+// holds the given balance.
+func newTestPoolMock(balances map[string]clientlib.Amount) *mockJobcoinClient {
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	jobcoinMock.AddressInfos = map[string]clientlib.JobcoinAddressInfo{}
+	for address, balance := range balances {
+		jobcoinMock.AddressInfos[address] = clientlib.JobcoinAddressInfo{Balance: balance}
+	}
+	return jobcoinMock
+}
+
+// Begin growHousePool tests
+func TestLoadHouseAccount_GrowsPoolToConfiguredSize(t *testing.T) {
+	ml := &MixerLib{
+		Store:  NewMemoryStore(),
+		Config: jobcoin.MixerConfig{BankFund: testBankFund, HousePoolSize: 3},
+	}
+	ml.Store.SaveHouseAccount(HouseAccount{Address: "saved-house", Pool: []string{"pool-house"}})
+
+	err := ml.LoadHouseAccount()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	saved, _ := ml.Store.HouseAccount()
+
+	assert.Equal(t, 3, len(ml.House.Mixing()))
+	assert.Equal(t, "saved-house", ml.House.Mixing()[0])
+	assert.Equal(t, "pool-house", ml.House.Mixing()[1])
+	assert.Equal(t, saved, ml.House)
+}
+
+func TestLoadHouseAccount_NeverShrinksPool(t *testing.T) {
+	house := HouseAccount{Address: "saved-house", Pool: []string{"pool-one", "pool-two"}}
+	ml := &MixerLib{Store: NewMemoryStore(), Config: jobcoin.MixerConfig{HousePoolSize: 1}}
+	ml.Store.SaveHouseAccount(house)
+
+	ml.LoadHouseAccount()
+
+	assert.Equal(t, house, ml.House)
+}
+
+// Begin Addresses tests
+func TestHouseAccountAddresses_IncludesPoolAndPreviousAddresses(t *testing.T) {
+	house := HouseAccount{
+		Address: "house-two",
+		Pool:    []string{"pool-one"},
+		Rotations: []HouseRotation{
+			{PreviousAddress: "house-one", NewAddress: "house-two"},
+		},
+	}
+
+	assert.Equal(t, []string{"house-two", "pool-one", "house-one"}, house.Addresses())
+}
+
+// Begin payoutHouse tests
+func TestPayoutHouse_PicksHouseWithEnoughBalance(t *testing.T) {
+	jobcoinMock := newTestPoolMock(map[string]clientlib.Amount{
+		testHouseAddress: clientlib.Coin,
+		"pool-one":       10 * clientlib.Coin,
+		"pool-two":       2 * clientlib.Coin,
+	})
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Pool = []string{"pool-one", "pool-two"}
+
+	house, err := ml.payoutHouse(5 * clientlib.Coin)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, "pool-one", house)
+	assert.Equal(t, 0, len(jobcoinMock.Sent))
+}
+
+func TestPayoutHouse_ConsolidatesFundsIfNoHouseHasEnough(t *testing.T) {
+	jobcoinMock := newTestPoolMock(map[string]clientlib.Amount{
+		testHouseAddress: 3 * clientlib.Coin,
+		"pool-one":       4 * clientlib.Coin,
+		"pool-two":       2 * clientlib.Coin,
+	})
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Pool = []string{"pool-one", "pool-two"}
+
+	house, err := ml.payoutHouse(8 * clientlib.Coin)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedTxs := []clientlib.JobcoinTx{
+		{FromAddress: testHouseAddress, ToAddress: "pool-one", Amount: 3 * clientlib.Coin},
+		{FromAddress: "pool-two", ToAddress: "pool-one", Amount: clientlib.Coin},
+	}
+	assert.Equal(t, "pool-one", house)
+	assert.Equal(t, expectedTxs, jobcoinMock.Sent)
+}
+
+func TestPayoutHouse_ReturnsErrorIfPoolHoldsTooLittle(t *testing.T) {
+	jobcoinMock := newTestPoolMock(map[string]clientlib.Amount{
+		testHouseAddress: clientlib.Coin,
+		"pool-one":       clientlib.Coin,
+	})
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Pool = []string{"pool-one"}
+
+	_, err := ml.payoutHouse(5 * clientlib.Coin)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
+
+// Begin ShuffleHouseFunds tests
+func TestShuffleHouseFunds_MovesPartOfOneHouseBalanceToAnother(t *testing.T) {
+	jobcoinMock := newTestPoolMock(map[string]clientlib.Amount{
+		testHouseAddress: 0,
+		"pool-one":       100 * clientlib.Coin,
+	})
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Pool = []string{"pool-one"}
+
+	err := ml.ShuffleHouseFunds()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, 1, len(jobcoinMock.Sent))
+	tx := jobcoinMock.Sent[0]
+	assert.Equal(t, "pool-one", tx.FromAddress)
+	assert.Equal(t, testHouseAddress, tx.ToAddress)
+	assert.True(t, tx.Amount >= 10*clientlib.Coin)
+	assert.True(t, tx.Amount <= 50*clientlib.Coin)
+}
+
+func TestShuffleIfDue_WaitsForShuffleInterval(t *testing.T) {
+	jobcoinMock := newTestPoolMock(map[string]clientlib.Amount{
+		testHouseAddress: 10 * clientlib.Coin,
+		"pool-one":       10 * clientlib.Coin,
+	})
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Pool = []string{"pool-one"}
+	ml.Config.HouseShuffleInterval = jobcoin.Duration(time.Minute)
+	start := time.Now()
+
+	ml.shuffleIfDue(start)
+	ml.shuffleIfDue(start.Add(30 * time.Second))
+	assert.Equal(t, 0, len(jobcoinMock.Sent))
+
+	ml.shuffleIfDue(start.Add(time.Minute))
+	assert.Equal(t, 1, len(jobcoinMock.Sent))
+}
@@ -0,0 +1,100 @@
+package mixerlib
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// QueuePressure is how full a HouseQueue is. A Limit of 0 means the queue has
+// no limit.
+type QueuePressure struct {
+	Length int `json:"length"`
+	Limit  int `json:"limit"`
+}
+
+// BackedUp reports whether more users are waiting than the queue's limit.
+func (p QueuePressure) BackedUp() bool {
+	return p.Limit > 0 && p.Length > p.Limit
+}
+
+// HouseQueue holds the users whose funds have reached the house, handing them
+// from the deposit poller to the return poller. Users are kept in Store, so
+// the queue survives a restart, and Enqueue only saves the user: it never
+// waits for the return poller, which picks the user up on its next pass.
+//
+// Nothing stops users being added to a queue that is backed up, as their
+// funds are already in the house. Instead the queue logs a warning once more
+// than Limit users are waiting, and again once it has drained, so that a
+// return poller falling behind is noticed without slowing down the deposit
+// poller or the API. A Limit of 0 never reports back-pressure. It is safe for
+// concurrent use.
+type HouseQueue struct {
+	Store  Store
+	Limit  int
+	Logger *slog.Logger
+
+	mu       sync.Mutex
+	backedUp bool
+}
+
+// NewHouseQueue returns a HouseQueue kept in store that reports back-pressure
+// once more than limit users are waiting.
+func NewHouseQueue(store Store, limit int) *HouseQueue {
+	return &HouseQueue{Store: store, Limit: limit}
+}
+
+func (q *HouseQueue) logger() *slog.Logger {
+	if q.Logger == nil {
+		return slog.Default()
+	}
+	return q.Logger
+}
+
+// Users returns the users waiting in the queue, in the order they were added.
+func (q *HouseQueue) Users() ([]MixerUser, error) {
+	return q.Store.HouseQueue()
+}
+
+// Enqueue adds the user to the end of the queue, or moves them there if they
+// are already in it, and returns how full the queue now is.
+func (q *HouseQueue) Enqueue(user MixerUser) (QueuePressure, error) {
+	err := q.Store.AddToHouseQueue(user)
+	if err != nil {
+		return QueuePressure{}, err
+	}
+	return q.Pressure()
+}
+
+// RemoveSettled takes the user with the given deposit address out of the
+// queue, unless the house still owes them anything or they have payouts
+// scheduled, and returns whether they were removed. This is checked in the
+// same store update as the removal, so a user whose new deposit reaches the
+// house while the return poller is finishing with them stays in the queue.
+func (q *HouseQueue) RemoveSettled(depositAddress string) (bool, error) {
+	removed, err := q.Store.RemoveSettledFromHouseQueue(depositAddress)
+	if err != nil || !removed {
+		return removed, err
+	}
+	_, err = q.Pressure()
+	return true, err
+}
+
+// Pressure returns how full the queue is, logging if it has become backed up
+// or drained since it was last checked.
+func (q *HouseQueue) Pressure() (QueuePressure, error) {
+	users, err := q.Store.HouseQueue()
+	if err != nil {
+		return QueuePressure{}, err
+	}
+	pressure := QueuePressure{Length: len(users), Limit: q.Limit}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if pressure.BackedUp() && !q.backedUp {
+		q.logger().Warn("House queue is backed up, user returns are falling behind", "length", pressure.Length, "limit", pressure.Limit)
+	} else if !pressure.BackedUp() && q.backedUp {
+		q.logger().Info("House queue has drained", "length", pressure.Length, "limit", pressure.Limit)
+	}
+	q.backedUp = pressure.BackedUp()
+	return pressure, nil
+}
@@ -0,0 +1,58 @@
+package mixerlib
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin HouseQueue tests
+func TestHouseQueue_EnqueueSurvivesRestart(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	queue := NewHouseQueue(fs, 10)
+	user := MixerUser{DepositAddress: "1234abcd", ReturnAddresses: []string{"1111aaaa"}, RegisteredAt: testRegisteredAt}
+
+	pressure, err := queue.Enqueue(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+	assert.Equal(t, QueuePressure{Length: 1, Limit: 10}, pressure)
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	users, _ := NewHouseQueue(reopened, 10).Users()
+	assert.Equal(t, []MixerUser{user}, users)
+}
+
+func TestHouseQueue_ReportsBackPressureOnceUntilDrained(t *testing.T) {
+	var out bytes.Buffer
+	queue := NewHouseQueue(NewMemoryStore(), 1)
+	queue.Logger = slog.New(slog.NewJSONHandler(&out, nil))
+
+	queue.Enqueue(MixerUser{DepositAddress: "1111aaaa"})
+	assert.Empty(t, out.String())
+
+	pressure, _ := queue.Enqueue(MixerUser{DepositAddress: "2222bbbb"})
+	queue.Enqueue(MixerUser{DepositAddress: "3333cccc"})
+	assert.True(t, pressure.BackedUp())
+	assert.Equal(t, 1, strings.Count(out.String(), "House queue is backed up"))
+
+	queue.RemoveSettled("2222bbbb")
+	queue.RemoveSettled("3333cccc")
+	assert.Contains(t, out.String(), "House queue has drained")
+
+	pressure, _ = queue.Pressure()
+	assert.Equal(t, QueuePressure{Length: 1, Limit: 1}, pressure)
+	assert.False(t, pressure.BackedUp())
+}
+
+func TestQueuePressure_NeverBackedUpWithoutLimit(t *testing.T) {
+	assert.False(t, QueuePressure{Length: 1000000}.BackedUp())
+	assert.False(t, QueuePressure{Length: 5, Limit: 5}.BackedUp())
+	assert.True(t, QueuePressure{Length: 6, Limit: 5}.BackedUp())
+}
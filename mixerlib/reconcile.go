@@ -0,0 +1,290 @@
+package mixerlib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// DiscrepancyKind describes what a Discrepancy found by Reconcile is.
+type DiscrepancyKind string
+
+// The kinds of discrepancy Reconcile looks for.
+const (
+	// HouseBalanceMismatch is a house address holding a different amount
+	// than the ledger says it should.
+	HouseBalanceMismatch DiscrepancyKind = "house_balance"
+	// HouseTotalMismatch is the house addresses together holding a different
+	// amount than the mixer owes users.
+	HouseTotalMismatch DiscrepancyKind = "house_total"
+	// UnexpectedInbound is a transfer to a house address from an address
+	// that is neither a house address nor a deposit address.
+	UnexpectedInbound DiscrepancyKind = "unexpected_inbound"
+	// MissingPayout is a user who has been returned less than the ledger
+	// says they have been.
+	MissingPayout DiscrepancyKind = "missing_payout"
+	// Overpayment is a user who has been returned more than the ledger says
+	// they have been.
+	Overpayment DiscrepancyKind = "overpayment"
+	// FeeMismatch is a user who has been charged a different fee than the
+	// mixer recorded.
+	FeeMismatch DiscrepancyKind = "fee_mismatch"
+	// RefundMismatch is a user who has been refunded a different amount than
+	// the mixer recorded.
+	RefundMismatch DiscrepancyKind = "refund_mismatch"
+)
+
+// Discrepancy is a difference between the mixer's own state and the Jobcoin
+// network. Address is the house address or, for a user, their deposit
+// address, and is empty for HouseTotalMismatch. Expected is what the mixer's
+// state says and Actual what Jobcoin says.
+type Discrepancy struct {
+	Kind     DiscrepancyKind  `json:"kind"`
+	Address  string           `json:"address"`
+	Expected clientlib.Amount `json:"expected"`
+	Actual   clientlib.Amount `json:"actual"`
+	Detail   string           `json:"detail,omitempty"`
+}
+
+func (d Discrepancy) String() string {
+	s := string(d.Kind)
+	if d.Address != "" {
+		s = s + " " + d.Address
+	}
+	s = s + fmt.Sprintf(": expected %s, found %s", d.Expected, d.Actual)
+	if d.Detail != "" {
+		s = s + " (" + d.Detail + ")"
+	}
+	return s
+}
+
+// ReconciliationReport is the result of comparing the mixer's state with the
+// Jobcoin network. Owed is what the mixer owes users according to the ledger,
+// HouseBalance what the house addresses hold, FeesCollected what the bank
+// fund has received from deposit addresses and Unswept what is sitting in
+// deposit addresses waiting to be swept.
+type ReconciliationReport struct {
+	CheckedAt     time.Time        `json:"checkedAt"`
+	Owed          clientlib.Amount `json:"owed"`
+	HouseBalance  clientlib.Amount `json:"houseBalance"`
+	FeesCollected clientlib.Amount `json:"feesCollected"`
+	Unswept       clientlib.Amount `json:"unswept"`
+	Discrepancies []Discrepancy    `json:"discrepancies"`
+}
+
+// Reconcile fetches the house addresses, the bank fund and every deposit
+// address from Jobcoin and compares them with the ledger and each user's
+// distribution progress. Archived users were settled before being archived,
+// so their deposit addresses are not fetched, though their sweeps and fees
+// are still expected. Users with an unfinished sweep are skipped, and a
+// sweep or payout being sent while this runs can show up in the house
+// balances until it has been recorded.
+func (ml *MixerLib) Reconcile() (ReconciliationReport, error) {
+	report := ReconciliationReport{CheckedAt: time.Now(), Discrepancies: []Discrepancy{}}
+
+	balances, err := ml.Store.LedgerBalances()
+	if err != nil {
+		return report, err
+	}
+	users, err := ml.Store.Users()
+	if err != nil {
+		return report, err
+	}
+	archived, err := ml.Store.ArchivedUsers()
+	if err != nil {
+		return report, err
+	}
+	houses := ml.House.Addresses()
+	houseInfos, err := ml.addressInfos(houses)
+	if err != nil {
+		return report, err
+	}
+
+	for _, d := range ledgerDiscrepancies(houses, balances, houseInfos) {
+		report.Discrepancies = append(report.Discrepancies, Discrepancy{
+			Kind:     HouseBalanceMismatch,
+			Address:  d.Address,
+			Expected: d.Ledger,
+			Actual:   d.Jobcoin,
+		})
+	}
+
+	for account, balance := range balances {
+		if strings.HasPrefix(account, userLedgerAccount("")) {
+			report.Owed = report.Owed - balance
+		}
+	}
+	for _, address := range houses {
+		report.HouseBalance = report.HouseBalance + houseInfos[address].Balance
+	}
+	if report.Owed != report.HouseBalance {
+		report.Discrepancies = append(report.Discrepancies, Discrepancy{
+			Kind:     HouseTotalMismatch,
+			Expected: report.Owed,
+			Actual:   report.HouseBalance,
+			Detail:   "all house addresses",
+		})
+	}
+
+	isHouse := map[string]bool{}
+	for _, address := range houses {
+		isHouse[address] = true
+	}
+	isDeposit := map[string]bool{}
+	for _, user := range users {
+		isDeposit[user.DepositAddress] = true
+	}
+	for _, user := range archived {
+		isDeposit[user.User.DepositAddress] = true
+	}
+	for _, address := range houses {
+		for _, tx := range houseInfos[address].Transactions {
+			if tx.ToAddress == address && !isHouse[tx.FromAddress] && !isDeposit[tx.FromAddress] {
+				report.Discrepancies = append(report.Discrepancies, Discrepancy{
+					Kind:    UnexpectedInbound,
+					Address: address,
+					Actual:  tx.Amount,
+					Detail:  "from " + tx.FromAddress,
+				})
+			}
+		}
+	}
+
+	if ml.House.BankFund != "" {
+		bankFund, err := ml.JobcoinClient.GetAddressInfo(ml.House.BankFund)
+		if err != nil {
+			return report, err
+		}
+		for _, tx := range bankFund.Transactions {
+			if tx.ToAddress == ml.House.BankFund && isDeposit[tx.FromAddress] {
+				report.FeesCollected = report.FeesCollected + tx.Amount
+			}
+		}
+	}
+
+	for _, user := range users {
+		discrepancies, unswept, err := ml.reconcileUser(user, houseInfos, isHouse, balances)
+		if err != nil {
+			return report, err
+		}
+		report.Unswept = report.Unswept + unswept
+		report.Discrepancies = append(report.Discrepancies, discrepancies...)
+	}
+
+	return report, nil
+}
+
+// reconcileUser compares what the house transaction history says has been
+// returned to the user with what the ledger says should have been, and the
+// fees paid and refunds sent from their deposit address with what was
+// recorded in their progress. It also returns the balance of their deposit
+// address.
+func (ml *MixerLib) reconcileUser(user MixerUser, houseInfos map[string]clientlib.JobcoinAddressInfo, isHouse map[string]bool, balances map[string]clientlib.Amount) ([]Discrepancy, clientlib.Amount, error) {
+	deposit, err := ml.JobcoinClient.GetAddressInfo(user.DepositAddress)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, pending, err := ml.Store.Sweep(user.DepositAddress)
+	if err != nil || pending {
+		return nil, deposit.Balance, err
+	}
+	progress, err := ml.Store.Progress(user.DepositAddress)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var swept, returned clientlib.Amount
+	for address, info := range houseInfos {
+		for _, tx := range info.Transactions {
+			if tx.FromAddress == user.DepositAddress && tx.ToAddress == address {
+				swept = swept + tx.Amount
+			}
+			if tx.FromAddress == address && containsElement(user.ReturnAddresses, tx.ToAddress) {
+				returned = returned + tx.Amount
+			}
+		}
+	}
+	// Anything leaving the deposit address other than for the house or the
+	// user's refund address is counted as a fee.
+	var feesPaid, refunded clientlib.Amount
+	for _, tx := range deposit.Transactions {
+		if tx.FromAddress != user.DepositAddress || isHouse[tx.ToAddress] {
+			continue
+		}
+		if user.RefundAddress != "" && tx.ToAddress == user.RefundAddress {
+			refunded = refunded + tx.Amount
+		} else {
+			feesPaid = feesPaid + tx.Amount
+		}
+	}
+
+	discrepancies := []Discrepancy{}
+	// The ledger balance is what is still owed, so whatever else was swept
+	// should have been returned.
+	expectedReturned := swept + balances[userLedgerAccount(user.DepositAddress)]
+	if returned < expectedReturned {
+		discrepancies = append(discrepancies, Discrepancy{
+			Kind:     MissingPayout,
+			Address:  user.DepositAddress,
+			Expected: expectedReturned,
+			Actual:   returned,
+		})
+	} else if returned > expectedReturned {
+		discrepancies = append(discrepancies, Discrepancy{
+			Kind:     Overpayment,
+			Address:  user.DepositAddress,
+			Expected: expectedReturned,
+			Actual:   returned,
+		})
+	}
+	if feesPaid != progress.Fee {
+		discrepancies = append(discrepancies, Discrepancy{
+			Kind:     FeeMismatch,
+			Address:  user.DepositAddress,
+			Expected: progress.Fee,
+			Actual:   feesPaid,
+		})
+	}
+	if refunded != progress.Refunded {
+		discrepancies = append(discrepancies, Discrepancy{
+			Kind:     RefundMismatch,
+			Address:  user.DepositAddress,
+			Expected: progress.Refunded,
+			Actual:   refunded,
+		})
+	}
+
+	return discrepancies, deposit.Balance, nil
+}
+
+// reconcileIfDue calls Reconcile if ReconcileInterval has passed since it
+// last ran, and logs an alert for every discrepancy found. The first
+// reconciliation happens one interval after the mixer starts.
+func (ml *MixerLib) reconcileIfDue(now time.Time) {
+	if ml.Config.ReconcileInterval <= 0 {
+		return
+	}
+	if ml.lastReconcile.IsZero() {
+		ml.lastReconcile = now
+		return
+	}
+	if now.Sub(ml.lastReconcile) < ml.Config.ReconcileInterval.Std() {
+		return
+	}
+
+	ml.lastReconcile = now
+	report, err := ml.Reconcile()
+	if err != nil {
+		ml.logger().Error("Failed to reconcile with Jobcoin", "error", err)
+		return
+	}
+	ml.Metrics.reconciled(report)
+	for _, d := range report.Discrepancies {
+		ml.logger().Error(
+			"ALERT reconciliation discrepancy",
+			"kind", d.Kind, "address", d.Address, "expected", d.Expected, "actual", d.Actual, "detail", d.Detail,
+		)
+	}
+}
@@ -0,0 +1,185 @@
+package mixerlib
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/ckaminer/jobcoin/jobcointest"
+	"github.com/stretchr/testify/assert"
+)
+
+var reconcileTestUser = MixerUser{
+	DepositAddress:  "1234abcd",
+	ReturnAddresses: []string{"1111aaaa", "2222bbbb"},
+}
+
+// newReconcileTestMixerLib returns a MixerLib backed by a simulated Jobcoin
+// network in which reconcileTestUser has deposited 12 Jobcoin and had it
+// swept to the house. Nothing has been returned yet.
+func newReconcileTestMixerLib(t *testing.T) (*MixerLib, *jobcointest.Ledger) {
+	ledger := jobcointest.NewLedger()
+	server := httptest.NewServer(jobcointest.NewHandler(ledger))
+	t.Cleanup(server.Close)
+
+	ml := newTestMixerLib(&clientlib.JobcoinLib{
+		Client:  server.Client(),
+		BaseURL: server.URL + "/api",
+	})
+	ml.Store.AddUser(reconcileTestUser)
+
+	ledger.Create("sender", 20*clientlib.Coin)
+	ledger.Send("sender", reconcileTestUser.DepositAddress, 12*clientlib.Coin)
+
+	_, err := ml.transferDepositToHouse(reconcileTestUser)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	return ml, ledger
+}
+
+// Begin Reconcile tests
+func TestReconcile_FindsNoDiscrepanciesOnceFundsReturned(t *testing.T) {
+	ml, _ := newReconcileTestMixerLib(t)
+	ml.Store.AddToHouseQueue(reconcileTestUser)
+
+	tick := make(chan time.Time, 1)
+	tick <- time.Now()
+	ml.processHouseUsers(context.Background(), &time.Ticker{C: tick})
+
+	report, err := ml.Reconcile()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, []Discrepancy{}, report.Discrepancies)
+	assert.Equal(t, clientlib.Amount(0), report.Owed)
+	assert.Equal(t, clientlib.Amount(0), report.HouseBalance)
+	assert.Equal(t, clientlib.MustParseAmount("0.12"), report.FeesCollected)
+}
+
+func TestReconcile_ReportsUnexpectedInboundTransferToHouse(t *testing.T) {
+	ml, ledger := newReconcileTestMixerLib(t)
+	ledger.Send("sender", testHouseAddress, 5*clientlib.Coin)
+
+	report, err := ml.Reconcile()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	owed := clientlib.MustParseAmount("11.88")
+	expected := []Discrepancy{
+		{Kind: HouseBalanceMismatch, Address: testHouseAddress, Expected: owed, Actual: owed + 5*clientlib.Coin},
+		{Kind: HouseTotalMismatch, Expected: owed, Actual: owed + 5*clientlib.Coin, Detail: "all house addresses"},
+		{Kind: UnexpectedInbound, Address: testHouseAddress, Actual: 5 * clientlib.Coin, Detail: "from sender"},
+	}
+	assert.Equal(t, expected, report.Discrepancies)
+}
+
+func TestReconcile_ReportsPayoutMissingFromJobcoin(t *testing.T) {
+	ml, _ := newReconcileTestMixerLib(t)
+	// The payout is taken off the ledger but never sent, as if the mixer
+	// crashed in between.
+	ml.postLedger(LedgerEntry{
+		Debit:  userLedgerAccount(reconcileTestUser.DepositAddress),
+		Credit: houseLedgerAccount(testHouseAddress),
+		Amount: 2 * clientlib.Coin,
+	})
+
+	report, err := ml.Reconcile()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Contains(t, report.Discrepancies, Discrepancy{
+		Kind:     MissingPayout,
+		Address:  reconcileTestUser.DepositAddress,
+		Expected: 2 * clientlib.Coin,
+		Actual:   0,
+	})
+}
+
+func TestReconcile_ReportsOverpaymentAndFeeMismatch(t *testing.T) {
+	ml, ledger := newReconcileTestMixerLib(t)
+	ledger.Send(testHouseAddress, reconcileTestUser.ReturnAddresses[0], 3*clientlib.Coin)
+	ml.Store.UpdateProgress(reconcileTestUser.DepositAddress, func(p *DistributionProgress) {
+		p.Fee = 0
+	})
+
+	report, err := ml.Reconcile()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Contains(t, report.Discrepancies, Discrepancy{
+		Kind:     Overpayment,
+		Address:  reconcileTestUser.DepositAddress,
+		Expected: 0,
+		Actual:   3 * clientlib.Coin,
+	})
+	assert.Contains(t, report.Discrepancies, Discrepancy{
+		Kind:     FeeMismatch,
+		Address:  reconcileTestUser.DepositAddress,
+		Expected: 0,
+		Actual:   clientlib.MustParseAmount("0.12"),
+	})
+}
+
+func TestReconcile_FindsNoDiscrepanciesForRefundedDeposit(t *testing.T) {
+	ml, ledger := newReconcileTestMixerLib(t)
+	user := MixerUser{DepositAddress: "5678efgh", ReturnAddresses: []string{"3333cccc"}, RefundAddress: "9999zzzz"}
+	ml.Store.AddUser(user)
+	ml.Config.MaxDeposit = 20 * clientlib.Coin
+	ml.Config.OutOfRangePolicy = jobcoin.RefundDeposits
+	ledger.Create("whale", 50*clientlib.Coin)
+	ledger.Send("whale", user.DepositAddress, 50*clientlib.Coin)
+
+	_, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	report, err := ml.Reconcile()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	for _, d := range report.Discrepancies {
+		assert.NotEqual(t, user.DepositAddress, d.Address, d.String())
+	}
+}
+
+func TestReconcile_ReportsRefundMissingFromProgress(t *testing.T) {
+	ml, ledger := newReconcileTestMixerLib(t)
+	user := MixerUser{DepositAddress: "5678efgh", ReturnAddresses: []string{"3333cccc"}, RefundAddress: "9999zzzz"}
+	ml.Store.AddUser(user)
+	ledger.Create("whale", 50*clientlib.Coin)
+	ledger.Send("whale", user.DepositAddress, 50*clientlib.Coin)
+	ledger.Send(user.DepositAddress, user.RefundAddress, 50*clientlib.Coin)
+
+	report, err := ml.Reconcile()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Contains(t, report.Discrepancies, Discrepancy{
+		Kind:     RefundMismatch,
+		Address:  user.DepositAddress,
+		Expected: 0,
+		Actual:   50 * clientlib.Coin,
+	})
+	assert.NotContains(t, report.Discrepancies, Discrepancy{
+		Kind:     FeeMismatch,
+		Address:  user.DepositAddress,
+		Expected: 0,
+		Actual:   50 * clientlib.Coin,
+	})
+}
+
+func TestDiscrepancyString_DescribesDiscrepancy(t *testing.T) {
+	d := Discrepancy{Kind: UnexpectedInbound, Address: "house", Actual: clientlib.Coin, Detail: "from sender"}
+
+	assert.Equal(t, "unexpected_inbound house: expected 0, found 1 (from sender)", d.String())
+}
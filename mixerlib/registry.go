@@ -0,0 +1,144 @@
+package mixerlib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrInvalidRefundAddress is returned when a user gives one of their return
+// addresses as their refund address, which would link the two, or an address
+// already used by the mixer, which would link them to another user.
+var ErrInvalidRefundAddress = errors.New("Refund address must not be one of the return addresses or an address already in use")
+
+// AddressInUseError is returned when a user tries to register a return
+// address that already belongs to another user, as a return, refund or
+// deposit address.
+type AddressInUseError struct {
+	Address string
+}
+
+func (e *AddressInUseError) Error() string {
+	return fmt.Sprintf("Return address %s is already in use", e.Address)
+}
+
+// Registry is the only place users are registered with the mixer. It is safe
+// to use from multiple goroutines: checking that a user's return and refund
+// addresses are free and registering the user happen as one step, so two users
+// can never claim the same address. Users may only register a webhook URL if
+// WebhooksEnabled is set, and one pointing at a private address if
+// AllowPrivateWebhooks is set, both of which must be done before the Registry
+// is used.
+type Registry struct {
+	WebhooksEnabled      bool
+	AllowPrivateWebhooks bool
+
+	mu       sync.Mutex
+	store    Store
+	reserved map[string]string
+}
+
+// NewRegistry returns a Registry that saves users to the given store. The
+// return, refund and deposit addresses of users already in the store, archived
+// or not, are reserved.
+func NewRegistry(store Store) (*Registry, error) {
+	users, err := store.Users()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := store.ArchivedUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range archived {
+		users = append(users, user.User)
+	}
+
+	r := &Registry{
+		store:    store,
+		reserved: map[string]string{},
+	}
+	for _, user := range users {
+		r.reserve(user)
+	}
+
+	return r, nil
+}
+
+// Register reserves the user's return, refund and deposit addresses and saves
+// the user. If any of the return addresses is already in use, or repeated, an
+// *AddressInUseError is returned and nothing is saved. ErrWebhooksDisabled,
+// ErrInvalidWebhookURL or ErrPrivateWebhookHost is returned if the user's
+// webhook URL cannot be used, and ErrInvalidRefundAddress if their refund
+// address cannot.
+func (r *Registry) Register(user MixerUser) error {
+	if user.WebhookURL != "" {
+		if !r.WebhooksEnabled {
+			return ErrWebhooksDisabled
+		}
+		err := ValidWebhookURL(user.WebhookURL, r.AllowPrivateWebhooks)
+		if err != nil {
+			return err
+		}
+	}
+	if user.RefundAddress != "" {
+		for _, address := range user.ReturnAddresses {
+			if address == user.RefundAddress {
+				return ErrInvalidRefundAddress
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	address, valid := r.validUserAddresses(user.ReturnAddresses)
+	if !valid {
+		return &AddressInUseError{address}
+	}
+	if user.RefundAddress != "" {
+		_, reserved := r.reserved[user.RefundAddress]
+		if reserved || user.RefundAddress == user.DepositAddress {
+			return ErrInvalidRefundAddress
+		}
+	}
+
+	err := r.store.AddUser(user)
+	if err != nil {
+		return err
+	}
+	r.reserve(user)
+
+	return nil
+}
+
+// ValidUserAddresses checks the given addresses against the return, refund and
+// deposit addresses of every registered user. If one is already in use it is
+// returned along with false.
+func (r *Registry) ValidUserAddresses(addresses []string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.validUserAddresses(addresses)
+}
+
+func (r *Registry) validUserAddresses(addresses []string) (string, bool) {
+	seen := map[string]bool{}
+	for _, address := range addresses {
+		if _, reserved := r.reserved[address]; reserved || seen[address] {
+			return address, false
+		}
+		seen[address] = true
+	}
+
+	return "", true
+}
+
+func (r *Registry) reserve(user MixerUser) {
+	for _, address := range user.ReturnAddresses {
+		r.reserved[address] = user.DepositAddress
+	}
+	if user.RefundAddress != "" {
+		r.reserved[user.RefundAddress] = user.DepositAddress
+	}
+	r.reserved[user.DepositAddress] = user.DepositAddress
+}
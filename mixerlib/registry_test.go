@@ -0,0 +1,291 @@
+package mixerlib
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin NewRegistry tests
+func TestNewRegistry_ReservesReturnAddressesOfStoredUsers(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddUser(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+	})
+
+	registry, err := NewRegistry(store)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	badAddress, valid := registry.ValidUserAddresses([]string{"return-one"})
+
+	assert.False(t, valid)
+	assert.Equal(t, "return-one", badAddress)
+}
+
+func TestNewRegistry_ReservesRefundAndDepositAddressesOfStoredUsers(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddUser(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+		RefundAddress:   "refund-one",
+	})
+
+	registry, err := NewRegistry(store)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	_, refundValid := registry.ValidUserAddresses([]string{"refund-one"})
+	_, depositValid := registry.ValidUserAddresses([]string{"deposit-one"})
+
+	assert.False(t, refundValid)
+	assert.False(t, depositValid)
+}
+
+func TestNewRegistry_ReservesReturnAddressesOfArchivedUsers(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddUser(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+	})
+	store.ArchiveSettledUser("deposit-one")
+
+	registry, err := NewRegistry(store)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	_, valid := registry.ValidUserAddresses([]string{"return-one"})
+
+	assert.False(t, valid)
+}
+
+// Begin Register tests
+func TestRegister_SavesUserToStore(t *testing.T) {
+	store := NewMemoryStore()
+	registry, _ := NewRegistry(store)
+	user := MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one", "return-two"},
+	}
+
+	err := registry.Register(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	users, _ := store.Users()
+	assert.Equal(t, []MixerUser{user}, users)
+}
+
+func TestRegister_ReturnsAddressInUseErrorIfAddressTaken(t *testing.T) {
+	store := NewMemoryStore()
+	registry, _ := NewRegistry(store)
+	registry.Register(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one", "return-two"},
+	})
+
+	err := registry.Register(MixerUser{
+		DepositAddress:  "deposit-two",
+		ReturnAddresses: []string{"return-three", "return-two"},
+	})
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	users, _ := store.Users()
+	assert.Equal(t, &AddressInUseError{"return-two"}, err)
+	assert.Equal(t, "Return address return-two is already in use", err.Error())
+	assert.Equal(t, 1, len(users))
+
+	// The address from the rejected user must not have been reserved
+	_, valid := registry.ValidUserAddresses([]string{"return-three"})
+	assert.True(t, valid)
+}
+
+func TestRegister_ReturnsAddressInUseErrorIfAddressRepeated(t *testing.T) {
+	registry, _ := NewRegistry(NewMemoryStore())
+
+	err := registry.Register(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one", "return-one"},
+	})
+
+	assert.Equal(t, &AddressInUseError{"return-one"}, err)
+}
+
+func TestRegister_RejectsWebhookURLIfWebhooksDisabled(t *testing.T) {
+	store := NewMemoryStore()
+	registry, _ := NewRegistry(store)
+
+	err := registry.Register(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+		WebhookURL:      "https://example.com/hooks/mixer",
+	})
+
+	users, _ := store.Users()
+	assert.Equal(t, ErrWebhooksDisabled, err)
+	assert.Equal(t, 0, len(users))
+}
+
+func TestRegister_RejectsInvalidWebhookURL(t *testing.T) {
+	registry, _ := NewRegistry(NewMemoryStore())
+	registry.WebhooksEnabled = true
+
+	err := registry.Register(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+		WebhookURL:      "example.com/hooks/mixer",
+	})
+
+	assert.Equal(t, ErrInvalidWebhookURL, err)
+}
+
+func TestRegister_RejectsReturnAddressAsRefundAddress(t *testing.T) {
+	registry, _ := NewRegistry(NewMemoryStore())
+
+	err := registry.Register(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one", "return-two"},
+		RefundAddress:   "return-two",
+	})
+
+	assert.Equal(t, ErrInvalidRefundAddress, err)
+}
+
+func TestRegister_RejectsRefundAddressTakenByAnotherUser(t *testing.T) {
+	store := NewMemoryStore()
+	registry, _ := NewRegistry(store)
+	registry.Register(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+		RefundAddress:   "refund-one",
+	})
+
+	for _, refundAddress := range []string{"return-one", "refund-one", "deposit-one"} {
+		err := registry.Register(MixerUser{
+			DepositAddress:  "deposit-two",
+			ReturnAddresses: []string{"return-two"},
+			RefundAddress:   refundAddress,
+		})
+		assert.Equal(t, ErrInvalidRefundAddress, err)
+	}
+
+	users, _ := store.Users()
+	assert.Equal(t, 1, len(users))
+}
+
+func TestRegister_ReturnsAddressInUseErrorIfAddressIsAnotherUsersRefundAddress(t *testing.T) {
+	registry, _ := NewRegistry(NewMemoryStore())
+	registry.Register(MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+		RefundAddress:   "refund-one",
+	})
+
+	err := registry.Register(MixerUser{
+		DepositAddress:  "deposit-two",
+		ReturnAddresses: []string{"refund-one"},
+	})
+
+	assert.Equal(t, &AddressInUseError{"refund-one"}, err)
+}
+
+func TestRegister_SavesWebhookURLIfWebhooksEnabled(t *testing.T) {
+	store := NewMemoryStore()
+	registry, _ := NewRegistry(store)
+	registry.WebhooksEnabled = true
+	user := MixerUser{
+		DepositAddress:  "deposit-one",
+		ReturnAddresses: []string{"return-one"},
+		WebhookURL:      "https://example.com/hooks/mixer",
+	}
+
+	err := registry.Register(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	saved, _ := store.User("deposit-one")
+	assert.Equal(t, user, saved)
+}
+
+func TestRegister_OnlyOneConcurrentUserClaimsAnAddress(t *testing.T) {
+	store := NewMemoryStore()
+	registry, _ := NewRegistry(store)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			registry.Register(MixerUser{
+				DepositAddress:  fmt.Sprintf("deposit-%d", i),
+				ReturnAddresses: []string{fmt.Sprintf("return-%d", i), "shared-return"},
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	users, _ := store.Users()
+	assert.Equal(t, 1, len(users))
+}
+
+// Begin ValidUserAddresses tests
+func TestValidUserAddresses_ReturnsTrueIfAllUniqueAddresses(t *testing.T) {
+	registry, _ := NewRegistry(NewMemoryStore())
+	registry.Register(MixerUser{
+		DepositAddress: "deposit-one",
+		ReturnAddresses: []string{
+			"return-one",
+			"return-two",
+		},
+	})
+	registry.Register(MixerUser{
+		DepositAddress: "deposit-two",
+		ReturnAddresses: []string{
+			"return-three",
+			"return-four",
+			"return-five",
+		},
+	})
+
+	newAddresses := []string{"return-six", "return-seven"}
+
+	_, valid := registry.ValidUserAddresses(newAddresses)
+
+	assert.True(t, valid)
+}
+
+func TestValidUserAddresses_ReturnsFalseAndCulpritIfNotUnique(t *testing.T) {
+	registry, _ := NewRegistry(NewMemoryStore())
+	registry.Register(MixerUser{
+		DepositAddress: "deposit-one",
+		ReturnAddresses: []string{
+			"return-one",
+			"return-two",
+		},
+	})
+	registry.Register(MixerUser{
+		DepositAddress: "deposit-two",
+		ReturnAddresses: []string{
+			"return-three",
+			"return-four",
+			"return-five",
+		},
+	})
+
+	newAddresses := []string{"return-six", "return-five"}
+
+	badAddress, valid := registry.ValidUserAddresses(newAddresses)
+
+	assert.False(t, valid)
+	assert.Equal(t, "return-five", badAddress)
+}
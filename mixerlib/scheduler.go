@@ -0,0 +1,306 @@
+package mixerlib
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// ScheduledPayout is a single return of funds from the house to one of a
+// user's return addresses, to be sent once DueAt has passed.
+//
+// A payout whose send failed in a way that may still have created the
+// transaction is Unconfirmed. It stays taken off the user's ledger balance and
+// is checked against the transaction history of House before it is sent again.
+type ScheduledPayout struct {
+	ToAddress   string           `json:"toAddress"`
+	Amount      clientlib.Amount `json:"amount"`
+	DueAt       time.Time        `json:"dueAt"`
+	House       string           `json:"house,omitempty"`
+	Unconfirmed bool             `json:"unconfirmed,omitempty"`
+}
+
+// schedulePayouts splits balance into payouts to the user's return addresses,
+// gives each a random time to be sent and saves them. The balance is divided
+// into rounds sized by the amount strategy, each split randomly between the
+// return addresses, and the payouts are then shuffled so their order says
+// nothing about the rounds.
+func (ml *MixerLib) schedulePayouts(user MixerUser, balance clientlib.Amount) ([]ScheduledPayout, error) {
+	strategy := ml.amountStrategy()
+	payouts := []ScheduledPayout{}
+	for remaining := balance; remaining > 0; {
+		chunk := strategy.NextAmount(remaining)
+		if chunk <= 0 || chunk > remaining {
+			chunk = remaining
+		}
+		for address, amount := range ml.assignReturnAmounts(user.ReturnAddresses, chunk) {
+			payouts = append(payouts, ScheduledPayout{ToAddress: address, Amount: amount})
+		}
+		remaining = remaining - chunk
+	}
+	rand.Shuffle(len(payouts), func(i, j int) {
+		payouts[i], payouts[j] = payouts[j], payouts[i]
+	})
+
+	now := time.Now()
+	for i, delay := range payoutDelays(ml.Config, len(payouts)) {
+		payouts[i].DueAt = now.Add(delay)
+	}
+
+	err := ml.Store.SavePayouts(user.DepositAddress, payouts)
+	if err != nil {
+		return nil, err
+	}
+	ml.userLogger(user.DepositAddress).Info("Scheduled payouts", "payouts", len(payouts), "amount", balance)
+
+	return payouts, nil
+}
+
+func (ml *MixerLib) amountStrategy() AmountStrategy {
+	if ml.Amounts != nil {
+		return ml.Amounts
+	}
+	return NewAmountStrategy(ml.Config)
+}
+
+// sendDuePayouts sends every payout whose time has come, each from any house
+// address holding enough, and returns true if none are left afterwards. Each
+// payout is removed from the schedule before it is sent so that it can never be
+// sent twice, and put back if the send fails. An unconfirmed payout is only
+// sent again once its house's history shows it never went through. If the
+// Jobcoin API circuit breaker is open, the payouts not yet sent are put back
+// and ErrCircuitOpen is returned, so the return poller can pause.
+func (ml *MixerLib) sendDuePayouts(user MixerUser, payouts []ScheduledPayout) (bool, error) {
+	now := time.Now()
+	due := []ScheduledPayout{}
+	remaining := []ScheduledPayout{}
+	for _, payout := range payouts {
+		if payout.DueAt.After(now) {
+			remaining = append(remaining, payout)
+		} else {
+			due = append(due, payout)
+		}
+	}
+	if len(due) == 0 {
+		return len(remaining) == 0, nil
+	}
+
+	for i, payout := range due {
+		unsent := append(append([]ScheduledPayout{}, remaining...), due[i+1:]...)
+		err := ml.Store.SavePayouts(user.DepositAddress, unsent)
+		if err != nil {
+			return false, err
+		}
+
+		sent := false
+		if payout.Unconfirmed {
+			sent, err = ml.confirmPayout(user, payout)
+			if errors.Is(err, clientlib.ErrCircuitOpen) {
+				return false, ml.putBackPayouts(user, append(append(remaining, payout), due[i+1:]...), err)
+			}
+			if err != nil {
+				ml.userLogger(user.DepositAddress).Warn("Failed to check whether payout was sent, it will be checked again", "amount", payout.Amount, "to", payout.ToAddress, "house", payout.House, "error", err)
+				remaining = append(remaining, payout)
+				continue
+			}
+			payout.House, payout.Unconfirmed = "", false
+		}
+
+		if !sent {
+			// Only a failed send from the house may have created the
+			// transaction. Failing to pick a house sends nothing.
+			unconfirmed := false
+			house, err := ml.payoutHouse(payout.Amount)
+			if err == nil {
+				err = ml.sendPayout(user, house, payout)
+				unconfirmed = mayHaveBeenSent(err)
+			}
+			if errors.Is(err, clientlib.ErrCircuitOpen) {
+				return false, ml.putBackPayouts(user, append(append(remaining, payout), due[i+1:]...), err)
+			}
+			if err != nil {
+				ml.userLogger(user.DepositAddress).Error("Failed to send payout", "amount", payout.Amount, "to", payout.ToAddress, "error", err)
+				ml.Metrics.payoutFailed()
+				ml.publish(Event{
+					Kind:           EventError,
+					DepositAddress: user.DepositAddress,
+					Amount:         payout.Amount,
+					ToAddress:      payout.ToAddress,
+					Message:        "Failed to send payout, it will be retried",
+				})
+				if unconfirmed {
+					payout.House, payout.Unconfirmed = house, true
+				}
+				remaining = append(remaining, payout)
+				continue
+			}
+		}
+		ml.Metrics.payoutSent(payout.Amount)
+		ml.publish(Event{Kind: EventPayoutSent, DepositAddress: user.DepositAddress, Amount: payout.Amount, ToAddress: payout.ToAddress})
+
+		ml.recordProgress(user.DepositAddress, func(p *DistributionProgress) {
+			p.Returned = p.Returned + payout.Amount
+			p.ReturnedTo[payout.ToAddress] = p.ReturnedTo[payout.ToAddress] + payout.Amount
+			p.State = StateDistributing
+			p.Rounds++
+			p.LastPayout = time.Now()
+		})
+	}
+
+	err := ml.Store.SavePayouts(user.DepositAddress, remaining)
+	if err != nil {
+		return false, err
+	}
+	return len(remaining) == 0, nil
+}
+
+// putBackPayouts saves payouts as the user's schedule and returns err, or the
+// error saving them.
+func (ml *MixerLib) putBackPayouts(user MixerUser, payouts []ScheduledPayout, err error) error {
+	saveErr := ml.Store.SavePayouts(user.DepositAddress, payouts)
+	if saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// sendPayout sends the payout from the given house address. Like the payout
+// is taken off the schedule, it is taken off the user's ledger balance before
+// it is sent, and put back if the send fails. A send that may still have
+// created the transaction is left off the ledger balance until confirmPayout
+// has checked. Nothing is posted while the Jobcoin API circuit breaker is
+// open; ErrCircuitOpen is returned instead.
+func (ml *MixerLib) sendPayout(user MixerUser, house string, payout ScheduledPayout) error {
+	if ml.Breaker.Open() {
+		return clientlib.ErrCircuitOpen
+	}
+	err := ml.postLedger(payoutLedgerEntry(user, house, payout))
+	if err != nil {
+		return err
+	}
+
+	err = ml.JobcoinClient.SendJobcoin(house, payout.ToAddress, payout.Amount)
+	if err != nil && !mayHaveBeenSent(err) {
+		ml.reversePayout(user, house, payout)
+	}
+	return err
+}
+
+// confirmPayout checks the history of the unconfirmed payout's house for its
+// transaction, and returns true if it went through. Every payout of the same
+// amount from the house to the same address is posted to the ledger, so the
+// payout went through if the history has at least as many such transactions as
+// the ledger. Otherwise it is put back on the user's ledger balance.
+func (ml *MixerLib) confirmPayout(user MixerUser, payout ScheduledPayout) (bool, error) {
+	info, err := ml.JobcoinClient.GetAddressInfo(payout.House)
+	if err != nil {
+		return false, err
+	}
+	entries, err := ml.Store.LedgerEntries()
+	if err != nil {
+		return false, err
+	}
+
+	sent := 0
+	for _, tx := range info.Transactions {
+		if tx.FromAddress == payout.House && tx.ToAddress == payout.ToAddress && tx.Amount == payout.Amount {
+			sent++
+		}
+	}
+	posted := 0
+	entry := payoutLedgerEntry(user, payout.House, payout)
+	for _, e := range entries {
+		if e.Amount != entry.Amount {
+			continue
+		}
+		if e.Debit == entry.Debit && e.Credit == entry.Credit && e.Memo == entry.Memo {
+			posted++
+		} else if e.Debit == entry.Credit && e.Credit == entry.Debit && e.Memo == "failed "+entry.Memo {
+			posted--
+		}
+	}
+
+	if sent >= posted {
+		ml.userLogger(user.DepositAddress).Info("Found unconfirmed payout in house history", "amount", payout.Amount, "to", payout.ToAddress, "house", payout.House)
+		return true, nil
+	}
+	ml.reversePayout(user, payout.House, payout)
+	return false, nil
+}
+
+// payoutLedgerEntry is the ledger entry taking payout off the user's balance.
+func payoutLedgerEntry(user MixerUser, house string, payout ScheduledPayout) LedgerEntry {
+	return LedgerEntry{
+		Debit:          userLedgerAccount(user.DepositAddress),
+		Credit:         houseLedgerAccount(house),
+		Amount:         payout.Amount,
+		DepositAddress: user.DepositAddress,
+		Memo:           "payout to " + payout.ToAddress,
+	}
+}
+
+// reversePayout puts a payout that was not sent back on the user's ledger
+// balance. A failure to post is logged, and will show up when the ledger is
+// next reconciled.
+func (ml *MixerLib) reversePayout(user MixerUser, house string, payout ScheduledPayout) {
+	entry := payoutLedgerEntry(user, house, payout)
+	entry.Debit, entry.Credit = entry.Credit, entry.Debit
+	entry.Memo = "failed " + entry.Memo
+	err := ml.postLedger(entry)
+	if err != nil {
+		ml.userLogger(user.DepositAddress).Error("Failed to reverse payout in ledger", "amount", payout.Amount, "to", payout.ToAddress, "error", err)
+	}
+}
+
+// mayHaveBeenSent reports whether a transaction that failed with err may still
+// have been created: after a network error or 5xx response, but not a 429.
+func mayHaveBeenSent(err error) bool {
+	var apiErr *clientlib.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+		return false
+	}
+	return errors.Is(err, clientlib.ErrTransient)
+}
+
+// payoutDelays returns n delays in increasing order, all between
+// PayoutMinDelay and PayoutMaxDelay, drawn from the configured distribution.
+//
+// With the exponential distribution the gaps between payouts are drawn with a
+// mean of PayoutMeanInterval, like the arrival of unrelated transactions. If
+// they add up to more than the window allows they are scaled down to fit it.
+// With the uniform distribution each delay is drawn independently from the window.
+func payoutDelays(config jobcoin.MixerConfig, n int) []time.Duration {
+	window := float64(config.PayoutMaxDelay - config.PayoutMinDelay)
+	offsets := make([]float64, n)
+
+	switch config.PayoutDistribution {
+	case jobcoin.UniformDistribution:
+		for i := range offsets {
+			offsets[i] = rand.Float64() * window
+		}
+		sort.Float64s(offsets)
+	default:
+		elapsed := 0.0
+		for i := range offsets {
+			elapsed = elapsed + rand.ExpFloat64()*float64(config.PayoutMeanInterval)
+			offsets[i] = elapsed
+		}
+		if elapsed > window {
+			for i := range offsets {
+				offsets[i] = offsets[i] * window / elapsed
+			}
+		}
+	}
+
+	delays := make([]time.Duration, n)
+	for i, offset := range offsets {
+		delays[i] = config.PayoutMinDelay.Std() + time.Duration(math.Round(offset))
+	}
+	return delays
+}
@@ -0,0 +1,223 @@
+package mixerlib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin schedulePayouts tests
+func TestSchedulePayouts_PayoutsSumToBalance(t *testing.T) {
+	user := MixerUser{
+		DepositAddress:  "1234abcd",
+		ReturnAddresses: []string{"1111aaaa", "2222bbbb", "3333cccc"},
+	}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	balance := clientlib.MustParseAmount("23.456")
+
+	payouts, err := ml.schedulePayouts(user, balance)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	var total clientlib.Amount
+	for _, payout := range payouts {
+		assert.Contains(t, user.ReturnAddresses, payout.ToAddress)
+		assert.True(t, payout.Amount <= ml.Config.MaxPayoutAmount)
+		total = total + payout.Amount
+	}
+	saved, _ := ml.Store.Payouts(user.DepositAddress)
+
+	assert.Equal(t, balance, total)
+	assert.Equal(t, len(payouts), len(saved))
+}
+
+func TestSchedulePayouts_PayoutsAreDueWithinDelayWindow(t *testing.T) {
+	user := MixerUser{
+		DepositAddress:  "1234abcd",
+		ReturnAddresses: []string{"1111aaaa", "2222bbbb"},
+	}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Config.PayoutMinDelay = jobcoin.Duration(time.Minute)
+	ml.Config.PayoutMaxDelay = jobcoin.Duration(time.Hour)
+
+	before := time.Now()
+	payouts, _ := ml.schedulePayouts(user, 50*clientlib.Coin)
+
+	for _, payout := range payouts {
+		assert.False(t, payout.DueAt.Before(before.Add(time.Minute)))
+		assert.False(t, payout.DueAt.After(time.Now().Add(time.Hour)))
+	}
+}
+
+// Begin sendDuePayouts tests
+func TestSendDuePayouts_OnlySendsPayoutsThatAreDue(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+	later := ScheduledPayout{ToAddress: "2222bbbb", Amount: 2 * clientlib.Coin, DueAt: time.Now().Add(time.Hour)}
+
+	allSent, err := ml.sendDuePayouts(user, []ScheduledPayout{due, later})
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedTxs := []clientlib.JobcoinTx{
+		{FromAddress: testHouseAddress, ToAddress: "1111aaaa", Amount: clientlib.Coin},
+	}
+	remaining, _ := ml.Store.Payouts(user.DepositAddress)
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+
+	assert.False(t, allSent)
+	assert.Equal(t, expectedTxs, jobcoinMock.Sent)
+	assert.Equal(t, []ScheduledPayout{later}, remaining)
+	assert.Equal(t, clientlib.Coin, progress.ReturnedTo["1111aaaa"])
+}
+
+func TestSendDuePayouts_KeepsPayoutsThatFailToSend(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, errors.New("SendJobcoin failed")))
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	allSent, err := ml.sendDuePayouts(user, []ScheduledPayout{due})
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	remaining, _ := ml.Store.Payouts(user.DepositAddress)
+
+	assert.False(t, allSent)
+	assert.Equal(t, []ScheduledPayout{due}, remaining)
+}
+
+func TestSendDuePayouts_DoesNotResendPayoutFoundInHouseHistory(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, &clientlib.APIError{StatusCode: 502, Message: "Bad Gateway"}).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	creditUser(ml, user.DepositAddress, clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+	unconfirmed, _ := ml.Store.Payouts(user.DepositAddress)
+	assert.Equal(t, []ScheduledPayout{{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: due.DueAt, House: testHouseAddress, Unconfirmed: true}}, unconfirmed)
+
+	// The payout was created even though the client saw an error.
+	jobcoinMock.SendError = nil
+	jobcoinMock.AddressInfos = map[string]clientlib.JobcoinAddressInfo{
+		testHouseAddress: {Transactions: []clientlib.JobcoinTx{
+			{FromAddress: testHouseAddress, ToAddress: "1111aaaa", Amount: clientlib.Coin},
+		}},
+	}
+	allSent, err := ml.sendDuePayouts(user, unconfirmed)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	balance, _ := ml.userHouseBalance(user.DepositAddress)
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+	assert.True(t, allSent)
+	assert.Empty(t, jobcoinMock.Sent)
+	assert.Equal(t, clientlib.Amount(0), balance)
+	assert.Equal(t, clientlib.Coin, progress.ReturnedTo["1111aaaa"])
+}
+
+func TestSendDuePayouts_PutsPayoutBackUnchangedIfNoHouseCouldBePicked(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newTestPoolMock(map[string]clientlib.Amount{testHouseAddress: clientlib.Coin, "pool-house": 0})
+	jobcoinMock.GetAddressError = &clientlib.APIError{StatusCode: 502, Message: "Bad Gateway"}
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Pool = []string{"pool-house"}
+	creditUser(ml, user.DepositAddress, clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+	unsent, _ := ml.Store.Payouts(user.DepositAddress)
+	assert.Equal(t, []ScheduledPayout{due}, unsent)
+
+	jobcoinMock.GetAddressError = nil
+	allSent, err := ml.sendDuePayouts(user, unsent)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	balance, _ := ml.userHouseBalance(user.DepositAddress)
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+	assert.True(t, allSent)
+	assert.Equal(t, []clientlib.JobcoinTx{{FromAddress: testHouseAddress, ToAddress: "1111aaaa", Amount: clientlib.Coin}}, jobcoinMock.Sent)
+	assert.Equal(t, clientlib.Amount(0), balance)
+	assert.Equal(t, clientlib.Coin, progress.Returned)
+}
+
+func TestSendDuePayouts_ResendsUnconfirmedPayoutMissingFromHouseHistory(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, &clientlib.APIError{StatusCode: 502, Message: "Bad Gateway"}).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	creditUser(ml, user.DepositAddress, clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+	unconfirmed, _ := ml.Store.Payouts(user.DepositAddress)
+	jobcoinMock.SendError = nil
+	allSent, err := ml.sendDuePayouts(user, unconfirmed)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedTxs := []clientlib.JobcoinTx{
+		{FromAddress: testHouseAddress, ToAddress: "1111aaaa", Amount: clientlib.Coin},
+	}
+	balance, _ := ml.userHouseBalance(user.DepositAddress)
+	assert.True(t, allSent)
+	assert.Equal(t, expectedTxs, jobcoinMock.Sent)
+	assert.Equal(t, clientlib.Amount(0), balance)
+}
+
+func TestMayHaveBeenSent_OnlyForErrorsAfterTheRequestWasMade(t *testing.T) {
+	assert.True(t, mayHaveBeenSent(&clientlib.APIError{StatusCode: 503}))
+	assert.False(t, mayHaveBeenSent(&clientlib.APIError{StatusCode: 429}))
+	assert.False(t, mayHaveBeenSent(&clientlib.APIError{StatusCode: 422, Message: "Insufficient Funds"}))
+	assert.False(t, mayHaveBeenSent(clientlib.ErrCircuitOpen))
+}
+
+// Begin payoutDelays tests
+func TestPayoutDelays_ExponentialDelaysAreIncreasingAndWithinWindow(t *testing.T) {
+	config := jobcoin.DefaultConfig().Mixer
+	config.PayoutDistribution = jobcoin.ExponentialDistribution
+	config.PayoutMinDelay = jobcoin.Duration(time.Minute)
+	config.PayoutMaxDelay = jobcoin.Duration(10 * time.Minute)
+	config.PayoutMeanInterval = jobcoin.Duration(time.Minute)
+
+	// 100 gaps averaging a minute will not fit into nine minutes, so they are scaled down.
+	delays := payoutDelays(config, 100)
+
+	assert.Equal(t, 100, len(delays))
+	for i, delay := range delays {
+		assert.True(t, delay >= time.Minute)
+		assert.True(t, delay <= 10*time.Minute)
+		if i > 0 {
+			assert.True(t, delay >= delays[i-1])
+		}
+	}
+}
+
+func TestPayoutDelays_UniformDelaysAreIncreasingAndWithinWindow(t *testing.T) {
+	config := jobcoin.DefaultConfig().Mixer
+	config.PayoutDistribution = jobcoin.UniformDistribution
+	config.PayoutMinDelay = jobcoin.Duration(time.Minute)
+	config.PayoutMaxDelay = jobcoin.Duration(2 * time.Minute)
+
+	delays := payoutDelays(config, 50)
+
+	for i, delay := range delays {
+		assert.True(t, delay >= time.Minute)
+		assert.True(t, delay <= 2*time.Minute)
+		if i > 0 {
+			assert.True(t, delay >= delays[i-1])
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package mixerlib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// ErrUserNotFound is returned when no user is registered with a deposit address.
+var ErrUserNotFound = errors.New("User not found")
+
+// UserState describes where a user's funds are in the mixing process.
+type UserState string
+
+// The states a user moves through, in order. A user who makes another
+// deposit after completing goes back to StateReceived. A user still awaiting
+// a deposit once their deposit address expires moves to StateExpired, and
+// from there to StateReceived if they deposit after all.
+const (
+	StateAwaitingDeposit UserState = "awaiting_deposit"
+	StateReceived        UserState = "received"
+	StateInHouse         UserState = "in_house"
+	StateDistributing    UserState = "distributing"
+	StateComplete        UserState = "complete"
+	StateExpired         UserState = "expired"
+)
+
+// DistributionProgress records what the mixer has done with a user's funds:
+// how much was deposited, the fee taken and how much has been returned to each
+// return address so far. Held is what is being held in the deposit address for
+// being outside the deposit limits, and HoldReason why. Refunded is the total
+// of the deposits refunded for the same reason.
+type DistributionProgress struct {
+	DepositAddress string                      `json:"depositAddress"`
+	State          UserState                   `json:"state"`
+	Deposited      clientlib.Amount            `json:"deposited"`
+	Fee            clientlib.Amount            `json:"fee"`
+	Returned       clientlib.Amount            `json:"returned"`
+	ReturnedTo     map[string]clientlib.Amount `json:"returnedTo"`
+	Rounds         int                         `json:"rounds"`
+	LastPayout     time.Time                   `json:"lastPayout"`
+	CompletedAt    time.Time                   `json:"completedAt"`
+	Held           clientlib.Amount            `json:"held,omitempty"`
+	HoldReason     string                      `json:"holdReason,omitempty"`
+	Refunded       clientlib.Amount            `json:"refunded,omitempty"`
+}
+
+func newDistributionProgress(depositAddress string) DistributionProgress {
+	return DistributionProgress{
+		DepositAddress: depositAddress,
+		State:          StateAwaitingDeposit,
+		ReturnedTo:     map[string]clientlib.Amount{},
+	}
+}
+
+// Remaining is the amount still to be returned to the user.
+func (p DistributionProgress) Remaining() clientlib.Amount {
+	return p.Deposited - p.Fee - p.Returned
+}
+
+func (p DistributionProgress) copy() DistributionProgress {
+	returnedTo := map[string]clientlib.Amount{}
+	for address, amount := range p.ReturnedTo {
+		returnedTo[address] = amount
+	}
+	p.ReturnedTo = returnedTo
+	return p
+}
+
+// DepositLimits are the limits on a user's deposits. Each is left out if
+// there is no such limit. Remaining is how much more the user may deposit in
+// all.
+type DepositLimits struct {
+	Min       clientlib.Amount  `json:"min,omitempty"`
+	Max       clientlib.Amount  `json:"max,omitempty"`
+	Remaining *clientlib.Amount `json:"remaining,omitempty"`
+}
+
+// UserStatus is a summary of a user's progress through the mixer. Held and
+// HoldReason describe any deposit being held for being outside Limits, and
+// Refunded is the total refunded for the same reason.
+type UserStatus struct {
+	DepositAddress      string                      `json:"depositAddress"`
+	State               UserState                   `json:"state"`
+	Deposited           clientlib.Amount            `json:"deposited"`
+	Fee                 clientlib.Amount            `json:"fee"`
+	Remaining           clientlib.Amount            `json:"remaining"`
+	Returned            map[string]clientlib.Amount `json:"returned"`
+	EstimatedCompletion *time.Time                  `json:"estimatedCompletion,omitempty"`
+	ExpiresAt           *time.Time                  `json:"expiresAt,omitempty"`
+	Held                clientlib.Amount            `json:"held,omitempty"`
+	HoldReason          string                      `json:"holdReason,omitempty"`
+	Refunded            clientlib.Amount            `json:"refunded,omitempty"`
+	Limits              DepositLimits               `json:"limits"`
+}
+
+// UserStatus returns the status of the user with the given deposit address,
+// or ErrUserNotFound. It only reads the mixer's own state, so no Jobcoin
+// API calls are made.
+func (ml *MixerLib) UserStatus(depositAddress string) (UserStatus, error) {
+	user, err := ml.Store.User(depositAddress)
+	if err != nil {
+		return UserStatus{}, err
+	}
+
+	progress, err := ml.Store.Progress(depositAddress)
+	if err != nil {
+		return UserStatus{}, err
+	}
+
+	status := UserStatus{
+		DepositAddress: user.DepositAddress,
+		State:          progress.State,
+		Deposited:      progress.Deposited,
+		Fee:            progress.Fee,
+		Remaining:      progress.Remaining(),
+		Returned:       map[string]clientlib.Amount{},
+		Held:           progress.Held,
+		HoldReason:     progress.HoldReason,
+		Refunded:       progress.Refunded,
+		Limits:         ml.depositLimits(progress),
+	}
+	for _, address := range user.ReturnAddresses {
+		status.Returned[address] = progress.ReturnedTo[address]
+	}
+
+	if expiresAt, expires := ml.expiresAt(user); expires && progress.State == StateAwaitingDeposit {
+		status.ExpiresAt = &expiresAt
+	}
+	if progress.State == StateInHouse || progress.State == StateDistributing {
+		estimate, err := ml.estimateCompletion(depositAddress)
+		if err != nil {
+			return UserStatus{}, err
+		}
+		status.EstimatedCompletion = &estimate
+	}
+
+	return status, nil
+}
+
+// estimateCompletion returns when the user's last scheduled payout is due. If
+// nothing is scheduled yet their payouts will be scheduled on the next house
+// poll, so the latest they can finish is one poll and the longest payout delay away.
+func (ml *MixerLib) estimateCompletion(depositAddress string) (time.Time, error) {
+	payouts, err := ml.Store.Payouts(depositAddress)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(payouts) == 0 {
+		return time.Now().Add(ml.Config.HousePollInterval.Std() + ml.Config.PayoutMaxDelay.Std()), nil
+	}
+	return payouts[len(payouts)-1].DueAt, nil
+}
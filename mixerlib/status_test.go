@@ -0,0 +1,139 @@
+package mixerlib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin"
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin UserStatus tests
+func TestUserStatus_ReturnsErrorIfUserNotFound(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+
+	_, err := ml.UserStatus("1234abcd")
+
+	assert.Equal(t, ErrUserNotFound, err)
+}
+
+func TestUserStatus_AwaitingDepositForNewUser(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Store.AddUser(MixerUser{
+		DepositAddress:  "1234abcd",
+		ReturnAddresses: []string{"1111aaaa", "2222bbbb"},
+	})
+
+	status, err := ml.UserStatus("1234abcd")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedStatus := UserStatus{
+		DepositAddress: "1234abcd",
+		State:          StateAwaitingDeposit,
+		Returned: map[string]clientlib.Amount{
+			"1111aaaa": 0,
+			"2222bbbb": 0,
+		},
+		Limits: DepositLimits{Min: ml.Config.MinDeposit},
+	}
+	assert.Equal(t, expectedStatus, status)
+}
+
+func TestUserStatus_ReportsWhenDepositAddressExpires(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	registeredAt := time.Now()
+	ml.Store.AddUser(MixerUser{DepositAddress: "1234abcd", RegisteredAt: registeredAt})
+
+	status, err := ml.UserStatus("1234abcd")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, registeredAt.Add(ml.Config.DepositTTL.Std()), *status.ExpiresAt)
+}
+
+func TestUserStatus_ReportsDepositAndFeeOnceInHouse(t *testing.T) {
+	user := MixerUser{
+		DepositAddress:  "1234abcd",
+		ReturnAddresses: []string{"1111aaaa", "2222bbbb"},
+	}
+	mockAddressInfo := clientlib.JobcoinAddressInfo{
+		Balance: 12 * clientlib.Coin,
+	}
+	ml := newTestMixerLib(newJobcoinMock(mockAddressInfo, nil, nil))
+	ml.Store.AddUser(user)
+
+	ml.transferDepositToHouse(user)
+
+	status, err := ml.UserStatus(user.DepositAddress)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, StateInHouse, status.State)
+	assert.Equal(t, 12*clientlib.Coin, status.Deposited)
+	assert.Equal(t, clientlib.MustParseAmount("0.12"), status.Fee)
+	assert.Equal(t, clientlib.MustParseAmount("11.88"), status.Remaining)
+	assert.NotNil(t, status.EstimatedCompletion)
+}
+
+func TestUserStatus_ReportsAmountReturnedToEachAddress(t *testing.T) {
+	user := MixerUser{
+		DepositAddress:  "1234abcd",
+		ReturnAddresses: []string{"1111aaaa", "2222bbbb"},
+	}
+	mock := newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(mock)
+	ml.Store.AddUser(user)
+	creditUser(ml, user.DepositAddress, 8*clientlib.Coin)
+	ml.Store.UpdateProgress(user.DepositAddress, func(p *DistributionProgress) {
+		p.State = StateInHouse
+		p.Deposited = 8 * clientlib.Coin
+	})
+
+	ml.returnFundsToUser(user)
+
+	status, err := ml.UserStatus(user.DepositAddress)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedReturned := map[string]clientlib.Amount{}
+	for _, tx := range mock.Sent {
+		expectedReturned[tx.ToAddress] = expectedReturned[tx.ToAddress] + tx.Amount
+	}
+	assert.Equal(t, StateDistributing, status.State)
+	assert.Equal(t, expectedReturned, status.Returned)
+	assert.Equal(t, clientlib.Amount(0), status.Remaining)
+}
+
+// Begin estimateCompletion tests
+func TestEstimateCompletion_ReturnsWhenLastPayoutIsDue(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	lastDue := time.Now().Add(time.Hour)
+	ml.Store.SavePayouts("1234abcd", []ScheduledPayout{
+		{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: lastDue},
+		{ToAddress: "2222bbbb", Amount: clientlib.Coin, DueAt: time.Now()},
+	})
+
+	estimate, err := ml.estimateCompletion("1234abcd")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, lastDue, estimate)
+}
+
+func TestEstimateCompletion_AllowsForLongestDelayIfNothingScheduled(t *testing.T) {
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Config.PayoutMaxDelay = jobcoin.Duration(10 * time.Minute)
+
+	before := time.Now()
+	estimate, _ := ml.estimateCompletion("1234abcd")
+
+	expected := ml.Config.HousePollInterval.Std() + 10*time.Minute
+	assert.WithinDuration(t, before.Add(expected), estimate, time.Second)
+}
@@ -0,0 +1,1172 @@
+package mixerlib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// ErrReadOnlyStore is returned when changing a store opened read-only.
+var ErrReadOnlyStore = errors.New("State is open read-only")
+
+// Store is an interface representing the state the mixer needs to keep
+// track of in order to survive a restart without stranding user funds.
+type Store interface {
+	Users() ([]MixerUser, error)
+	User(depositAddress string) (MixerUser, error)
+	AddUser(user MixerUser) error
+	ArchivedUsers() ([]ArchivedUser, error)
+	ArchiveSettledUser(depositAddress string) (bool, error)
+	RestoreArchivedUser(depositAddress string) (bool, error)
+	HouseQueue() ([]MixerUser, error)
+	AddToHouseQueue(user MixerUser) error
+	RemoveSettledFromHouseQueue(depositAddress string) (bool, error)
+	Progress(depositAddress string) (DistributionProgress, error)
+	UpdateProgress(depositAddress string, change func(progress *DistributionProgress)) (DistributionProgress, error)
+	Payouts(depositAddress string) ([]ScheduledPayout, error)
+	SavePayouts(depositAddress string, payouts []ScheduledPayout) error
+	Sweep(depositAddress string) (Sweep, bool, error)
+	Sweeps() ([]Sweep, error)
+	SaveSweep(sweep Sweep) error
+	RemoveSweep(depositAddress string) error
+	LedgerEntries() ([]LedgerEntry, error)
+	LedgerBalance(account string) (clientlib.Amount, error)
+	LedgerBalances() (map[string]clientlib.Amount, error)
+	PostLedgerEntries(entries ...LedgerEntry) error
+	HouseAccount() (HouseAccount, error)
+	SaveHouseAccount(house HouseAccount) error
+	AddWebhookDeadLetter(delivery WebhookDelivery) error
+	WebhookDeadLetters() ([]WebhookDelivery, error)
+	CheckWritable() error
+	Close() error
+}
+
+// ArchivedUser is a user who is finished with the mixer, along with their
+// final distribution progress. Archived users are only checked for late
+// deposits by the expired sweep, but their status can still be looked up and
+// their return addresses stay reserved. Restored is only set in the archive
+// log of a FileStore, on a user moved back out of the archive.
+type ArchivedUser struct {
+	User       MixerUser            `json:"user"`
+	Progress   DistributionProgress `json:"progress"`
+	ArchivedAt time.Time            `json:"archivedAt"`
+	Restored   bool                 `json:"restored,omitempty"`
+}
+
+// storeState is the full set of data held by a Store. It is shared by the
+// in-memory and on-disk implementations. A FileStore keeps Ledger, DeadLetters
+// and Archived in logs of their own, and works out Balances and ledgerIDs from
+// the ledger when it is opened, so none of them are written to the state file.
+type storeState struct {
+	Users       []MixerUser                     `json:"users"`
+	Archived    map[string]ArchivedUser         `json:"-"`
+	HouseQueue  []MixerUser                     `json:"houseQueue"`
+	Progress    map[string]DistributionProgress `json:"progress"`
+	Sweeps      map[string]Sweep                `json:"sweeps"`
+	Payouts     map[string][]ScheduledPayout    `json:"payouts"`
+	Ledger      []LedgerEntry                   `json:"-"`
+	Balances    map[string]clientlib.Amount     `json:"-"`
+	House       HouseAccount                    `json:"house"`
+	DeadLetters []WebhookDelivery               `json:"-"`
+
+	// ledgerIDs holds the ID of every entry in Ledger.
+	ledgerIDs map[string]bool
+}
+
+func newStoreState() storeState {
+	return storeState{
+		Users:       []MixerUser{},
+		Archived:    map[string]ArchivedUser{},
+		HouseQueue:  []MixerUser{},
+		Progress:    map[string]DistributionProgress{},
+		Sweeps:      map[string]Sweep{},
+		Payouts:     map[string][]ScheduledPayout{},
+		Ledger:      []LedgerEntry{},
+		Balances:    map[string]clientlib.Amount{},
+		DeadLetters: []WebhookDelivery{},
+		ledgerIDs:   map[string]bool{},
+	}
+}
+
+func (s *storeState) user(depositAddress string) (MixerUser, error) {
+	for _, user := range s.Users {
+		if user.DepositAddress == depositAddress {
+			return user, nil
+		}
+	}
+	archived, ok := s.Archived[depositAddress]
+	if ok {
+		return archived.User, nil
+	}
+	return MixerUser{}, ErrUserNotFound
+}
+
+func (s *storeState) addUser(user MixerUser) {
+	s.Users = append(s.Users, user)
+}
+
+func (s *storeState) archivedUsers() []ArchivedUser {
+	archived := []ArchivedUser{}
+	for _, user := range s.Archived {
+		archived = append(archived, user)
+	}
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].ArchivedAt.Before(archived[j].ArchivedAt)
+	})
+	return archived
+}
+
+// settledUser returns the user ready to be archived, unless they are not
+// registered, the house still owes them anything, or they are in the house
+// queue or have a sweep or payouts outstanding.
+func (s *storeState) settledUser(depositAddress string, now time.Time) (ArchivedUser, bool) {
+	if s.Balances[userLedgerAccount(depositAddress)] < 0 || len(s.Payouts[depositAddress]) > 0 {
+		return ArchivedUser{}, false
+	}
+	if _, pending := s.Sweeps[depositAddress]; pending {
+		return ArchivedUser{}, false
+	}
+	if s.inHouseQueue(depositAddress) {
+		return ArchivedUser{}, false
+	}
+	for _, user := range s.Users {
+		if user.DepositAddress == depositAddress {
+			return ArchivedUser{User: user, Progress: s.progress(depositAddress), ArchivedAt: now}, true
+		}
+	}
+	return ArchivedUser{}, false
+}
+
+// removeUser removes the user and their progress, leaving them only in Archived.
+func (s *storeState) removeUser(depositAddress string) {
+	remaining := []MixerUser{}
+	for _, user := range s.Users {
+		if user.DepositAddress != depositAddress {
+			remaining = append(remaining, user)
+		}
+	}
+	s.Users = remaining
+	delete(s.Progress, depositAddress)
+}
+
+// restoreUser moves the archived user back into Users with the progress they
+// were archived with.
+func (s *storeState) restoreUser(archived ArchivedUser) {
+	delete(s.Archived, archived.User.DepositAddress)
+	s.addUser(archived.User)
+	s.Progress[archived.User.DepositAddress] = archived.Progress.copy()
+}
+
+func (s *storeState) addToHouseQueue(user MixerUser) {
+	s.HouseQueue = addOrReplaceUserInCollection(s.HouseQueue, user)
+}
+
+// removeSettledFromHouseQueue removes the user from the house queue unless the
+// house still owes them anything or they have payouts scheduled, and returns
+// whether they were removed.
+func (s *storeState) removeSettledFromHouseQueue(depositAddress string) bool {
+	if !s.settledInHouseQueue(depositAddress) {
+		return false
+	}
+	s.removeFromHouseQueue(depositAddress)
+	return true
+}
+
+// settledInHouseQueue returns false if the house still owes the user anything
+// or they have payouts scheduled.
+func (s *storeState) settledInHouseQueue(depositAddress string) bool {
+	return s.Balances[userLedgerAccount(depositAddress)] >= 0 && len(s.Payouts[depositAddress]) == 0
+}
+
+func (s *storeState) inHouseQueue(depositAddress string) bool {
+	for _, user := range s.HouseQueue {
+		if user.DepositAddress == depositAddress {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *storeState) removeFromHouseQueue(depositAddress string) {
+	remaining := []MixerUser{}
+	for _, user := range s.HouseQueue {
+		if user.DepositAddress != depositAddress {
+			remaining = append(remaining, user)
+		}
+	}
+	s.HouseQueue = remaining
+}
+
+func (s *storeState) progress(depositAddress string) DistributionProgress {
+	progress, ok := s.Progress[depositAddress]
+	if ok {
+		return progress.copy()
+	}
+	archived, ok := s.Archived[depositAddress]
+	if ok {
+		return archived.Progress.copy()
+	}
+	return newDistributionProgress(depositAddress)
+}
+
+func (s *storeState) updateProgress(depositAddress string, change func(progress *DistributionProgress)) DistributionProgress {
+	progress := s.progress(depositAddress)
+	change(&progress)
+	s.Progress[depositAddress] = progress
+	return progress.copy()
+}
+
+func (s *storeState) payouts(depositAddress string) []ScheduledPayout {
+	return append([]ScheduledPayout{}, s.Payouts[depositAddress]...)
+}
+
+func (s *storeState) savePayouts(depositAddress string, payouts []ScheduledPayout) {
+	if len(payouts) == 0 {
+		delete(s.Payouts, depositAddress)
+		return
+	}
+	saved := append([]ScheduledPayout{}, payouts...)
+	sort.SliceStable(saved, func(i, j int) bool {
+		return saved[i].DueAt.Before(saved[j].DueAt)
+	})
+	s.Payouts[depositAddress] = saved
+}
+
+func (s *storeState) sweep(depositAddress string) (Sweep, bool) {
+	sweep, ok := s.Sweeps[depositAddress]
+	return sweep, ok
+}
+
+func (s *storeState) sweeps() []Sweep {
+	sweeps := []Sweep{}
+	for _, sweep := range s.Sweeps {
+		sweeps = append(sweeps, sweep)
+	}
+	sort.Slice(sweeps, func(i, j int) bool {
+		return sweeps[i].StartedAt.Before(sweeps[j].StartedAt)
+	})
+	return sweeps
+}
+
+func (s *storeState) ledgerBalances() map[string]clientlib.Amount {
+	balances := map[string]clientlib.Amount{}
+	for account, balance := range s.Balances {
+		balances[account] = balance
+	}
+	return balances
+}
+
+// unpostedLedgerEntries returns the entries whose IDs are not yet in the
+// ledger, dropping any repeated among the entries themselves.
+func (s *storeState) unpostedLedgerEntries(entries []LedgerEntry) []LedgerEntry {
+	unposted := []LedgerEntry{}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if s.ledgerIDs[entry.ID] || seen[entry.ID] {
+			continue
+		}
+		seen[entry.ID] = true
+		unposted = append(unposted, entry)
+	}
+	return unposted
+}
+
+// applyLedgerEntries adds entries that have not been posted before to the
+// ledger and the account balances.
+func (s *storeState) applyLedgerEntries(entries []LedgerEntry) {
+	for _, entry := range entries {
+		s.ledgerIDs[entry.ID] = true
+		s.Ledger = append(s.Ledger, entry)
+		s.Balances[entry.Debit] = s.Balances[entry.Debit] + entry.Amount
+		s.Balances[entry.Credit] = s.Balances[entry.Credit] - entry.Amount
+	}
+}
+
+// The kinds of storeChange.
+const (
+	changeAddUser         = "addUser"
+	changeRemoveUser      = "removeUser"
+	changeRestoreUser     = "restoreUser"
+	changeAddToQueue      = "addToHouseQueue"
+	changeRemoveFromQueue = "removeFromHouseQueue"
+	changeProgress        = "progress"
+	changePayouts         = "payouts"
+	changeSweep           = "sweep"
+	changeRemoveSweep     = "removeSweep"
+	changeHouse           = "house"
+)
+
+// storeChange is a single change to the users, house queue, progress,
+// payouts, sweeps or house account, as written to a FileStore's change log.
+// Seq orders changes, so those already in the state file can be skipped.
+type storeChange struct {
+	Seq            uint64                `json:"seq"`
+	Kind           string                `json:"kind"`
+	DepositAddress string                `json:"depositAddress,omitempty"`
+	User           *MixerUser            `json:"user,omitempty"`
+	Progress       *DistributionProgress `json:"progress,omitempty"`
+	Payouts        []ScheduledPayout     `json:"payouts,omitempty"`
+	Sweep          *Sweep                `json:"sweep,omitempty"`
+	House          *HouseAccount         `json:"house,omitempty"`
+}
+
+// apply makes the change to the state. It returns an error for a change it
+// does not recognise or that is missing its value.
+func (s *storeState) apply(change storeChange) error {
+	missing := false
+	switch change.Kind {
+	case changeAddUser:
+		missing = change.User == nil
+		if !missing {
+			s.addUser(*change.User)
+		}
+	case changeRemoveUser:
+		s.removeUser(change.DepositAddress)
+	case changeRestoreUser:
+		missing = change.User == nil || change.Progress == nil
+		if !missing {
+			s.restoreUser(ArchivedUser{User: *change.User, Progress: *change.Progress})
+		}
+	case changeAddToQueue:
+		missing = change.User == nil
+		if !missing {
+			s.addToHouseQueue(*change.User)
+		}
+	case changeRemoveFromQueue:
+		s.removeFromHouseQueue(change.DepositAddress)
+	case changeProgress:
+		missing = change.Progress == nil
+		if !missing {
+			s.Progress[change.DepositAddress] = *change.Progress
+		}
+	case changePayouts:
+		s.savePayouts(change.DepositAddress, change.Payouts)
+	case changeSweep:
+		missing = change.Sweep == nil
+		if !missing {
+			s.Sweeps[change.Sweep.DepositAddress] = *change.Sweep
+		}
+	case changeRemoveSweep:
+		delete(s.Sweeps, change.DepositAddress)
+	case changeHouse:
+		missing = change.House == nil
+		if !missing {
+			s.House = *change.House
+		}
+	default:
+		return fmt.Errorf("unknown change %q", change.Kind)
+	}
+	if missing {
+		return fmt.Errorf("%s change is missing its value", change.Kind)
+	}
+	return nil
+}
+
+// MemoryStore is an implementation of the Store interface that keeps all
+// state in memory. Everything is lost when the process exits.
+type MemoryStore struct {
+	mu    sync.Mutex
+	state storeState
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: newStoreState()}
+}
+
+// Users returns a copy of every user registered with the mixer.
+func (ms *MemoryStore) Users() ([]MixerUser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return copyUsers(ms.state.Users), nil
+}
+
+// User returns the user with the given deposit address, or ErrUserNotFound.
+func (ms *MemoryStore) User(depositAddress string) (MixerUser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.user(depositAddress)
+}
+
+// AddUser registers a new user.
+func (ms *MemoryStore) AddUser(user MixerUser) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.state.addUser(user)
+	return nil
+}
+
+// ArchivedUsers returns every archived user, oldest archived first.
+func (ms *MemoryStore) ArchivedUsers() ([]ArchivedUser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.archivedUsers(), nil
+}
+
+// ArchiveSettledUser moves the user with the given deposit address out of
+// Users into ArchivedUsers, unless the house still owes them anything, or they
+// are in the house queue or have a sweep or payouts outstanding. It returns
+// whether they were archived.
+func (ms *MemoryStore) ArchiveSettledUser(depositAddress string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	archived, ok := ms.state.settledUser(depositAddress, time.Now())
+	if !ok {
+		return false, nil
+	}
+	ms.state.removeUser(depositAddress)
+	ms.state.Archived[depositAddress] = archived
+	return true, nil
+}
+
+// RestoreArchivedUser moves the archived user with the given deposit address
+// back into Users, with the progress they were archived with, so that a
+// deposit made after they were archived is mixed. It returns false if they
+// are not archived.
+func (ms *MemoryStore) RestoreArchivedUser(depositAddress string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	archived, ok := ms.state.Archived[depositAddress]
+	if !ok {
+		return false, nil
+	}
+	ms.state.restoreUser(archived)
+	return true, nil
+}
+
+// HouseQueue returns a copy of the users whose funds are in the house.
+func (ms *MemoryStore) HouseQueue() ([]MixerUser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return copyUsers(ms.state.HouseQueue), nil
+}
+
+// AddToHouseQueue adds the user to the end of the house queue, moving them
+// there if they are already queued.
+func (ms *MemoryStore) AddToHouseQueue(user MixerUser) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.state.addToHouseQueue(user)
+	return nil
+}
+
+// RemoveSettledFromHouseQueue removes the user with the given deposit address
+// from the house queue, unless the house still owes them anything or they have
+// payouts scheduled. It returns whether they were removed.
+func (ms *MemoryStore) RemoveSettledFromHouseQueue(depositAddress string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.removeSettledFromHouseQueue(depositAddress), nil
+}
+
+// Progress returns the distribution progress for the given deposit address.
+func (ms *MemoryStore) Progress(depositAddress string) (DistributionProgress, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.progress(depositAddress), nil
+}
+
+// UpdateProgress applies change to the distribution progress of a user and
+// returns the result. No other update can happen while change runs.
+func (ms *MemoryStore) UpdateProgress(depositAddress string, change func(progress *DistributionProgress)) (DistributionProgress, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.updateProgress(depositAddress, change), nil
+}
+
+// Payouts returns the payouts still scheduled for the given deposit address,
+// soonest first.
+func (ms *MemoryStore) Payouts(depositAddress string) ([]ScheduledPayout, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.payouts(depositAddress), nil
+}
+
+// SavePayouts replaces the payouts scheduled for the given deposit address.
+func (ms *MemoryStore) SavePayouts(depositAddress string, payouts []ScheduledPayout) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.state.savePayouts(depositAddress, payouts)
+	return nil
+}
+
+// Sweep returns the unfinished sweep for the given deposit address, and
+// whether there is one.
+func (ms *MemoryStore) Sweep(depositAddress string) (Sweep, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	sweep, ok := ms.state.sweep(depositAddress)
+	return sweep, ok, nil
+}
+
+// Sweeps returns every unfinished sweep, oldest first.
+func (ms *MemoryStore) Sweeps() ([]Sweep, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.sweeps(), nil
+}
+
+// SaveSweep adds or replaces the unfinished sweep for the sweep's deposit address.
+func (ms *MemoryStore) SaveSweep(sweep Sweep) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.state.Sweeps[sweep.DepositAddress] = sweep
+	return nil
+}
+
+// RemoveSweep removes the sweep for the given deposit address once it is finished.
+func (ms *MemoryStore) RemoveSweep(depositAddress string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.state.Sweeps, depositAddress)
+	return nil
+}
+
+// LedgerEntries returns every entry posted to the ledger, oldest first.
+func (ms *MemoryStore) LedgerEntries() ([]LedgerEntry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return append([]LedgerEntry{}, ms.state.Ledger...), nil
+}
+
+// LedgerBalance returns the balance of a ledger account, debits less credits.
+func (ms *MemoryStore) LedgerBalance(account string) (clientlib.Amount, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.Balances[account], nil
+}
+
+// LedgerBalances returns the balance of every ledger account.
+func (ms *MemoryStore) LedgerBalances() (map[string]clientlib.Amount, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.ledgerBalances(), nil
+}
+
+// PostLedgerEntries adds the entries to the ledger all at once. Entries with
+// the ID of an entry already posted are ignored.
+func (ms *MemoryStore) PostLedgerEntries(entries ...LedgerEntry) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.state.applyLedgerEntries(ms.state.unpostedLedgerEntries(entries))
+	return nil
+}
+
+// HouseAccount returns the saved house account. Its address is empty if
+// none has been saved yet.
+func (ms *MemoryStore) HouseAccount() (HouseAccount, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.state.House, nil
+}
+
+// SaveHouseAccount replaces the saved house account.
+func (ms *MemoryStore) SaveHouseAccount(house HouseAccount) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.state.House = house
+	return nil
+}
+
+// AddWebhookDeadLetter keeps a webhook delivery that could not be made.
+func (ms *MemoryStore) AddWebhookDeadLetter(delivery WebhookDelivery) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.state.DeadLetters = append(ms.state.DeadLetters, delivery)
+	return nil
+}
+
+// WebhookDeadLetters returns every webhook delivery that could not be made,
+// oldest first.
+func (ms *MemoryStore) WebhookDeadLetters() ([]WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return append([]WebhookDelivery{}, ms.state.DeadLetters...), nil
+}
+
+// CheckWritable always succeeds for a MemoryStore.
+func (ms *MemoryStore) CheckWritable() error {
+	return nil
+}
+
+// Close does nothing for a MemoryStore.
+func (ms *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore is an implementation of the Store interface that keeps its
+// state on disk. Each change is made to the state in memory and written as a
+// single line to a change log next to the state file, named after it with
+// ".changes" added, so a change costs the same however many users there are.
+// Once the change log has grown larger than the state file, the state is
+// written to a temporary file which then replaces the state file, and the
+// change log is emptied. A crash mid-write never leaves a partially written
+// state file behind, and a change cut short by a crash is dropped from the
+// change log.
+//
+// The ledger, the webhook dead letters and the archived users only ever grow,
+// so rather than being written to the state file they are appended to logs of
+// their own, named after it with ".ledger", ".dead-letters" and ".archive"
+// added. Posting to the ledger costs the same however long it is, and
+// archived users no longer add to the state file.
+type FileStore struct {
+	mu            sync.RWMutex
+	path          string
+	readOnly      bool
+	state         storeState
+	seq           uint64
+	stateSize     int64
+	compactAfter  int64
+	changeLog     *appendLog
+	ledgerLog     *appendLog
+	deadLetterLog *appendLog
+	archiveLog    *appendLog
+}
+
+// fileStoreState is what a FileStore writes to its state file. Seq is that of
+// the last change included in it.
+type fileStoreState struct {
+	storeState
+	Seq uint64 `json:"seq"`
+}
+
+// compactChangesAfter is the smallest size the change log of a FileStore grows
+// to before it is written to the state file.
+const compactChangesAfter = 1 << 20
+
+// readOnlyAttempts is how many times a read-only FileStore tries to read the
+// state file and change log while another process replaces the state file.
+const readOnlyAttempts = 5
+
+// NewFileStore returns a FileStore backed by the file at path. If the file
+// already exists its contents are loaded, otherwise it is created.
+func NewFileStore(path string) (*FileStore, error) {
+	return openFileStore(path, false)
+}
+
+// OpenFileStoreReadOnly returns a FileStore with the state saved at path,
+// which must already exist. Nothing is ever written to the state file or its
+// logs, so it is safe to use while another process is running with the same
+// state. Any change returns ErrReadOnlyStore.
+func OpenFileStoreReadOnly(path string) (*FileStore, error) {
+	for attempt := 1; ; attempt++ {
+		fs, err := openFileStore(path, true)
+		if err != errStateFileReplaced || attempt == readOnlyAttempts {
+			return fs, err
+		}
+	}
+}
+
+// errStateFileReplaced is returned when opening a FileStore read-only if the
+// state file was replaced while it and the change log were being read, so
+// changes may be missing from what was read.
+var errStateFileReplaced = errors.New("State file was replaced while it was being read")
+
+func openFileStore(path string, readOnly bool) (*FileStore, error) {
+	fs := &FileStore{
+		path:          path,
+		readOnly:      readOnly,
+		state:         newStoreState(),
+		compactAfter:  compactChangesAfter,
+		changeLog:     &appendLog{path: path + ".changes", readOnly: readOnly},
+		ledgerLog:     &appendLog{path: path + ".ledger", readOnly: readOnly},
+		deadLetterLog: &appendLog{path: path + ".dead-letters", readOnly: readOnly},
+		archiveLog:    &appendLog{path: path + ".archive", readOnly: readOnly},
+	}
+
+	before, err := os.Stat(path)
+	if os.IsNotExist(err) && !readOnly {
+		err = fs.loadLogs()
+		if err != nil {
+			return nil, err
+		}
+		return fs, fs.compact()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	saved := fileStoreState{storeState: fs.state}
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return nil, err
+	}
+	fs.state, fs.seq, fs.stateSize = saved.storeState, saved.Seq, int64(len(data))
+	if fs.state.Progress == nil {
+		fs.state.Progress = map[string]DistributionProgress{}
+	}
+	if fs.state.Sweeps == nil {
+		fs.state.Sweeps = map[string]Sweep{}
+	}
+	if fs.state.Payouts == nil {
+		fs.state.Payouts = map[string][]ScheduledPayout{}
+	}
+
+	err = fs.changeLog.read(func(line []byte) error {
+		var change storeChange
+		err := json.Unmarshal(line, &change)
+		if err != nil || change.Seq <= fs.seq {
+			// A change from before the state file was last written, whose
+			// change log had not been emptied yet.
+			return err
+		}
+		fs.seq = change.Seq
+		return fs.state.apply(change)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read changes: %w", err)
+	}
+	if readOnly {
+		// Another process may have replaced the state file and emptied the
+		// change log after the state file was read.
+		after, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !os.SameFile(before, after) || !before.ModTime().Equal(after.ModTime()) {
+			return nil, errStateFileReplaced
+		}
+	}
+
+	err = fs.loadLogs()
+	if err != nil {
+		return nil, err
+	}
+
+	// A user in the archive log and the state was being archived when the
+	// change removing them last failed to be written.
+	for _, user := range copyUsers(fs.state.Users) {
+		if _, archived := fs.state.Archived[user.DepositAddress]; !archived {
+			continue
+		}
+		if readOnly {
+			fs.state.removeUser(user.DepositAddress)
+			continue
+		}
+		err = fs.commit(storeChange{Kind: changeRemoveUser, DepositAddress: user.DepositAddress})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// loadLogs reads the ledger, dead letters and archived users from their logs.
+func (fs *FileStore) loadLogs() error {
+	err := fs.ledgerLog.read(func(line []byte) error {
+		entries := []LedgerEntry{}
+		err := json.Unmarshal(line, &entries)
+		if err != nil {
+			return err
+		}
+		fs.state.applyLedgerEntries(fs.state.unpostedLedgerEntries(entries))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read ledger: %w", err)
+	}
+
+	err = fs.deadLetterLog.read(func(line []byte) error {
+		var delivery WebhookDelivery
+		err := json.Unmarshal(line, &delivery)
+		if err != nil {
+			return err
+		}
+		fs.state.DeadLetters = append(fs.state.DeadLetters, delivery)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read webhook dead letters: %w", err)
+	}
+
+	err = fs.archiveLog.read(func(line []byte) error {
+		var archived ArchivedUser
+		err := json.Unmarshal(line, &archived)
+		if err != nil {
+			return err
+		}
+		if archived.Restored {
+			delete(fs.state.Archived, archived.User.DepositAddress)
+			return nil
+		}
+		fs.state.Archived[archived.User.DepositAddress] = archived
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read archived users: %w", err)
+	}
+	return nil
+}
+
+// Users returns a copy of every user registered with the mixer.
+func (fs *FileStore) Users() ([]MixerUser, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return copyUsers(fs.state.Users), nil
+}
+
+// User returns the user with the given deposit address, or ErrUserNotFound.
+func (fs *FileStore) User(depositAddress string) (MixerUser, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.user(depositAddress)
+}
+
+// AddUser registers a new user.
+func (fs *FileStore) AddUser(user MixerUser) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.commit(storeChange{Kind: changeAddUser, User: &user})
+}
+
+// ArchivedUsers returns every archived user, oldest archived first.
+func (fs *FileStore) ArchivedUsers() ([]ArchivedUser, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.archivedUsers(), nil
+}
+
+// ArchiveSettledUser moves the user with the given deposit address out of
+// Users into ArchivedUsers, unless the house still owes them anything, or they
+// are in the house queue or have a sweep or payouts outstanding. It returns
+// whether they were archived. The user is added to the archive log before
+// being removed from the state.
+func (fs *FileStore) ArchiveSettledUser(depositAddress string) (bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	archived, ok := fs.state.settledUser(depositAddress, time.Now())
+	if !ok {
+		return false, nil
+	}
+	err := fs.archiveLog.append(archived)
+	if err != nil {
+		return false, err
+	}
+	err = fs.commit(storeChange{Kind: changeRemoveUser, DepositAddress: depositAddress})
+	if err != nil {
+		return false, err
+	}
+	fs.state.Archived[depositAddress] = archived
+	return true, nil
+}
+
+// RestoreArchivedUser moves the archived user with the given deposit address
+// back into Users, with the progress they were archived with, so that a
+// deposit made after they were archived is mixed. It returns false if they
+// are not archived. The user is added back to the state before being marked
+// restored in the archive log, so a failure in between leaves them archived.
+func (fs *FileStore) RestoreArchivedUser(depositAddress string) (bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	archived, ok := fs.state.Archived[depositAddress]
+	if !ok {
+		return false, nil
+	}
+	err := fs.commit(storeChange{Kind: changeRestoreUser, DepositAddress: depositAddress, User: &archived.User, Progress: &archived.Progress})
+	if err != nil {
+		return false, err
+	}
+	archived.Restored = true
+	err = fs.archiveLog.append(archived)
+	if err != nil {
+		// Archive them again, as they would be on the next start.
+		fs.state.removeUser(depositAddress)
+		archived.Restored = false
+		fs.state.Archived[depositAddress] = archived
+		return false, err
+	}
+	return true, nil
+}
+
+// HouseQueue returns a copy of the users whose funds are in the house.
+func (fs *FileStore) HouseQueue() ([]MixerUser, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return copyUsers(fs.state.HouseQueue), nil
+}
+
+// AddToHouseQueue adds the user to the end of the house queue, moving them
+// there if they are already queued.
+func (fs *FileStore) AddToHouseQueue(user MixerUser) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.commit(storeChange{Kind: changeAddToQueue, User: &user})
+}
+
+// RemoveSettledFromHouseQueue removes the user with the given deposit address
+// from the house queue, unless the house still owes them anything or they have
+// payouts scheduled. It returns whether they were removed.
+func (fs *FileStore) RemoveSettledFromHouseQueue(depositAddress string) (bool, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if !fs.state.settledInHouseQueue(depositAddress) {
+		return false, nil
+	}
+	if !fs.state.inHouseQueue(depositAddress) {
+		return true, nil
+	}
+	err := fs.commit(storeChange{Kind: changeRemoveFromQueue, DepositAddress: depositAddress})
+	return err == nil, err
+}
+
+// Progress returns the distribution progress for the given deposit address.
+func (fs *FileStore) Progress(depositAddress string) (DistributionProgress, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.progress(depositAddress), nil
+}
+
+// UpdateProgress applies change to the distribution progress of a user and
+// returns the result. No other update can happen while change runs.
+func (fs *FileStore) UpdateProgress(depositAddress string, change func(progress *DistributionProgress)) (DistributionProgress, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	progress := fs.state.progress(depositAddress)
+	change(&progress)
+	err := fs.commit(storeChange{Kind: changeProgress, DepositAddress: depositAddress, Progress: &progress})
+	if err != nil {
+		return DistributionProgress{}, err
+	}
+	return progress.copy(), nil
+}
+
+// Payouts returns the payouts still scheduled for the given deposit address,
+// soonest first.
+func (fs *FileStore) Payouts(depositAddress string) ([]ScheduledPayout, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.payouts(depositAddress), nil
+}
+
+// SavePayouts replaces the payouts scheduled for the given deposit address.
+func (fs *FileStore) SavePayouts(depositAddress string, payouts []ScheduledPayout) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.commit(storeChange{Kind: changePayouts, DepositAddress: depositAddress, Payouts: payouts})
+}
+
+// Sweep returns the unfinished sweep for the given deposit address, and
+// whether there is one.
+func (fs *FileStore) Sweep(depositAddress string) (Sweep, bool, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	sweep, ok := fs.state.sweep(depositAddress)
+	return sweep, ok, nil
+}
+
+// Sweeps returns every unfinished sweep, oldest first.
+func (fs *FileStore) Sweeps() ([]Sweep, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.sweeps(), nil
+}
+
+// SaveSweep adds or replaces the unfinished sweep for the sweep's deposit address.
+func (fs *FileStore) SaveSweep(sweep Sweep) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.commit(storeChange{Kind: changeSweep, Sweep: &sweep})
+}
+
+// RemoveSweep removes the sweep for the given deposit address once it is finished.
+func (fs *FileStore) RemoveSweep(depositAddress string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.commit(storeChange{Kind: changeRemoveSweep, DepositAddress: depositAddress})
+}
+
+// LedgerEntries returns every entry posted to the ledger, oldest first.
+func (fs *FileStore) LedgerEntries() ([]LedgerEntry, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return append([]LedgerEntry{}, fs.state.Ledger...), nil
+}
+
+// LedgerBalance returns the balance of a ledger account, debits less credits.
+func (fs *FileStore) LedgerBalance(account string) (clientlib.Amount, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.Balances[account], nil
+}
+
+// LedgerBalances returns the balance of every ledger account.
+func (fs *FileStore) LedgerBalances() (map[string]clientlib.Amount, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.ledgerBalances(), nil
+}
+
+// PostLedgerEntries adds the entries to the ledger all at once, as a single
+// line of the ledger log. Entries with the ID of an entry already posted are
+// ignored.
+func (fs *FileStore) PostLedgerEntries(entries ...LedgerEntry) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	unposted := fs.state.unpostedLedgerEntries(entries)
+	if len(unposted) == 0 {
+		return nil
+	}
+	err := fs.ledgerLog.append(unposted)
+	if err != nil {
+		return err
+	}
+	fs.state.applyLedgerEntries(unposted)
+	return nil
+}
+
+// HouseAccount returns the saved house account. Its address is empty if
+// none has been saved yet.
+func (fs *FileStore) HouseAccount() (HouseAccount, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.state.House, nil
+}
+
+// SaveHouseAccount replaces the saved house account.
+func (fs *FileStore) SaveHouseAccount(house HouseAccount) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.commit(storeChange{Kind: changeHouse, House: &house})
+}
+
+// AddWebhookDeadLetter keeps a webhook delivery that could not be made.
+func (fs *FileStore) AddWebhookDeadLetter(delivery WebhookDelivery) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	err := fs.deadLetterLog.append(delivery)
+	if err != nil {
+		return err
+	}
+	fs.state.DeadLetters = append(fs.state.DeadLetters, delivery)
+	return nil
+}
+
+// WebhookDeadLetters returns every webhook delivery that could not be made,
+// oldest first.
+func (fs *FileStore) WebhookDeadLetters() ([]WebhookDelivery, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return append([]WebhookDelivery{}, fs.state.DeadLetters...), nil
+}
+
+// CheckWritable returns an error if a new state file could not be written, by
+// writing and removing a temporary file next to it. It always returns
+// ErrReadOnlyStore for a read-only store.
+func (fs *FileStore) CheckWritable() error {
+	if fs.readOnly {
+		return ErrReadOnlyStore
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".check")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write([]byte("{}"))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close writes the current state to the state file and empties the change
+// log, unless the store is read-only, and closes the logs. The store should
+// not be used afterwards.
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var err error
+	if !fs.readOnly {
+		err = fs.compact()
+	}
+	for _, log := range []*appendLog{fs.changeLog, fs.ledgerLog, fs.deadLetterLog, fs.archiveLog} {
+		if closeErr := log.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// commit writes change to the change log and, once it is on disk, makes it to
+// the state. The caller must hold the write lock.
+func (fs *FileStore) commit(change storeChange) error {
+	change.Seq = fs.seq + 1
+	err := fs.changeLog.append(change)
+	if err != nil {
+		return err
+	}
+	fs.seq = change.Seq
+	err = fs.state.apply(change)
+	if err != nil {
+		return err
+	}
+
+	if fs.changeLog.size > fs.compactAfter && fs.changeLog.size > fs.stateSize {
+		// The change is already safe in the change log, so a failure is
+		// only tried again after the next change.
+		fs.compact()
+	}
+	return nil
+}
+
+// compact writes everything but the ledger, dead letters and archived users to
+// the state file, then empties the change log.
+func (fs *FileStore) compact() error {
+	if fs.readOnly {
+		return ErrReadOnlyStore
+	}
+	data, err := json.MarshalIndent(fileStoreState{storeState: fs.state, Seq: fs.seq}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), fs.path)
+	if err != nil {
+		return err
+	}
+	fs.stateSize = int64(len(data))
+
+	return fs.changeLog.truncate()
+}
+
+func copyUsers(users []MixerUser) []MixerUser {
+	return append([]MixerUser{}, users...)
+}
@@ -0,0 +1,612 @@
+package mixerlib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRegisteredAt is a registration time that survives a round trip through JSON unchanged.
+var testRegisteredAt = time.Date(2020, 10, 31, 15, 4, 5, 0, time.UTC)
+
+func newTestFileStore(t *testing.T) (*FileStore, string) {
+	dir, err := ioutil.TempDir("", "mixerlib-store")
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "state.json")
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+
+	return fs, path
+}
+
+// breakChangeLog points the store's change log at a directory that does not
+// exist, so that writing any change fails.
+func breakChangeLog(fs *FileStore, path string) {
+	fs.changeLog.close()
+	fs.changeLog.path = filepath.Join(filepath.Dir(path), "missing", "state.json.changes")
+}
+
+// Begin MemoryStore tests
+func TestMemoryStore_AddToHouseQueueBumpsExistingUserToEnd(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddToHouseQueue(MixerUser{DepositAddress: "aaa"})
+	store.AddToHouseQueue(MixerUser{DepositAddress: "bbb"})
+	store.AddToHouseQueue(MixerUser{DepositAddress: "aaa"})
+
+	expectedQueue := []MixerUser{
+		{DepositAddress: "bbb"},
+		{DepositAddress: "aaa"},
+	}
+	actualQueue, err := store.HouseQueue()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, expectedQueue, actualQueue)
+}
+
+func TestMemoryStore_RemoveSettledFromHouseQueueRemovesOnlyGivenUser(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddToHouseQueue(MixerUser{DepositAddress: "aaa"})
+	store.AddToHouseQueue(MixerUser{DepositAddress: "bbb"})
+
+	removed, err := store.RemoveSettledFromHouseQueue("aaa")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	actualQueue, _ := store.HouseQueue()
+	assert.True(t, removed)
+	assert.Equal(t, []MixerUser{{DepositAddress: "bbb"}}, actualQueue)
+}
+
+func TestMemoryStore_RemoveSettledFromHouseQueueKeepsUserStillOwedFunds(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddToHouseQueue(MixerUser{DepositAddress: "aaa"})
+	store.AddToHouseQueue(MixerUser{DepositAddress: "bbb"})
+	store.PostLedgerEntries(LedgerEntry{ID: "sweep", Debit: houseLedgerAccount("house"), Credit: userLedgerAccount("aaa"), Amount: clientlib.Coin})
+	store.SavePayouts("bbb", []ScheduledPayout{{ToAddress: "ccc", Amount: clientlib.Coin}})
+
+	removedOwed, _ := store.RemoveSettledFromHouseQueue("aaa")
+	removedScheduled, _ := store.RemoveSettledFromHouseQueue("bbb")
+
+	actualQueue, _ := store.HouseQueue()
+	assert.False(t, removedOwed)
+	assert.False(t, removedScheduled)
+	assert.Equal(t, []MixerUser{{DepositAddress: "aaa"}, {DepositAddress: "bbb"}}, actualQueue)
+}
+
+func TestMemoryStore_ArchiveSettledUserKeepsStatusAvailable(t *testing.T) {
+	store := NewMemoryStore()
+	store.AddUser(MixerUser{DepositAddress: "aaa"})
+	store.AddUser(MixerUser{DepositAddress: "bbb"})
+	store.UpdateProgress("aaa", func(p *DistributionProgress) {
+		p.State = StateComplete
+	})
+
+	archived, err := store.ArchiveSettledUser("aaa")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	users, _ := store.Users()
+	archivedUsers, _ := store.ArchivedUsers()
+	user, err := store.User("aaa")
+	progress, _ := store.Progress("aaa")
+	assert.True(t, archived)
+	assert.Equal(t, []MixerUser{{DepositAddress: "bbb"}}, users)
+	assert.Equal(t, 1, len(archivedUsers))
+	assert.Nil(t, err)
+	assert.Equal(t, "aaa", user.DepositAddress)
+	assert.Equal(t, StateComplete, progress.State)
+}
+
+func TestMemoryStore_ArchiveSettledUserKeepsUserWithAnythingOutstanding(t *testing.T) {
+	store := NewMemoryStore()
+	for _, depositAddress := range []string{"owed", "scheduled", "sweeping", "queued"} {
+		store.AddUser(MixerUser{DepositAddress: depositAddress})
+	}
+	store.PostLedgerEntries(LedgerEntry{ID: "sweep", Debit: houseLedgerAccount("house"), Credit: userLedgerAccount("owed"), Amount: clientlib.Coin})
+	store.SavePayouts("scheduled", []ScheduledPayout{{ToAddress: "ccc", Amount: clientlib.Coin}})
+	store.SaveSweep(Sweep{DepositAddress: "sweeping"})
+	store.AddToHouseQueue(MixerUser{DepositAddress: "queued"})
+
+	for _, depositAddress := range []string{"owed", "scheduled", "sweeping", "queued", "unknown"} {
+		archived, err := store.ArchiveSettledUser(depositAddress)
+		assert.Nil(t, err)
+		assert.False(t, archived, depositAddress)
+	}
+	users, _ := store.Users()
+	assert.Equal(t, 4, len(users))
+}
+
+func TestMemoryStore_ProgressDefaultsToEmptyProgressForAddress(t *testing.T) {
+	store := NewMemoryStore()
+
+	progress, err := store.Progress("aaa")
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.Equal(t, newDistributionProgress("aaa"), progress)
+}
+
+func TestMemoryStore_UpdateProgressAppliesChangeToStoredProgress(t *testing.T) {
+	store := NewMemoryStore()
+	store.UpdateProgress("aaa", func(p *DistributionProgress) {
+		p.ReturnedTo["1111aaaa"] = clientlib.Coin
+	})
+
+	progress, err := store.UpdateProgress("aaa", func(p *DistributionProgress) {
+		p.ReturnedTo["1111aaaa"] = p.ReturnedTo["1111aaaa"] + clientlib.Coin
+		p.Rounds++
+	})
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	// Changing the returned progress must not change what is stored.
+	progress.ReturnedTo["1111aaaa"] = 0
+	stored, _ := store.Progress("aaa")
+
+	assert.Equal(t, 2*clientlib.Coin, stored.ReturnedTo["1111aaaa"])
+	assert.Equal(t, 1, stored.Rounds)
+}
+
+func TestMemoryStore_PostLedgerEntriesIgnoresEntriesAlreadyPosted(t *testing.T) {
+	store := NewMemoryStore()
+	entry := LedgerEntry{ID: "sweep:aaa:1", Debit: "house:bbb", Credit: "user:aaa", Amount: clientlib.Coin}
+
+	store.PostLedgerEntries(entry)
+	err := store.PostLedgerEntries(entry, LedgerEntry{ID: "payout", Debit: "user:aaa", Credit: "house:bbb", Amount: clientlib.Coin / 4})
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	entries, _ := store.LedgerEntries()
+	balances, _ := store.LedgerBalances()
+
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, clientlib.MustParseAmount("0.75"), balances["house:bbb"])
+	assert.Equal(t, clientlib.MustParseAmount("-0.75"), balances["user:aaa"])
+}
+
+// Begin FileStore tests
+func TestFileStore_PersistsStateAcrossRestarts(t *testing.T) {
+	fs, path := newTestFileStore(t)
+
+	user := MixerUser{
+		DepositAddress:  "1234abcd",
+		ReturnAddresses: []string{"1111aaaa", "2222bbbb"},
+		RegisteredAt:    testRegisteredAt,
+	}
+
+	assert.Nil(t, fs.AddUser(user))
+	assert.Nil(t, fs.AddToHouseQueue(user))
+	progress, err := fs.UpdateProgress(user.DepositAddress, func(p *DistributionProgress) {
+		p.State = StateDistributing
+		p.Rounds = 2
+		p.Returned = clientlib.MustParseAmount("7.5")
+		p.ReturnedTo["1111aaaa"] = clientlib.MustParseAmount("7.5")
+	})
+	assert.Nil(t, err)
+	sweep := Sweep{
+		DepositAddress: "5678efgh",
+		Balance:        clientlib.Coin,
+		FeeSent:        true,
+	}
+	assert.Nil(t, fs.SaveSweep(sweep))
+	payouts := []ScheduledPayout{
+		{ToAddress: "1111aaaa", Amount: clientlib.Coin},
+	}
+	assert.Nil(t, fs.SavePayouts(user.DepositAddress, payouts))
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	users, _ := reopened.Users()
+	houseQueue, _ := reopened.HouseQueue()
+	savedProgress, _ := reopened.Progress(user.DepositAddress)
+
+	assert.Equal(t, []MixerUser{user}, users)
+	assert.Equal(t, []MixerUser{user}, houseQueue)
+	assert.Equal(t, progress, savedProgress)
+	savedSweep, pending, _ := reopened.Sweep(sweep.DepositAddress)
+	assert.True(t, pending)
+	assert.Equal(t, sweep, savedSweep)
+	savedPayouts, _ := reopened.Payouts(user.DepositAddress)
+	assert.Equal(t, payouts, savedPayouts)
+}
+
+func TestFileStore_PersistsWebhookDeadLetters(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	delivery := WebhookDelivery{
+		ID:        "delivery-one",
+		URL:       "https://example.com/hooks/mixer",
+		Event:     Event{Kind: EventComplete, DepositAddress: "1234abcd", Time: time.Now().UTC()},
+		Attempts:  6,
+		LastError: "webhook URL responded with status 500",
+		FailedAt:  time.Now().UTC(),
+	}
+
+	assert.Nil(t, fs.AddWebhookDeadLetter(delivery))
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	deadLetters, _ := reopened.WebhookDeadLetters()
+	assert.Equal(t, 1, len(deadLetters))
+	assert.Equal(t, delivery.ID, deadLetters[0].ID)
+	assert.True(t, delivery.FailedAt.Equal(deadLetters[0].FailedAt))
+}
+
+func TestFileStore_AppendsLedgerWithoutRewritingStateFile(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.AddUser(MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt})
+	before, _ := ioutil.ReadFile(path)
+	sweep := LedgerEntry{ID: "sweep:aaa:1", Debit: "house:bbb", Credit: "user:aaa", Amount: clientlib.Coin}
+
+	assert.Nil(t, fs.PostLedgerEntries(sweep))
+	assert.Nil(t, fs.PostLedgerEntries(LedgerEntry{ID: "payout", Debit: "user:aaa", Credit: "house:bbb", Amount: clientlib.Coin / 4}))
+
+	after, _ := ioutil.ReadFile(path)
+	assert.Equal(t, string(before), string(after))
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	assert.Nil(t, reopened.PostLedgerEntries(sweep))
+	entries, _ := reopened.LedgerEntries()
+	balance, _ := reopened.LedgerBalance("user:aaa")
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, clientlib.MustParseAmount("-0.75"), balance)
+}
+
+func TestFileStore_WritesChangesWithoutRewritingStateFile(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	before, _ := ioutil.ReadFile(path)
+	user := MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt}
+
+	assert.Nil(t, fs.AddUser(user))
+	assert.Nil(t, fs.SaveSweep(Sweep{DepositAddress: "aaa", Balance: clientlib.Coin}))
+	assert.Nil(t, fs.RemoveSweep("aaa"))
+
+	after, _ := ioutil.ReadFile(path)
+	changes, _ := ioutil.ReadFile(path + ".changes")
+	assert.Equal(t, string(before), string(after))
+	assert.Equal(t, 3, strings.Count(string(changes), "\n"))
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	users, _ := reopened.Users()
+	_, pending, _ := reopened.Sweep("aaa")
+	assert.Equal(t, []MixerUser{user}, users)
+	assert.False(t, pending)
+}
+
+func TestFileStore_CompactsChangeLogOnceLargerThanStateFile(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.compactAfter = 0
+	user := MixerUser{DepositAddress: "aaa", ReturnAddresses: []string{strings.Repeat("a", 1000)}, RegisteredAt: testRegisteredAt}
+
+	assert.Nil(t, fs.AddUser(user))
+
+	state, _ := ioutil.ReadFile(path)
+	changes, _ := os.Stat(path + ".changes")
+	assert.Contains(t, string(state), user.ReturnAddresses[0])
+	assert.Equal(t, int64(0), changes.Size())
+
+	assert.Nil(t, fs.AddUser(MixerUser{DepositAddress: "bbb", RegisteredAt: testRegisteredAt}))
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	users, _ := reopened.Users()
+	assert.Equal(t, 2, len(users))
+}
+
+func TestNewFileStore_SkipsChangesAlreadyInStateFile(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.AddUser(MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt})
+	fs.AddToHouseQueue(MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt})
+	changes, _ := ioutil.ReadFile(path + ".changes")
+	fs.Close()
+
+	// A crash after the state file was written but before the change log
+	// was emptied.
+	ioutil.WriteFile(path+".changes", changes, 0600)
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	users, _ := reopened.Users()
+	houseQueue, _ := reopened.HouseQueue()
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, 1, len(houseQueue))
+}
+
+func TestNewFileStore_ReturnsErrorIfChangeLogIsCorrupt(t *testing.T) {
+	_, path := newTestFileStore(t)
+	ioutil.WriteFile(path+".changes", []byte(`{"seq":1,"kind":"unknown"}`+"\n"), 0600)
+
+	_, err := NewFileStore(path)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
+
+func TestNewFileStore_DropsLedgerEntriesCutShortByCrash(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.PostLedgerEntries(LedgerEntry{ID: "one", Debit: "house:bbb", Credit: "user:aaa", Amount: clientlib.Coin})
+	fs.Close()
+	log, _ := os.OpenFile(path+".ledger", os.O_WRONLY|os.O_APPEND, 0600)
+	log.Write([]byte(`[{"id":"two","debit":"ho`))
+	log.Close()
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	reopened.PostLedgerEntries(LedgerEntry{ID: "three", Debit: "house:bbb", Credit: "user:aaa", Amount: clientlib.Coin})
+	reopened.Close()
+	reopened, err = NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+
+	entries, _ := reopened.LedgerEntries()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "one", entries[0].ID)
+	assert.Equal(t, "three", entries[1].ID)
+}
+
+func TestNewFileStore_ReturnsErrorIfLedgerIsCorrupt(t *testing.T) {
+	_, path := newTestFileStore(t)
+	ioutil.WriteFile(path+".ledger", []byte("not json\n[]\n"), 0600)
+
+	_, err := NewFileStore(path)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
+
+func TestFileStore_KeepsPreviousStateIfWriteFails(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	assert.Nil(t, fs.AddUser(MixerUser{DepositAddress: "aaa"}))
+
+	breakChangeLog(fs, path)
+
+	err := fs.AddUser(MixerUser{DepositAddress: "bbb"})
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	users, _ := fs.Users()
+	assert.Equal(t, []MixerUser{{DepositAddress: "aaa"}}, users)
+}
+
+func TestFileStoreCheckWritable_LeavesNoFilesBehind(t *testing.T) {
+	fs, path := newTestFileStore(t)
+
+	err := fs.CheckWritable()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	assert.Equal(t, 1, len(files))
+}
+
+func TestFileStoreCheckWritable_ReturnsErrorIfDirectoryMissing(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.path = filepath.Join(filepath.Dir(path), "missing", "state.json")
+
+	err := fs.CheckWritable()
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
+
+func TestNewFileStore_ReturnsErrorIfStateFileIsCorrupt(t *testing.T) {
+	_, path := newTestFileStore(t)
+	ioutil.WriteFile(path, []byte("not json"), 0600)
+
+	_, err := NewFileStore(path)
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+}
+
+func TestFileStore_CloseFlushesStateToDisk(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	user := MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt}
+	fs.AddUser(user)
+	os.Remove(path)
+
+	err := fs.Close()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	reopened, _ := NewFileStore(path)
+	users, _ := reopened.Users()
+	assert.Equal(t, []MixerUser{user}, users)
+}
+
+func TestFileStore_KeepsArchivedUsersOutOfStateFile(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.AddUser(MixerUser{DepositAddress: "aaa", ReturnAddresses: []string{"archived-return"}, RegisteredAt: testRegisteredAt})
+	fs.AddUser(MixerUser{DepositAddress: "bbb", RegisteredAt: testRegisteredAt})
+	fs.UpdateProgress("aaa", func(p *DistributionProgress) {
+		p.State = StateComplete
+	})
+
+	archived, err := fs.ArchiveSettledUser("aaa")
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	fs.Close()
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+
+	state, _ := ioutil.ReadFile(path)
+	users, _ := reopened.Users()
+	archivedUsers, _ := reopened.ArchivedUsers()
+	progress, _ := reopened.Progress("aaa")
+	assert.True(t, archived)
+	assert.NotContains(t, string(state), "archived-return")
+	assert.Equal(t, 1, len(users))
+	assert.Equal(t, "bbb", users[0].DepositAddress)
+	assert.Equal(t, 1, len(archivedUsers))
+	assert.Equal(t, []string{"archived-return"}, archivedUsers[0].User.ReturnAddresses)
+	assert.Equal(t, StateComplete, progress.State)
+}
+
+func TestNewFileStore_FinishesArchivingUserInterruptedByFailedWrite(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.AddUser(MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt})
+
+	// The user is added to the archive log, but removing them from the
+	// state cannot be written.
+	breakChangeLog(fs, path)
+	_, err := fs.ArchiveSettledUser("aaa")
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	users, _ := reopened.Users()
+	archivedUsers, _ := reopened.ArchivedUsers()
+	assert.Equal(t, 0, len(users))
+	assert.Equal(t, 1, len(archivedUsers))
+}
+
+func TestFileStore_RestoresArchivedUserAcrossRestarts(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	user := MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt}
+	fs.AddUser(user)
+	fs.UpdateProgress("aaa", func(p *DistributionProgress) {
+		p.State = StateComplete
+	})
+	fs.ArchiveSettledUser("aaa")
+
+	restored, err := fs.RestoreArchivedUser("aaa")
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+
+	users, _ := reopened.Users()
+	archivedUsers, _ := reopened.ArchivedUsers()
+	progress, _ := reopened.Progress("aaa")
+	assert.True(t, restored)
+	assert.Equal(t, []MixerUser{user}, users)
+	assert.Equal(t, 0, len(archivedUsers))
+	assert.Equal(t, StateComplete, progress.State)
+}
+
+func TestNewFileStore_KeepsUserArchivedIfRestoreWasInterrupted(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.AddUser(MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt})
+	fs.ArchiveSettledUser("aaa")
+
+	// The user is added back to the state, but marking them restored in the
+	// archive log fails.
+	fs.archiveLog.close()
+	fs.archiveLog.path = filepath.Join(filepath.Dir(path), "missing", "state.json.archive")
+	_, err := fs.RestoreArchivedUser("aaa")
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	users, _ := reopened.Users()
+	archivedUsers, _ := reopened.ArchivedUsers()
+	assert.Equal(t, 0, len(users))
+	assert.Equal(t, 1, len(archivedUsers))
+}
+
+// Begin OpenFileStoreReadOnly tests
+func TestOpenFileStoreReadOnly_NeverWritesStateOrLogs(t *testing.T) {
+	_, path := newTestFileStore(t)
+	ioutil.WriteFile(path, []byte(`{"users": [{"depositAddress": "aaa"}]}`), 0600)
+	ioutil.WriteFile(path+".ledger", []byte(`[{"id":"sweep","debit":"house:bbb","credit":"user:aaa","amount":"2"}]
+[{"id":"one","debit":"ho`), 0600)
+	state, _ := ioutil.ReadFile(path)
+	ledger, _ := ioutil.ReadFile(path + ".ledger")
+
+	fs, err := OpenFileStoreReadOnly(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	balance, _ := fs.LedgerBalance("house:bbb")
+	users, _ := fs.Users()
+	assert.Equal(t, 2*clientlib.Coin, balance)
+	assert.Equal(t, 1, len(users))
+
+	assert.Equal(t, ErrReadOnlyStore, fs.AddUser(MixerUser{DepositAddress: "ccc"}))
+	assert.Equal(t, ErrReadOnlyStore, fs.PostLedgerEntries(LedgerEntry{ID: "two", Debit: "house:bbb", Credit: "user:ccc", Amount: clientlib.Coin}))
+	assert.Equal(t, ErrReadOnlyStore, fs.CheckWritable())
+	assert.Nil(t, fs.Close())
+
+	stateAfter, _ := ioutil.ReadFile(path)
+	ledgerAfter, _ := ioutil.ReadFile(path + ".ledger")
+	_, deadLettersErr := os.Stat(path + ".dead-letters")
+	assert.Equal(t, string(state), string(stateAfter))
+	assert.Equal(t, string(ledger), string(ledgerAfter))
+	assert.True(t, os.IsNotExist(deadLettersErr))
+}
+
+func TestOpenFileStoreReadOnly_IncludesChangesNotYetInStateFile(t *testing.T) {
+	fs, path := newTestFileStore(t)
+	fs.AddUser(MixerUser{DepositAddress: "aaa", RegisteredAt: testRegisteredAt})
+
+	readOnly, err := OpenFileStoreReadOnly(path)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	users, _ := readOnly.Users()
+	assert.Equal(t, 1, len(users))
+}
+
+func TestOpenFileStoreReadOnly_ReturnsErrorIfStateFileMissing(t *testing.T) {
+	_, path := newTestFileStore(t)
+
+	_, err := OpenFileStoreReadOnly(path + ".missing")
+	if err == nil {
+		t.Errorf("Expected error to be returned but it was not.")
+	}
+	_, statErr := os.Stat(path + ".missing")
+	assert.True(t, os.IsNotExist(statErr))
+}
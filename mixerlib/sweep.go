@@ -0,0 +1,209 @@
+package mixerlib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+)
+
+// Sweep is a journal entry for moving a user's deposit to the house. It is
+// saved before any Jobcoin is sent and each of its two legs, the fee to the
+// bank fund and the remainder to the house, is marked once it is known to have
+// been sent. A sweep interrupted by an error or a restart is finished from the
+// journal rather than started again on whatever balance is left, so the fee is
+// never charged twice and no leg is sent twice.
+type Sweep struct {
+	DepositAddress string           `json:"depositAddress"`
+	Balance        clientlib.Amount `json:"balance"`
+	Fee            clientlib.Amount `json:"fee"`
+	HouseAmount    clientlib.Amount `json:"houseAmount"`
+	BankFund       string           `json:"bankFund"`
+	HouseAddress   string           `json:"houseAddress"`
+	// PriorTxCount is the number of transactions the deposit address had when
+	// the sweep was started. Only transactions after these can be part of it.
+	PriorTxCount int       `json:"priorTxCount"`
+	FeeSent      bool      `json:"feeSent"`
+	HouseSent    bool      `json:"houseSent"`
+	StartedAt    time.Time `json:"startedAt"`
+}
+
+// RecoverSweeps finishes any sweep left unfinished by a previous run. Each
+// deposit address's transaction history is checked first so that legs which
+// were sent before the restart are not sent again. Users whose funds reach
+// the house are added to the house queue.
+func (ml *MixerLib) RecoverSweeps() error {
+	sweeps, err := ml.Store.Sweeps()
+	if err != nil {
+		return err
+	}
+
+	for _, sweep := range sweeps {
+		user, err := ml.Store.User(sweep.DepositAddress)
+		if err != nil {
+			return err
+		}
+
+		ml.userLogger(user.DepositAddress).Info("Recovering unfinished sweep")
+		sentToHouse, err := ml.transferDepositToHouse(user)
+		if err != nil {
+			ml.userLogger(user.DepositAddress).Error("Failed to recover sweep", "error", err)
+			continue
+		}
+		if sentToHouse {
+			ml.addToHouseQueue(user)
+		}
+	}
+	return nil
+}
+
+// startSweep records the intent to sweep the deposit address's current
+// balance to the house, less fee.
+func (ml *MixerLib) startSweep(info clientlib.JobcoinAddressInfo, depositAddress string, fee clientlib.Amount) (Sweep, error) {
+	sweep := Sweep{
+		DepositAddress: depositAddress,
+		Balance:        info.Balance,
+		Fee:            fee,
+		HouseAmount:    info.Balance - fee,
+		BankFund:       ml.House.BankFund,
+		HouseAddress:   ml.randomHouse(),
+		PriorTxCount:   len(info.Transactions),
+		FeeSent:        fee == 0,
+		StartedAt:      time.Now(),
+	}
+
+	err := ml.Store.SaveSweep(sweep)
+	if err != nil {
+		return Sweep{}, err
+	}
+	ml.Metrics.depositDetected()
+	ml.publish(Event{Kind: EventDepositDetected, DepositAddress: depositAddress, Amount: info.Balance})
+	ml.recordProgress(depositAddress, func(p *DistributionProgress) {
+		p.State = StateReceived
+		p.Held = 0
+		p.HoldReason = ""
+	})
+
+	return sweep, nil
+}
+
+// reconcileSweep marks any leg of the sweep found in the deposit address's
+// transaction history as sent.
+func (ml *MixerLib) reconcileSweep(sweep Sweep, info clientlib.JobcoinAddressInfo) (Sweep, error) {
+	feeSent, houseSent := sweep.FeeSent, sweep.HouseSent
+
+	if sweep.PriorTxCount < len(info.Transactions) {
+		for _, tx := range info.Transactions[sweep.PriorTxCount:] {
+			if tx.FromAddress != sweep.DepositAddress {
+				continue
+			}
+			if tx.ToAddress == sweep.BankFund && tx.Amount == sweep.Fee {
+				feeSent = true
+			} else if tx.ToAddress == sweep.HouseAddress && tx.Amount == sweep.HouseAmount {
+				houseSent = true
+			}
+		}
+	}
+
+	return ml.markSweep(sweep, feeSent, houseSent)
+}
+
+// retargetSweep points a sweep whose house leg has not been sent at a current
+// house address if the one it was started with has since been rotated out.
+// Otherwise the leg would land in an address whose funds have already been
+// migrated, where no payout would ever use them.
+func (ml *MixerLib) retargetSweep(sweep Sweep) (Sweep, error) {
+	if sweep.HouseSent || !ml.House.Retired(sweep.HouseAddress) {
+		return sweep, nil
+	}
+
+	retired := sweep.HouseAddress
+	sweep.HouseAddress = ml.randomHouse()
+	err := ml.Store.SaveSweep(sweep)
+	if err != nil {
+		return sweep, err
+	}
+	ml.userLogger(sweep.DepositAddress).Info("Moved unfinished sweep off retired house address", "from", retired, "to", sweep.HouseAddress)
+	return sweep, nil
+}
+
+// finishSweep sends whichever legs of the sweep have not been sent yet.
+func (ml *MixerLib) finishSweep(sweep Sweep) error {
+	var err error
+	if !sweep.FeeSent {
+		err = ml.JobcoinClient.SendJobcoin(sweep.DepositAddress, sweep.BankFund, sweep.Fee)
+		if err != nil {
+			return err
+		}
+		sweep, err = ml.markSweep(sweep, true, sweep.HouseSent)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !sweep.HouseSent {
+		err = ml.JobcoinClient.SendJobcoin(sweep.DepositAddress, sweep.HouseAddress, sweep.HouseAmount)
+		if err != nil {
+			return err
+		}
+		_, err = ml.markSweep(sweep, true, true)
+	}
+	return err
+}
+
+// markSweep saves the sweep with the given legs marked as sent, and records
+// each newly sent leg in the user's progress. The house leg is credited to the
+// user in the ledger before the sweep is saved, under an ID unique to the sweep
+// so that it is only credited once even if saving the sweep fails. The sweep
+// is removed from the journal once both legs are sent.
+func (ml *MixerLib) markSweep(sweep Sweep, feeSent, houseSent bool) (Sweep, error) {
+	newlyFeeSent := feeSent && !sweep.FeeSent
+	newlyHouseSent := houseSent && !sweep.HouseSent
+	if !newlyFeeSent && !newlyHouseSent {
+		return sweep, nil
+	}
+
+	var err error
+	if newlyHouseSent {
+		err = ml.postLedger(LedgerEntry{
+			ID:             fmt.Sprintf("sweep:%s:%d", sweep.DepositAddress, sweep.StartedAt.UnixNano()),
+			Debit:          houseLedgerAccount(sweep.HouseAddress),
+			Credit:         userLedgerAccount(sweep.DepositAddress),
+			Amount:         sweep.HouseAmount,
+			DepositAddress: sweep.DepositAddress,
+			Memo:           "sweep",
+		})
+		if err != nil {
+			return sweep, err
+		}
+	}
+
+	sweep.FeeSent = sweep.FeeSent || feeSent
+	sweep.HouseSent = sweep.HouseSent || houseSent
+
+	if sweep.FeeSent && sweep.HouseSent {
+		err = ml.Store.RemoveSweep(sweep.DepositAddress)
+	} else {
+		err = ml.Store.SaveSweep(sweep)
+	}
+	if err != nil {
+		return sweep, err
+	}
+
+	if newlyFeeSent {
+		ml.Metrics.feeCollected(sweep.Fee)
+		ml.publish(Event{Kind: EventFeeCharged, DepositAddress: sweep.DepositAddress, Amount: sweep.Fee})
+		ml.recordProgress(sweep.DepositAddress, func(p *DistributionProgress) {
+			p.Fee = p.Fee + sweep.Fee
+		})
+	}
+	if newlyHouseSent {
+		ml.Metrics.swept(sweep.HouseAmount)
+		ml.publish(Event{Kind: EventSwept, DepositAddress: sweep.DepositAddress, Amount: sweep.HouseAmount})
+		ml.recordProgress(sweep.DepositAddress, func(p *DistributionProgress) {
+			p.Deposited = p.Deposited + sweep.Balance
+			p.State = StateInHouse
+		})
+	}
+	return sweep, nil
+}
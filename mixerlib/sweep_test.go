@@ -0,0 +1,206 @@
+package mixerlib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+// Begin transferDepositToHouse sweep journal tests
+func TestTransferDepositToHouse_JournalsSweepBeforeSending(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	mockAddressInfo := clientlib.JobcoinAddressInfo{
+		Balance: 10 * clientlib.Coin,
+	}
+	jobcoinMock := newJobcoinMock(mockAddressInfo, nil, errors.New("SendJobcoin failed"))
+	ml := newTestMixerLib(jobcoinMock)
+
+	ml.transferDepositToHouse(user)
+
+	sweep, pending, err := ml.Store.Sweep(user.DepositAddress)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.True(t, pending)
+	assert.Equal(t, 10*clientlib.Coin, sweep.Balance)
+	assert.Equal(t, clientlib.MustParseAmount("0.1"), sweep.Fee)
+	assert.Equal(t, clientlib.MustParseAmount("9.9"), sweep.HouseAmount)
+	assert.False(t, sweep.FeeSent)
+	assert.False(t, sweep.HouseSent)
+}
+
+// failingSweepStore is a Store that cannot journal sweeps.
+type failingSweepStore struct {
+	Store
+}
+
+func (s failingSweepStore) SaveSweep(sweep Sweep) error {
+	return errors.New("disk full")
+}
+
+func TestTransferDepositToHouse_SendsNothingIfSweepCannotBeJournalled(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: 10 * clientlib.Coin}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Store = failingSweepStore{ml.Store}
+
+	sentToHouse, err := ml.transferDepositToHouse(user)
+
+	assert.False(t, sentToHouse)
+	assert.EqualError(t, err, "disk full")
+	assert.Empty(t, jobcoinMock.Sent)
+}
+
+func TestTransferDepositToHouse_FinishesInterruptedSweepWithoutChargingFeeAgain(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{
+		Balance: 10 * clientlib.Coin,
+	}, nil, errors.New("connection reset")).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+
+	ml.transferDepositToHouse(user)
+
+	// The fee was created even though the client saw an error.
+	jobcoinMock.SendError = nil
+	jobcoinMock.AddressInfo = clientlib.JobcoinAddressInfo{
+		Balance: clientlib.MustParseAmount("9.9"),
+		Transactions: []clientlib.JobcoinTx{
+			{FromAddress: user.DepositAddress, ToAddress: testBankFund, Amount: clientlib.MustParseAmount("0.1")},
+		},
+	}
+
+	sentToHouse, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedTxs := []clientlib.JobcoinTx{
+		{FromAddress: user.DepositAddress, ToAddress: testHouseAddress, Amount: clientlib.MustParseAmount("9.9")},
+	}
+	_, pending, _ := ml.Store.Sweep(user.DepositAddress)
+	progress, _ := ml.Store.Progress(user.DepositAddress)
+
+	assert.True(t, sentToHouse)
+	assert.Equal(t, expectedTxs, jobcoinMock.Sent)
+	assert.False(t, pending)
+	assert.Equal(t, clientlib.MustParseAmount("0.1"), progress.Fee)
+	assert.Equal(t, 10*clientlib.Coin, progress.Deposited)
+}
+
+func TestTransferDepositToHouse_DoesNotResendLegsFoundInHistory(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Store.SaveSweep(Sweep{
+		DepositAddress: user.DepositAddress,
+		Balance:        10 * clientlib.Coin,
+		Fee:            clientlib.MustParseAmount("0.1"),
+		HouseAmount:    clientlib.MustParseAmount("9.9"),
+		BankFund:       testBankFund,
+		HouseAddress:   testHouseAddress,
+		PriorTxCount:   1,
+	})
+
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{
+		Transactions: []clientlib.JobcoinTx{
+			{ToAddress: user.DepositAddress, Amount: 10 * clientlib.Coin},
+			{FromAddress: user.DepositAddress, ToAddress: testBankFund, Amount: clientlib.MustParseAmount("0.1")},
+			{FromAddress: user.DepositAddress, ToAddress: testHouseAddress, Amount: clientlib.MustParseAmount("9.9")},
+		},
+	}, nil, nil).(*mockJobcoinClient)
+	ml.JobcoinClient = jobcoinMock
+
+	sentToHouse, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	_, pending, _ := ml.Store.Sweep(user.DepositAddress)
+
+	assert.True(t, sentToHouse)
+	assert.Equal(t, 0, len(jobcoinMock.Sent))
+	assert.False(t, pending)
+}
+
+func TestTransferDepositToHouse_SendsUnfinishedSweepToCurrentHouseAfterRotation(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{Balance: clientlib.MustParseAmount("9.9")}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.House.Rotations = []HouseRotation{{PreviousAddress: "old-house", NewAddress: testHouseAddress, Migrated: true}}
+	ml.Store.SaveSweep(Sweep{
+		DepositAddress: user.DepositAddress,
+		Balance:        10 * clientlib.Coin,
+		Fee:            clientlib.MustParseAmount("0.1"),
+		HouseAmount:    clientlib.MustParseAmount("9.9"),
+		BankFund:       testBankFund,
+		HouseAddress:   "old-house",
+		FeeSent:        true,
+	})
+
+	sentToHouse, err := ml.transferDepositToHouse(user)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	expectedTxs := []clientlib.JobcoinTx{
+		{FromAddress: user.DepositAddress, ToAddress: testHouseAddress, Amount: clientlib.MustParseAmount("9.9")},
+	}
+	balance, _ := ml.Store.LedgerBalance(houseLedgerAccount(testHouseAddress))
+	assert.True(t, sentToHouse)
+	assert.Equal(t, expectedTxs, jobcoinMock.Sent)
+	assert.Equal(t, clientlib.MustParseAmount("9.9"), balance)
+}
+
+func TestTransferDepositToHouse_IgnoresMatchingTransactionsFromBeforeSweep(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	previousFee := clientlib.JobcoinTx{FromAddress: user.DepositAddress, ToAddress: testBankFund, Amount: clientlib.MustParseAmount("0.1")}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{
+		Balance:      10 * clientlib.Coin,
+		Transactions: []clientlib.JobcoinTx{previousFee},
+	}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	sweep, _ := ml.startSweep(jobcoinMock.AddressInfo, user.DepositAddress, previousFee.Amount)
+
+	sweep, err := ml.reconcileSweep(sweep, jobcoinMock.AddressInfo)
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	assert.False(t, sweep.FeeSent)
+}
+
+// Begin RecoverSweeps tests
+func TestRecoverSweeps_FinishesSweepsAndQueuesUsers(t *testing.T) {
+	user := MixerUser{DepositAddress: "1234abcd"}
+	jobcoinMock := newJobcoinMock(clientlib.JobcoinAddressInfo{
+		Balance: clientlib.MustParseAmount("9.9"),
+		Transactions: []clientlib.JobcoinTx{
+			{FromAddress: user.DepositAddress, ToAddress: testBankFund, Amount: clientlib.MustParseAmount("0.1")},
+		},
+	}, nil, nil).(*mockJobcoinClient)
+	ml := newTestMixerLib(jobcoinMock)
+	ml.Store.AddUser(user)
+	ml.Store.SaveSweep(Sweep{
+		DepositAddress: user.DepositAddress,
+		Balance:        10 * clientlib.Coin,
+		Fee:            clientlib.MustParseAmount("0.1"),
+		HouseAmount:    clientlib.MustParseAmount("9.9"),
+		BankFund:       testBankFund,
+		HouseAddress:   testHouseAddress,
+	})
+
+	err := ml.RecoverSweeps()
+	if err != nil {
+		t.Errorf("Did not expect error. Got: %s", err.Error())
+	}
+
+	sweeps, _ := ml.Store.Sweeps()
+	houseQueue, _ := ml.Store.HouseQueue()
+
+	assert.Equal(t, 1, len(jobcoinMock.Sent))
+	assert.Equal(t, testHouseAddress, jobcoinMock.Sent[0].ToAddress)
+	assert.Equal(t, 0, len(sweeps))
+	assert.Equal(t, []MixerUser{user}, houseQueue)
+}
@@ -0,0 +1,311 @@
+package mixerlib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/google/uuid"
+)
+
+// The headers sent with every webhook request.
+const (
+	// WebhookIDHeader identifies the delivery. It stays the same across
+	// retries, so receivers can use it to ignore repeats.
+	WebhookIDHeader = "X-Mixer-Delivery"
+	// WebhookEventHeader is the kind of the event in the body.
+	WebhookEventHeader = "X-Mixer-Event"
+	// WebhookTimestampHeader is when the request was signed, in Unix seconds.
+	WebhookTimestampHeader = "X-Mixer-Timestamp"
+	// WebhookSignatureHeader is the signature of the request, see SignWebhook.
+	WebhookSignatureHeader = "X-Mixer-Signature"
+)
+
+var (
+	// ErrWebhooksDisabled is returned when a user registers a webhook URL with
+	// a mixer that has no webhook secret configured.
+	ErrWebhooksDisabled = errors.New("Webhooks are not enabled on this mixer")
+	// ErrInvalidWebhookURL is returned when a user registers a webhook URL
+	// that is not an absolute http or https URL.
+	ErrInvalidWebhookURL = errors.New("Webhook URL must be an absolute http or https URL")
+	// ErrPrivateWebhookHost is returned when a user registers a webhook URL,
+	// or a delivery is made to a host, that is a loopback, link-local or
+	// private address.
+	ErrPrivateWebhookHost = errors.New("Webhook URL must not point at a loopback, link-local or private address")
+)
+
+// webhookQueueSize is how many deliveries can wait to be sent before further
+// deliveries go straight to the dead letters.
+const webhookQueueSize = 1024
+
+// webhookWorkers is how many deliveries are sent at the same time.
+const webhookWorkers = 4
+
+// WebhookDelivery is an event being sent to a user's webhook URL. Deliveries
+// that could not be made are kept in the Store as dead letters.
+type WebhookDelivery struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Event     Event     `json:"event"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError,omitempty"`
+	FailedAt  time.Time `json:"failedAt,omitempty"`
+}
+
+// SignWebhook returns the value of WebhookSignatureHeader for a request with
+// the given timestamp and body: "sha256=" followed by the hex encoded
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with secret.
+// Receivers should compute the same and compare it in constant time, and
+// reject old timestamps to stop requests being replayed.
+func SignWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidWebhookURL checks that a user's webhook URL can be delivered to. Unless
+// allowPrivate is set, URLs for localhost or a loopback, link-local or private
+// IP address are refused. Host names that resolve to one are refused by the
+// client from NewWebhookClient instead, when a delivery is made.
+func ValidWebhookURL(webhookURL string, allowPrivate bool) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	if allowPrivate {
+		return nil
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(parsed.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateWebhookHost
+	}
+	if ip := net.ParseIP(host); ip != nil && privateIP(ip) {
+		return ErrPrivateWebhookHost
+	}
+	return nil
+}
+
+// privateIP reports whether ip is an address webhooks must not be sent to:
+// unspecified, loopback, link-local, multicast or private.
+func privateIP(ip net.IP) bool {
+	return ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsPrivate()
+}
+
+// NewWebhookClient returns an HTTP client for sending webhooks that gives up
+// on a request after timeout. Unless allowPrivate is set, it refuses to connect
+// to any address privateIP matches. The address is checked once the host name
+// has been resolved, so names that resolve to such an address, including on
+// a redirect, are refused too. Proxies from the environment are not used, as
+// they would connect on the client's behalf.
+func NewWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || privateIP(ip) {
+				return ErrPrivateWebhookHost
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// Webhooks sends the events for each user with a webhook URL to that URL as
+// a signed JSON POST. A delivery is retried following Retry for as long as the
+// URL does not respond with a 2xx status, and is saved to Store as a dead
+// letter once it runs out of attempts. Nothing is sent until Run is called.
+// A nil *Webhooks sends nothing, so MixerLib works without one.
+type Webhooks struct {
+	Client clientlib.HTTPClient
+	Secret []byte
+	Retry  clientlib.RetryPolicy
+	Store  Store
+	Logger *slog.Logger
+
+	queue chan WebhookDelivery
+	sleep func(ctx context.Context, d time.Duration) bool
+}
+
+// NewWebhooks returns Webhooks signing requests with secret and sending
+// them with client.
+func NewWebhooks(client clientlib.HTTPClient, secret []byte, retry clientlib.RetryPolicy, store Store) *Webhooks {
+	return &Webhooks{
+		Client: client,
+		Secret: secret,
+		Retry:  retry,
+		Store:  store,
+		queue:  make(chan WebhookDelivery, webhookQueueSize),
+		sleep:  sleepContext,
+	}
+}
+
+func (wh *Webhooks) logger() *slog.Logger {
+	if wh.Logger == nil {
+		return slog.Default()
+	}
+	return wh.Logger
+}
+
+// Run sends deliveries until ctx is cancelled. Deliveries still waiting to
+// be sent or retried then are saved as dead letters, so none are lost.
+func (wh *Webhooks) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := 0; i < webhookWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-wh.queue:
+					wh.deliver(ctx, delivery)
+				}
+			}
+		}()
+	}
+	workers.Wait()
+
+	for {
+		select {
+		case delivery := <-wh.queue:
+			delivery.LastError = "Not sent before shutdown"
+			wh.deadLetter(delivery)
+		default:
+			return
+		}
+	}
+}
+
+// notify queues event for delivery if its user has a webhook URL, without
+// waiting for it to be sent.
+func (wh *Webhooks) notify(event Event) {
+	if wh == nil {
+		return
+	}
+
+	user, err := wh.Store.User(event.DepositAddress)
+	if err != nil {
+		wh.logger().Error("Failed to look up webhook URL", "depositAddress", event.DepositAddress, "error", err)
+		return
+	}
+	if user.WebhookURL == "" {
+		return
+	}
+
+	delivery := WebhookDelivery{ID: uuid.New().String(), URL: user.WebhookURL, Event: event}
+	select {
+	case wh.queue <- delivery:
+	default:
+		delivery.LastError = "Too many webhooks waiting to be sent"
+		wh.deadLetter(delivery)
+	}
+}
+
+// deliver sends delivery until it succeeds, runs out of attempts or ctx is
+// cancelled, and saves it as a dead letter if it did not succeed.
+func (wh *Webhooks) deliver(ctx context.Context, delivery WebhookDelivery) {
+	logger := wh.logger().With("depositAddress", delivery.Event.DepositAddress, "deliveryId", delivery.ID)
+
+	for delivery.Attempts < wh.Retry.Attempts() {
+		if delivery.Attempts > 0 && !wh.sleep(ctx, wh.Retry.Backoff(delivery.Attempts)) {
+			break
+		}
+
+		delivery.Attempts++
+		err := wh.send(ctx, delivery)
+		if err == nil {
+			logger.Debug("Sent webhook", "event", delivery.Event.Kind, "attempts", delivery.Attempts)
+			return
+		}
+		delivery.LastError = err.Error()
+		logger.Warn("Failed to send webhook", "event", delivery.Event.Kind, "attempt", delivery.Attempts, "error", err)
+	}
+
+	wh.deadLetter(delivery)
+}
+
+// send makes a single attempt at delivery.
+func (wh *Webhooks) send(ctx context.Context, delivery WebhookDelivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, delivery.ID)
+	req.Header.Set(WebhookEventHeader, string(delivery.Event.Kind))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(wh.Secret, timestamp, body))
+
+	res, err := wh.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook URL responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (wh *Webhooks) deadLetter(delivery WebhookDelivery) {
+	delivery.FailedAt = time.Now()
+	logger := wh.logger().With("depositAddress", delivery.Event.DepositAddress, "deliveryId", delivery.ID)
+	logger.Error("Gave up sending webhook", "event", delivery.Event.Kind, "attempts", delivery.Attempts, "error", delivery.LastError)
+
+	err := wh.Store.AddWebhookDeadLetter(delivery)
+	if err != nil {
+		logger.Error("Failed to save webhook dead letter", "error", err)
+	}
+}
+
+// sleepContext waits for d and returns true, or returns false as soon as ctx
+// is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
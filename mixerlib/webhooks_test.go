@@ -0,0 +1,251 @@
+package mixerlib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ckaminer/jobcoin/clientlib"
+	"github.com/stretchr/testify/assert"
+)
+
+var testWebhookSecret = []byte("webhook-secret")
+
+// webhookReceiver is a local webhook URL that records the events it receives.
+// It responds with a 500 to the first failures requests.
+type webhookReceiver struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	failures int
+	received receivedWebhooks
+}
+
+// receivedWebhooks is what a webhookReceiver has received so far.
+type receivedWebhooks struct {
+	requests      int
+	badSignatures int
+	events        []Event
+	ids           []string
+}
+
+func newWebhookReceiver(t *testing.T, failures int) *webhookReceiver {
+	wr := &webhookReceiver{failures: failures}
+	wr.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		wr.mu.Lock()
+		defer wr.mu.Unlock()
+		wr.received.requests++
+		wr.received.ids = append(wr.received.ids, r.Header.Get(WebhookIDHeader))
+		if SignWebhook(testWebhookSecret, r.Header.Get(WebhookTimestampHeader), body) != r.Header.Get(WebhookSignatureHeader) {
+			wr.received.badSignatures++
+		}
+		if wr.received.requests <= wr.failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var event Event
+		json.Unmarshal(body, &event)
+		wr.received.events = append(wr.received.events, event)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(wr.server.Close)
+	return wr
+}
+
+func (wr *webhookReceiver) snapshot() receivedWebhooks {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	received := wr.received
+	received.events = append([]Event{}, received.events...)
+	received.ids = append([]string{}, received.ids...)
+	return received
+}
+
+// newTestWebhooks returns Webhooks that do not wait between attempts, and
+// the store holding a user with the receiver's URL.
+func newTestWebhooks(receiver *webhookReceiver, maxAttempts int) (*Webhooks, Store) {
+	store := NewMemoryStore()
+	store.AddUser(MixerUser{DepositAddress: "1234abcd", ReturnAddresses: []string{"1111aaaa"}, WebhookURL: receiver.server.URL})
+
+	wh := NewWebhooks(http.DefaultClient, testWebhookSecret, clientlib.RetryPolicy{MaxAttempts: maxAttempts}, store)
+	wh.sleep = func(ctx context.Context, d time.Duration) bool {
+		return ctx.Err() == nil
+	}
+	return wh, store
+}
+
+// Begin Webhooks tests
+func TestSignWebhook_SignsTimestampAndBody(t *testing.T) {
+	signature := SignWebhook([]byte("key"), "1600000000", []byte(`{"kind":"complete"}`))
+
+	assert.Equal(t, "sha256=", signature[:7])
+	assert.Equal(t, 7+64, len(signature))
+	assert.NotEqual(t, signature, SignWebhook([]byte("key"), "1600000001", []byte(`{"kind":"complete"}`)))
+	assert.NotEqual(t, signature, SignWebhook([]byte("other"), "1600000000", []byte(`{"kind":"complete"}`)))
+}
+
+func TestValidWebhookURL_OnlyAcceptsAbsoluteHTTPURLs(t *testing.T) {
+	assert.Nil(t, ValidWebhookURL("https://example.com/hooks/mixer", false))
+	assert.Nil(t, ValidWebhookURL("http://93.184.216.34:9000", false))
+	assert.Equal(t, ErrInvalidWebhookURL, ValidWebhookURL("ftp://example.com", false))
+	assert.Equal(t, ErrInvalidWebhookURL, ValidWebhookURL("/hooks/mixer", false))
+	assert.Equal(t, ErrInvalidWebhookURL, ValidWebhookURL("https://", false))
+}
+
+func TestValidWebhookURL_RefusesPrivateHostsUnlessAllowed(t *testing.T) {
+	for _, webhookURL := range []string{
+		"http://localhost:9000",
+		"http://LocalHost./hooks",
+		"http://127.0.0.1/hooks",
+		"http://[::1]/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1/hooks",
+		"http://192.168.1.20/hooks",
+		"http://[fd00::1]/hooks",
+		"http://0.0.0.0/hooks",
+		"http://[::ffff:127.0.0.1]/hooks",
+	} {
+		assert.Equal(t, ErrPrivateWebhookHost, ValidWebhookURL(webhookURL, false), webhookURL)
+		assert.Nil(t, ValidWebhookURL(webhookURL, true), webhookURL)
+	}
+}
+
+func TestNewWebhookClient_RefusesToConnectToPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewWebhookClient(time.Second, false).Get(server.URL)
+	assert.True(t, errors.Is(err, ErrPrivateWebhookHost))
+
+	res, err := NewWebhookClient(time.Second, true).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Did not expect error. Got: %s", err.Error())
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestDeliver_SendsSignedEvent(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	wh, store := newTestWebhooks(receiver, 3)
+
+	wh.notify(Event{Kind: EventPayoutSent, DepositAddress: "1234abcd", Amount: clientlib.Coin, ToAddress: "1111aaaa"})
+	wh.deliver(context.Background(), <-wh.queue)
+
+	received := receiver.snapshot()
+	deadLetters, _ := store.WebhookDeadLetters()
+
+	assert.Equal(t, 1, received.requests)
+	assert.Equal(t, 0, received.badSignatures)
+	assert.Equal(t, EventPayoutSent, received.events[0].Kind)
+	assert.Equal(t, clientlib.Coin, received.events[0].Amount)
+	assert.Equal(t, "1111aaaa", received.events[0].ToAddress)
+	assert.Empty(t, deadLetters)
+}
+
+func TestDeliver_RetriesWithBackoffUntilSuccessful(t *testing.T) {
+	receiver := newWebhookReceiver(t, 2)
+	wh, store := newTestWebhooks(receiver, 3)
+	wh.Retry.InitialBackoff = time.Second
+	wh.Retry.MaxBackoff = time.Minute
+	waits := []time.Duration{}
+	wh.sleep = func(ctx context.Context, d time.Duration) bool {
+		waits = append(waits, d)
+		return true
+	}
+
+	wh.notify(Event{Kind: EventComplete, DepositAddress: "1234abcd"})
+	wh.deliver(context.Background(), <-wh.queue)
+
+	received := receiver.snapshot()
+	deadLetters, _ := store.WebhookDeadLetters()
+
+	assert.Equal(t, 3, received.requests)
+	assert.Equal(t, 1, len(received.events))
+	assert.Equal(t, 2, len(waits))
+	assert.True(t, waits[1] <= 2*time.Second)
+	assert.Equal(t, received.ids[0], received.ids[2])
+	assert.Empty(t, deadLetters)
+}
+
+func TestDeliver_SavesDeadLetterOnceAttemptsRunOut(t *testing.T) {
+	receiver := newWebhookReceiver(t, 10)
+	wh, store := newTestWebhooks(receiver, 3)
+
+	wh.notify(Event{Kind: EventError, DepositAddress: "1234abcd", Message: "Failed to send payout, it will be retried"})
+	wh.deliver(context.Background(), <-wh.queue)
+
+	received := receiver.snapshot()
+	deadLetters, _ := store.WebhookDeadLetters()
+
+	assert.Equal(t, 3, received.requests)
+	assert.Equal(t, 1, len(deadLetters))
+	assert.Equal(t, 3, deadLetters[0].Attempts)
+	assert.Equal(t, receiver.server.URL, deadLetters[0].URL)
+	assert.Equal(t, EventError, deadLetters[0].Event.Kind)
+	assert.Equal(t, "webhook URL responded with status 500", deadLetters[0].LastError)
+	assert.False(t, deadLetters[0].FailedAt.IsZero())
+}
+
+func TestNotify_IgnoresUsersWithoutWebhookURL(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	wh, store := newTestWebhooks(receiver, 3)
+	store.AddUser(MixerUser{DepositAddress: "5678efgh"})
+
+	wh.notify(Event{Kind: EventComplete, DepositAddress: "5678efgh"})
+
+	assert.Equal(t, 0, len(wh.queue))
+}
+
+func TestNotify_SavesDeadLetterIfQueueIsFull(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	wh, store := newTestWebhooks(receiver, 3)
+	wh.queue = make(chan WebhookDelivery)
+
+	wh.notify(Event{Kind: EventComplete, DepositAddress: "1234abcd"})
+
+	deadLetters, _ := store.WebhookDeadLetters()
+	assert.Equal(t, 1, len(deadLetters))
+	assert.Equal(t, 0, deadLetters[0].Attempts)
+}
+
+func TestRun_SavesUnsentDeliveriesAsDeadLettersOnShutdown(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	wh, store := newTestWebhooks(receiver, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	wh.notify(Event{Kind: EventComplete, DepositAddress: "1234abcd"})
+	wh.Run(ctx)
+
+	deadLetters, _ := store.WebhookDeadLetters()
+	assert.Equal(t, 1, len(deadLetters))
+}
+
+func TestSendDuePayouts_SendsWebhook(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	ml := newTestMixerLib(newJobcoinMock(clientlib.JobcoinAddressInfo{}, nil, nil))
+	ml.Webhooks, ml.Store = newTestWebhooks(receiver, 3)
+	creditUser(ml, "1234abcd", 3*clientlib.Coin)
+	due := ScheduledPayout{ToAddress: "1111aaaa", Amount: clientlib.Coin, DueAt: time.Now().Add(-time.Second)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ml.Webhooks.Run(ctx)
+
+	user, _ := ml.Store.User("1234abcd")
+	ml.sendDuePayouts(user, []ScheduledPayout{due})
+
+	assert.Eventually(t, func() bool {
+		received := receiver.snapshot()
+		return len(received.events) == 1 && received.events[0].Kind == EventPayoutSent
+	}, 5*time.Second, 10*time.Millisecond)
+}